@@ -0,0 +1,196 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event 事件总线上的事件，同一 EventName 对应同一种载荷类型
+type Event interface {
+	EventName() string
+}
+
+// Delivery 事件的投递方式
+type Delivery int
+
+const (
+	// InProcess 在发布事件的进程内异步处理，不经过 Redis，进程退出或队列已满时事件丢失
+	InProcess Delivery = iota
+	// AtLeastOnce 通过 Redis Streams 投递，每个订阅者在所有实例中只由一个实例处理
+	// 处理失败或实例崩溃时会重新投递，订阅者需要能够处理重复的事件
+	AtLeastOnce
+	// Broadcast 通过 Redis Streams 投递，每个实例各自处理一次，用于维护实例本地的状态
+	// 每个实例使用以 config.InstanceId 区分的消费者组，重启后从上次确认的位置继续处理
+	// 实例 id 需要在重启后保持不变，否则旧的消费者组会一直留在 stream 上
+	Broadcast
+)
+
+type subscriber struct {
+	name     string // 订阅者名称
+	group    string // Redis Streams 的消费者组名，AtLeastOnce 为订阅者名称，Broadcast 再加上实例 id
+	event    string
+	delivery Delivery
+	handle   func(ctx context.Context, data []byte) error
+	queue    chan []byte // 只用于 InProcess
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[string][]*subscriber)
+)
+
+// Subscribe 注册订阅者，需要在 Run 之前调用
+// 同一订阅者可以订阅多种事件，name 在同一种事件的订阅者中必须唯一
+func Subscribe[T Event](name string, delivery Delivery, handler func(ctx context.Context, event T) error) {
+	var zero T
+	sub := &subscriber{
+		name:     name,
+		event:    zero.EventName(),
+		delivery: delivery,
+		handle: func(ctx context.Context, data []byte) error {
+			var event T
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			return handler(ctx, event)
+		},
+	}
+	switch delivery {
+	case InProcess:
+		sub.queue = make(chan []byte, config.EventBusQueueSize)
+	case AtLeastOnce:
+		sub.group = name
+	case Broadcast:
+		sub.group = name + ":" + config.InstanceId
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers[sub.event] = append(subscribers[sub.event], sub)
+}
+
+// Publish 发布事件，不等待订阅者处理，失败只记录日志，不影响原操作
+// ctx 只用于写入 Redis，订阅者在后台处理，不受发布方的 ctx 影响
+func Publish(ctx context.Context, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	reliable := false
+	for _, sub := range subscribers[event.EventName()] {
+		if sub.delivery != InProcess {
+			reliable = true
+			continue
+		}
+		select {
+		case sub.queue <- data:
+		default:
+			log.Println("事件队列已满，丢弃事件：" + sub.name + " " + event.EventName())
+		}
+	}
+	// 同一事件的所有 AtLeastOnce 和 Broadcast 订阅者共用一个 stream，各自通过消费者组读取
+	if reliable {
+		if err := cache.AppendBusEvent(ctx, event.EventName(), data); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Run 为每个订阅者启动处理协程，AtLeastOnce 和 Broadcast 的订阅者先创建消费者组
+// 需要在开始处理请求之前调用，消费者组只接收创建之后发布的事件
+func Run() error {
+	ctx := context.Background()
+	consumer := consumerName()
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, subList := range subscribers {
+		for _, sub := range subList {
+			if sub.delivery == InProcess {
+				go sub.runInProcess(ctx)
+				continue
+			}
+			if sub.delivery == Broadcast && config.InstanceId == "" {
+				return errors.New("未设置实例 id，需要通过 DOUYIN_INSTANCE 设置固定的实例 id")
+			}
+			if err := cache.CreateBusGroup(ctx, sub.event, sub.group); err != nil {
+				return err
+			}
+			go sub.runStream(ctx, consumer)
+		}
+	}
+	return nil
+}
+
+// consumerName 当前实例在消费者组中的名称
+func consumerName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return hostname + "-" + strconv.Itoa(os.Getpid())
+}
+
+func (sub *subscriber) runInProcess(ctx context.Context) {
+	for data := range sub.queue {
+		if err := sub.handle(ctx, data); err != nil {
+			log.Println(sub.name, sub.event, err)
+		}
+	}
+}
+
+// runStream 循环处理 stream 中的事件，每轮先重新投递超时未确认的事件，再读取新事件
+func (sub *subscriber) runStream(ctx context.Context, consumer string) {
+	for {
+		claimList, err := cache.ClaimBusEvents(ctx, sub.event, sub.group, consumer)
+		if err != nil {
+			log.Println(err)
+			time.Sleep(config.EventBusBlock)
+			continue
+		}
+		for _, message := range claimList {
+			sub.deliver(ctx, message)
+		}
+		messageList, err := cache.ReadBusEvents(ctx, sub.event, sub.group, consumer)
+		if err != nil {
+			log.Println(err)
+			time.Sleep(config.EventBusBlock)
+			continue
+		}
+		for _, message := range messageList {
+			sub.deliver(ctx, message)
+		}
+	}
+}
+
+// deliver 处理成功后确认事件，失败时不确认，等待 EventBusRetryIdle 后重新投递
+// 投递次数达到 EventBusMaxRetries 后移入死信 stream
+func (sub *subscriber) deliver(ctx context.Context, message cache.BusMessage) {
+	err := sub.handle(ctx, message.Data)
+	if err == nil {
+		if err := cache.AckBusEvent(ctx, sub.event, sub.group, message.Id); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	log.Println(sub.name, sub.event, message.Id, err)
+	deliveries, err := cache.BusEventDeliveries(ctx, sub.event, sub.group, message.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if deliveries >= config.EventBusMaxRetries {
+		if err := cache.DeadBusEvent(ctx, sub.event, sub.group, message); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bus
+
+// 以下为用户操作产生的事件，字段只包含 id 等不会变化的信息，订阅者需要时再读取最新数据
+
+// UserRegistered 用户注册
+type UserRegistered struct {
+	UserId int64  `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+func (UserRegistered) EventName() string { return "user_registered" }
+
+// VideoPublished 视频发布，包括直接发布、草稿发布和定时发布
+type VideoPublished struct {
+	VideoId int64 `json:"video_id"`
+	UserId  int64 `json:"user_id"`
+}
+
+func (VideoPublished) EventName() string { return "video_published" }
+
+// VideoUpdated 视频信息修改或从回收站恢复
+type VideoUpdated struct {
+	VideoId int64 `json:"video_id"`
+	UserId  int64 `json:"user_id"`
+}
+
+func (VideoUpdated) EventName() string { return "video_updated" }
+
+// VideoDeleted 视频被移入回收站
+type VideoDeleted struct {
+	VideoId int64 `json:"video_id"`
+	UserId  int64 `json:"user_id"`
+}
+
+func (VideoDeleted) EventName() string { return "video_deleted" }
+
+// VideoFavorited 视频被点赞
+type VideoFavorited struct {
+	UserId  int64 `json:"user_id"`
+	VideoId int64 `json:"video_id"`
+}
+
+func (VideoFavorited) EventName() string { return "video_favorited" }
+
+// CommentAdded 发表评论或回复
+type CommentAdded struct {
+	CommentId int64 `json:"comment_id"`
+	VideoId   int64 `json:"video_id"`
+	UserId    int64 `json:"user_id"`
+}
+
+func (CommentAdded) EventName() string { return "comment_added" }
+
+// UserFollowed 关注用户
+type UserFollowed struct {
+	UserId   int64 `json:"user_id"`
+	ToUserId int64 `json:"to_user_id"`
+}
+
+func (UserFollowed) EventName() string { return "user_followed" }
+
+// MessageSent 发送私信
+type MessageSent struct {
+	MessageId  int64 `json:"message_id"`
+	FromUserId int64 `json:"from_user_id"`
+	ToUserId   int64 `json:"to_user_id"`
+}
+
+func (MessageSent) EventName() string { return "message_sent" }
+
+// ConversationRead 会话被标记为已读
+type ConversationRead struct {
+	UserId int64 `json:"user_id"`
+	PeerId int64 `json:"peer_id"`
+}
+
+func (ConversationRead) EventName() string { return "conversation_read" }
+
+// NotificationsRead 通知被标记为已读
+type NotificationsRead struct {
+	UserId int64 `json:"user_id"`
+}
+
+func (NotificationsRead) EventName() string { return "notifications_read" }
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+)
+
+// BusMessage 事件总线 stream 中的一条消息
+type BusMessage struct {
+	Id   string
+	Data []byte
+}
+
+// AppendBusEvent 将事件追加到事件的 stream 中，只保留最近 EventBusStreamSize 条
+func AppendBusEvent(ctx context.Context, eventName string, data []byte) error {
+	_, err := store.XAdd(ctx, BusStreamKey(eventName), config.EventBusStreamSize, map[string]interface{}{"data": data})
+	return err
+}
+
+// CreateBusGroup 为订阅者创建消费者组，只接收创建之后的事件，已存在时忽略
+func CreateBusGroup(ctx context.Context, eventName, group string) error {
+	return store.XGroupCreate(ctx, BusStreamKey(eventName), group)
+}
+
+// ReadBusEvents 以消费者组的方式读取新事件，最多阻塞 EventBusBlock，没有新事件时返回空
+func ReadBusEvents(ctx context.Context, eventName, group, consumer string) ([]BusMessage, error) {
+	messageList, err := store.XReadGroup(ctx, BusStreamKey(eventName), group, consumer, config.EventBusBatchSize, config.EventBusBlock)
+	if err != nil {
+		return nil, err
+	}
+	return toBusMessages(messageList), nil
+}
+
+// ClaimBusEvents 认领超过 EventBusRetryIdle 仍未确认的事件，包括处理失败的事件和其他实例崩溃前未处理完的事件
+func ClaimBusEvents(ctx context.Context, eventName, group, consumer string) ([]BusMessage, error) {
+	messageList, err := store.XAutoClaim(ctx, BusStreamKey(eventName), group, consumer, config.EventBusRetryIdle, config.EventBusBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return toBusMessages(messageList), nil
+}
+
+// BusEventDeliveries 获取未确认事件已被投递的次数
+func BusEventDeliveries(ctx context.Context, eventName, group, id string) (int64, error) {
+	return store.XDeliveries(ctx, BusStreamKey(eventName), group, id)
+}
+
+// AckBusEvent 确认事件已处理
+func AckBusEvent(ctx context.Context, eventName, group, id string) error {
+	return store.XAck(ctx, BusStreamKey(eventName), group, id)
+}
+
+// DeadBusEvent 将多次处理失败的事件移到死信 stream 中供人工排查，并确认原事件
+func DeadBusEvent(ctx context.Context, eventName, group string, message BusMessage) error {
+	if _, err := store.XAdd(ctx, BusDeadKey(eventName), config.EventBusStreamSize, map[string]interface{}{
+		"id": message.Id, "group": group, "data": message.Data,
+	}); err != nil {
+		return err
+	}
+	return AckBusEvent(ctx, eventName, group, message.Id)
+}
+
+func toBusMessages(messageList []XMessage) []BusMessage {
+	busMessageList := make([]BusMessage, 0, len(messageList))
+	for _, message := range messageList {
+		busMessageList = append(busMessageList, BusMessage{Id: message.Id, Data: []byte(message.Values["data"])})
+	}
+	return busMessageList
+}
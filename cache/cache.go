@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"strconv"
+	"time"
+)
+
+// Nil 读取的 key、field 或 member 不存在
+var Nil = errors.New("cache: nil")
+
+// Z 有序集合中的成员，读取时 Member 为 string
+type Z struct {
+	Score  float64
+	Member interface{}
+}
+
+// ZRangeBy 按分数范围读取有序集合，Min 和 Max 可以为 "-inf"、"+inf"，以 "(" 开头时不包含边界
+// Count 为 0 时不限制个数
+type ZRangeBy struct {
+	Min, Max      string
+	Offset, Count int64
+}
+
+// XMessage stream 中的一条消息，Id 格式为 "毫秒时间戳-序号"
+type XMessage struct {
+	Id     string
+	Values map[string]string
+}
+
+// Message pub/sub 频道中的一条消息
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// PubSub 一个 pub/sub 连接，可以动态订阅和取消订阅频道
+type PubSub interface {
+	Subscribe(ctx context.Context, channels ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+	// Channel 返回已订阅频道的消息，连接关闭后 channel 也会关闭
+	Channel() <-chan Message
+	Close() error
+}
+
+// Cache 缓存后端需要支持的操作，命名和语义与 Redis 命令一致
+// 写入的值按 Redis 的规则转换为字符串，如 bool 写入后为 "1" 或 "0"
+type Cache interface {
+	// Exists 返回 keys 中存在的个数
+	Exists(ctx context.Context, keys ...string) (int64, error)
+	Del(ctx context.Context, keys ...string) error
+	Expire(ctx context.Context, key string, exp time.Duration) error
+	Rename(ctx context.Context, key, newKey string) error
+
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value interface{}, exp time.Duration) error
+	SetNX(ctx context.Context, key string, value interface{}, exp time.Duration) (bool, error)
+	Incr(ctx context.Context, key string) (int64, error)
+
+	HGet(ctx context.Context, key, field string) (string, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HSet(ctx context.Context, key, field string, value interface{}) error
+	HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error)
+	HIncrBy(ctx context.Context, key, field string, incr int64) (int64, error)
+
+	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SRem(ctx context.Context, key string, members ...interface{}) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
+
+	ZAdd(ctx context.Context, key string, members ...Z) error
+	// ZIncrXX 只对已存在的成员增加分数，成员不存在时不做任何操作
+	ZIncrXX(ctx context.Context, key string, member Z) error
+	ZRem(ctx context.Context, key string, members ...interface{}) error
+	ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error
+	ZScore(ctx context.Context, key string, member interface{}) (float64, error)
+	ZCard(ctx context.Context, key string) (int64, error)
+	ZRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	ZRevRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error)
+	ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error)
+	ZRangeByScore(ctx context.Context, key string, by ZRangeBy) ([]string, error)
+	ZRevRangeByScore(ctx context.Context, key string, by ZRangeBy) ([]string, error)
+	ZRangeByScoreWithScores(ctx context.Context, key string, by ZRangeBy) ([]Z, error)
+	ZRevRangeByScoreWithScores(ctx context.Context, key string, by ZRangeBy) ([]Z, error)
+
+	// XAdd 追加消息并返回消息 id，只保留最近 maxLen 条
+	XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error)
+	// XRange 读取 id 在 [start, stop] 内的消息，"-" 和 "+" 表示最小和最大，以 "(" 开头时不包含边界
+	XRange(ctx context.Context, stream, start, stop string, count int64) ([]XMessage, error)
+	// XGroupCreate 创建只接收之后消息的消费者组，stream 不存在时一并创建，消费者组已存在时忽略
+	XGroupCreate(ctx context.Context, stream, group string) error
+	// XReadGroup 读取消费者组中尚未投递的消息，没有消息时最多阻塞 block，超时返回空
+	XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]XMessage, error)
+	// XAutoClaim 认领投递后超过 minIdle 仍未确认的消息，并增加投递次数
+	XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]XMessage, error)
+	// XDeliveries 获取未确认消息已被投递的次数，消息已确认时返回 0
+	XDeliveries(ctx context.Context, stream, group, id string) (int64, error)
+	XAck(ctx context.Context, stream, group string, ids ...string) error
+
+	Publish(ctx context.Context, channel string, message interface{}) error
+	// Subscribe 创建一个 pub/sub 连接，channels 为空时尚未订阅任何频道
+	Subscribe(ctx context.Context, channels ...string) PubSub
+}
+
+// store 当前使用的缓存后端，由 Use 设置
+// 缓存后端没有通过参数注入到 service 或各个缓存函数中，而是与 dal.Users 等一样作为包级变量，整个进程共用一个
+// 这样 service 和 cache 的函数签名可以保持不变，代价是同一进程内不能同时使用两个后端，测试之间也不能并行替换
+var store Cache
+
+// Use 设置缓存后端，需要在处理请求之前调用
+func Use(c Cache) {
+	store = c
+}
+
+// Connect 根据运行模式设置缓存后端，开发模式下使用进程内缓存，否则连接 Redis
+func Connect() error {
+	switch config.Mode {
+	case config.ModeProd:
+		return ConnectRDB()
+	case config.ModeDev:
+		Use(NewMemoryCache())
+		return nil
+	}
+	return errors.New("未知的运行模式：" + config.Mode)
+}
+
+// toString 与 go-redis 写入参数时的规则一致，将值转换为字符串
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
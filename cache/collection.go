@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"gorm.io/gorm"
+	"strconv"
+)
+
+// WriteCollection 从 MySQL 中读取收藏夹信息写入 Redis
+func WriteCollection(ctx context.Context, collectionId int64) (dal.Collection, error) {
+	collection, err := dal.GetCollectionById(ctx, collectionId)
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	key := CollectionKey(collectionId)
+	if err := RedisStructHash(ctx, collection, key); err != nil {
+		return dal.Collection{}, err
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return dal.Collection{}, err
+	}
+	return collection, nil
+}
+
+// ReadCollection 先在 Redis 中查找收藏夹信息，若无则从 MySQL 中读取
+func ReadCollection(ctx context.Context, collectionId int64) (dal.Collection, error) {
+	key := CollectionKey(collectionId)
+	n, err := store.Exists(ctx, key)
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	if n <= 0 { // 未命中，读取 MySQL
+		return WriteCollection(ctx, collectionId)
+	}
+	collection, err := ReadCollectionFromHash(ctx, key)
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return dal.Collection{}, err
+	}
+	return collection, nil
+}
+
+// ReadVisibleCollection 读取用户有权限查看的收藏夹，创建者总是可以查看，其他用户只能查看公开的收藏夹
+// 无权限时与收藏夹不存在返回相同的错误
+func ReadVisibleCollection(ctx context.Context, userId, collectionId int64) (dal.Collection, error) {
+	collection, err := ReadCollection(ctx, collectionId)
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	if collection.UserId != userId && !collection.IsPublic {
+		return dal.Collection{}, gorm.ErrRecordNotFound
+	}
+	return collection, nil
+}
+
+// WriteCollectionList 根据用户 id 从 MySQL 中读取收藏夹列表，以创建时间作为 score 写入 zset
+func WriteCollectionList(ctx context.Context, userId int64) error {
+	collectionList, err := dal.GetCollectionByUserId(ctx, userId)
+	if err != nil {
+		return err
+	}
+	listKey := CollectionListKey(userId)
+	for _, collection := range collectionList {
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(collection.CreatedAt), Member: collection.Id}); err != nil {
+			return err
+		}
+		key := CollectionKey(collection.Id)
+		if err := RedisStructHash(ctx, collection, key); err != nil {
+			return err
+		}
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+			return err
+		}
+	}
+	// zset 整体设置一次过期时间即可
+	return store.Expire(ctx, listKey, config.RedisExp)
+}
+
+// ReadCollectionList 读取用户创建的收藏夹，最新创建的在前
+// userA 是当前登录用户，userB 是查看的用户，查看他人时只返回公开的收藏夹
+func ReadCollectionList(ctx context.Context, userAId, userBId int64) ([]dal.Collection, error) {
+	listKey := CollectionListKey(userBId)
+	n, err := store.Exists(ctx, listKey)
+	if err != nil {
+		return []dal.Collection{}, err
+	}
+	if n <= 0 { // 未命中，从数据库中读取并写入
+		if err := WriteCollectionList(ctx, userBId); err != nil {
+			return []dal.Collection{}, err
+		}
+	}
+	collectionIdStrList, err := store.ZRevRange(ctx, listKey, 0, -1)
+	if err != nil {
+		return []dal.Collection{}, err
+	}
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
+		return []dal.Collection{}, err
+	}
+	collectionList := make([]dal.Collection, 0, len(collectionIdStrList))
+	for _, collectionIdStr := range collectionIdStrList {
+		collectionId, err := strconv.ParseInt(collectionIdStr, 10, 64)
+		if err != nil {
+			return []dal.Collection{}, err
+		}
+		collection, err := ReadCollection(ctx, collectionId)
+		if err != nil {
+			return []dal.Collection{}, err
+		}
+		if userAId == userBId || collection.IsPublic {
+			collectionList = append(collectionList, collection)
+		}
+	}
+	return collectionList, nil
+}
+
+// WriteCollectionItems 从 MySQL 中读取收藏夹中的视频，以位置作为 score 写入 zset
+func WriteCollectionItems(ctx context.Context, collectionId int64) error {
+	itemList, err := dal.GetCollectionItems(ctx, collectionId)
+	if err != nil {
+		return err
+	}
+	listKey := CollectionItemKey(collectionId)
+	for _, item := range itemList {
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(item.Position), Member: item.VideoId}); err != nil {
+			return err
+		}
+	}
+	return store.Expire(ctx, listKey, config.RedisExp)
+}
+
+// ReadCollectionItems 按位置分页读取收藏夹中的视频，只返回当前用户有权限查看的视频
+// offset 为已读取的个数，首页为 0，返回下一页的 offset 以及是否还有下一页
+func ReadCollectionItems(ctx context.Context, userId, collectionId, offset int64, count int) ([]dal.Video, int64, bool, error) {
+	listKey := CollectionItemKey(collectionId)
+	n, err := store.Exists(ctx, listKey)
+	if err != nil {
+		return []dal.Video{}, 0, false, err
+	}
+	if n <= 0 { // 未命中，从数据库中读取并写入
+		if err := WriteCollectionItems(ctx, collectionId); err != nil {
+			return []dal.Video{}, 0, false, err
+		}
+	}
+	// 多读一个用于判断是否还有下一页
+	videoIdStrList, err := store.ZRevRange(ctx, listKey, offset, offset+int64(count))
+	if err != nil {
+		return []dal.Video{}, 0, false, err
+	}
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
+		return []dal.Video{}, 0, false, err
+	}
+	hasMore := len(videoIdStrList) > count
+	if hasMore {
+		videoIdStrList = videoIdStrList[:count]
+	}
+	videoList := make([]dal.Video, 0, len(videoIdStrList))
+	for _, videoIdStr := range videoIdStrList {
+		videoId, err := strconv.ParseInt(videoIdStr, 10, 64)
+		if err != nil {
+			return []dal.Video{}, 0, false, err
+		}
+		video, err := ReadVideo(ctx, videoId)
+		if err != nil {
+			return []dal.Video{}, 0, false, err
+		}
+		videoList = append(videoList, video)
+	}
+	nextOffset := offset + int64(len(videoList))
+	videoList, err = filterVisibleVideos(ctx, userId, videoList)
+	if err != nil {
+		return []dal.Video{}, 0, false, err
+	}
+	return videoList, nextOffset, hasMore, nil
+}
+
+// AddCollection 创建收藏夹，写入 MySQL 后加入已缓存的收藏夹列表
+func AddCollection(ctx context.Context, collection dal.Collection) (dal.Collection, error) {
+	collection, err := dal.AddCollection(ctx, collection)
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	// 列表未缓存时跳过，下次读取时从数据库写入
+	listKey := CollectionListKey(collection.UserId)
+	n, err := store.Exists(ctx, listKey)
+	if err != nil || n <= 0 {
+		return collection, err
+	}
+	if err := store.ZAdd(ctx, listKey, Z{Score: float64(collection.CreatedAt), Member: collection.Id}); err != nil {
+		return dal.Collection{}, err
+	}
+	return collection, nil
+}
+
+// EditCollection 修改收藏夹名称和是否公开，采用延迟双删
+func EditCollection(ctx context.Context, userId, collectionId int64, name string, isPublic bool) error {
+	key := CollectionKey(collectionId)
+	// Redis 第一次删除
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.EditCollection(ctx, userId, collectionId, name, isPublic); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return store.Del(ctx, key)
+}
+
+// removeCollection 从 Redis 中删除收藏夹、收藏夹中的视频以及用户收藏夹列表中的引用
+func removeCollection(ctx context.Context, userId, collectionId int64) error {
+	if err := store.ZRem(ctx, CollectionListKey(userId), collectionId); err != nil {
+		return err
+	}
+	return store.Del(ctx, CollectionKey(collectionId), CollectionItemKey(collectionId))
+}
+
+// DeleteCollection 删除收藏夹，采用延迟双删
+func DeleteCollection(ctx context.Context, userId, collectionId int64) error {
+	// Redis 第一次删除
+	if err := removeCollection(ctx, userId, collectionId); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.DeleteCollection(ctx, userId, collectionId); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return removeCollection(ctx, userId, collectionId)
+}
+
+// deleteCollectionCache 收藏的视频变化时，删除收藏夹信息（视频数变化）和收藏夹中的视频
+func deleteCollectionCache(ctx context.Context, collectionId int64) error {
+	return store.Del(ctx, CollectionKey(collectionId), CollectionItemKey(collectionId))
+}
+
+// AddCollectionItem 将视频加入收藏夹，采用延迟双删
+func AddCollectionItem(ctx context.Context, userId, collectionId, videoId int64) error {
+	// Redis 第一次删除
+	if err := deleteCollectionCache(ctx, collectionId); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.AddCollectionItem(ctx, userId, collectionId, videoId); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return deleteCollectionCache(ctx, collectionId)
+}
+
+// DeleteCollectionItem 将视频移出收藏夹，采用延迟双删
+func DeleteCollectionItem(ctx context.Context, userId, collectionId, videoId int64) error {
+	// Redis 第一次删除
+	if err := deleteCollectionCache(ctx, collectionId); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.DeleteCollectionItem(ctx, userId, collectionId, videoId); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return deleteCollectionCache(ctx, collectionId)
+}
+
+// MoveCollectionItem 调整收藏夹中视频的顺序，采用延迟双删
+func MoveCollectionItem(ctx context.Context, userId, collectionId, videoId int64, toIndex int) error {
+	// Redis 第一次删除
+	if err := store.Del(ctx, CollectionItemKey(collectionId)); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.MoveCollectionItem(ctx, userId, collectionId, videoId, toIndex); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return store.Del(ctx, CollectionItemKey(collectionId))
+}
@@ -1,181 +1,363 @@
 package cache
 
 import (
+	"context"
 	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/dal"
+	"log"
 	"strconv"
 )
 
-// WriteCommentList 根据视频 id 从 MySQL 中读取对应的评论列表，用 set 存储
-// Comment 本身的内容用 hash 存储
-func WriteCommentList(videoId int64) ([]dal.Comment, error) {
-	commentList, err := dal.GetCommentByVideoId(videoId)
+// WriteCommentList 根据视频 id 从 MySQL 中读取对应的一级评论列表
+// 用两个 zset 存储：按发表时间排序的评论列表和按点赞数排序的热门列表，Comment 本身的内容用 hash 存储
+func WriteCommentList(ctx context.Context, videoId int64) ([]dal.Comment, error) {
+	commentList, err := dal.Comments.GetByVideoId(ctx, videoId)
 	if err != nil {
 		return []dal.Comment{}, err
 	}
 	listKey := CommentListKey(videoId)
+	hotKey := CommentHotKey(videoId)
 	for _, comment := range commentList {
-		// 写入 set
-		if err := RDB.SAdd(CTX, listKey, comment.Id).Err(); err != nil {
+		// 写入 zset
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(comment.CreatedAt), Member: comment.Id}); err != nil {
+			return []dal.Comment{}, err
+		}
+		if err := store.ZAdd(ctx, hotKey, Z{Score: float64(comment.LikeCount), Member: comment.Id}); err != nil {
 			return []dal.Comment{}, err
 		}
 		// 写入 hash
 		key := CommentKey(comment.Id)
-		if err := RedisStructHash(comment, key); err != nil {
+		if err := RedisStructHash(ctx, comment, key); err != nil {
 			return []dal.Comment{}, err
 		}
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return []dal.Comment{}, err
 		}
 	}
-	// set 整体设置一次过期时间即可
-	if err := RDB.Expire(CTX, listKey, config.RedisExp).Err(); err != nil {
+	// zset 整体设置一次过期时间即可
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
+		return []dal.Comment{}, err
+	}
+	if err := store.Expire(ctx, hotKey, config.RedisExp); err != nil {
 		return []dal.Comment{}, err
 	}
 	return commentList, nil
 }
 
-// ReadCommentList 读取视频的评论列表，没有则从数据库写入
-// 需要同时读取用户信息
-func ReadCommentList(videoId int64) ([]dal.Comment, error) {
-	var commentList []dal.Comment
+// ReadCommentList 分页读取视频的一级评论列表，没有则从数据库写入，需要同时读取用户信息
+// cursor 为上一页最后一条评论的排序分数（按时间排序时为 created_at，按热度排序时为点赞数）和评论 id，首页为零值
+// 返回下一页的 cursor 以及是否还有下一页
+func ReadCommentList(ctx context.Context, videoId int64, sortType string, cursor Cursor, count int) ([]dal.Comment, Cursor, bool, error) {
 	listKey := CommentListKey(videoId)
-	n, err := RDB.Exists(CTX, listKey).Result()
+	hotKey := CommentHotKey(videoId)
+	n, err := store.Exists(ctx, listKey, hotKey)
 	if err != nil {
-		return []dal.Comment{}, err
+		return []dal.Comment{}, cursor, false, err
+	}
+	if n < 2 { // 未命中，从数据库中读取并分别写入 zset 和 hash
+		if err := store.Del(ctx, listKey, hotKey); err != nil {
+			return []dal.Comment{}, cursor, false, err
+		}
+		if _, err := WriteCommentList(ctx, videoId); err != nil {
+			return []dal.Comment{}, cursor, false, err
+		}
 	}
-	if n <= 0 { // 未命中，从数据库中读取并分别写入 set 和 hash
-		commentList, err = WriteCommentList(videoId)
+	var zList []Z
+	var nextCursor Cursor
+	var hasMore bool
+	switch sortType {
+	case dal.CommentSortOldest:
+		zList, nextCursor, hasMore, err = zPage(ctx, listKey, cursor, count, false)
+	case dal.CommentSortHot:
+		zList, nextCursor, hasMore, err = zPage(ctx, hotKey, cursor, count, true)
+	default: // 默认最新发表在前
+		zList, nextCursor, hasMore, err = zPage(ctx, listKey, cursor, count, true)
+	}
+	if err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	// 更新过期时间
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	if err := store.Expire(ctx, hotKey, config.RedisExp); err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	commentList := make([]dal.Comment, 0, len(zList))
+	for _, z := range zList {
+		commentId, err := strconv.ParseInt(z.Member.(string), 10, 64)
 		if err != nil {
-			return []dal.Comment{}, err
+			return []dal.Comment{}, cursor, false, err
 		}
-	} else { // 命中，从 Redis 中读取
-		commentIdStrList, err := RDB.SMembers(CTX, listKey).Result()
+		// 查找对应评论及用户信息，若无则从数据库写入
+		comment, err := ReadComment(ctx, commentId)
 		if err != nil {
-			return []dal.Comment{}, err
+			return []dal.Comment{}, cursor, false, err
 		}
-		// 更新过期时间
-		if err := RDB.Expire(CTX, listKey, config.RedisExp).Err(); err != nil {
+		commentList = append(commentList, comment)
+	}
+	// 置顶评论只出现在第一页的最前面
+	commentList, err = placePinnedComment(ctx, videoId, commentList, cursor == Cursor{})
+	if err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	return commentList, nextCursor, hasMore, nil
+}
+
+// placePinnedComment 从评论列表中移除置顶评论，firstPage 为 true 时将其放在最前面
+func placePinnedComment(ctx context.Context, videoId int64, commentList []dal.Comment, firstPage bool) ([]dal.Comment, error) {
+	video, err := ReadVideo(ctx, videoId)
+	if err != nil {
+		return []dal.Comment{}, err
+	}
+	if video.PinnedCommentId == 0 {
+		return commentList, nil
+	}
+	resultList := make([]dal.Comment, 0, len(commentList)+1)
+	if firstPage {
+		pinned, err := ReadComment(ctx, video.PinnedCommentId)
+		if err != nil {
 			return []dal.Comment{}, err
 		}
-		for _, commentIdStr := range commentIdStrList {
-			commentId, err := strconv.ParseInt(commentIdStr, 10, 64)
-			if err != nil {
-				return []dal.Comment{}, err
-			}
-			// 查找对应评论，若无则写入评论
-			key := CommentKey(commentId)
-			n, err := RDB.Exists(CTX, key).Result()
-			if err != nil {
-				return []dal.Comment{}, err
-			}
-			var comment dal.Comment
-			if n <= 0 { // 未命中，从数据库中读取
-				comment, err = dal.GetCommentById(commentId)
-				if err != nil {
-					return []dal.Comment{}, err
-				}
-			} else { // 命中
-				comment, err = ReadCommentFromHash(key)
-				if err != nil {
-					return []dal.Comment{}, err
-				}
-				if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
-					return []dal.Comment{}, err
-				}
-			}
-			// 为每条评论读取用户信息
-			comment.User, err = ReadUser(comment.UserId)
-			if err != nil {
-				return []dal.Comment{}, err
-			}
-			commentList = append(commentList, comment)
+		pinned.IsPinned = true
+		resultList = append(resultList, pinned)
+	}
+	for _, comment := range commentList {
+		if comment.Id != video.PinnedCommentId {
+			resultList = append(resultList, comment)
 		}
 	}
-	return commentList, nil
+	return resultList, nil
 }
 
-// AddComment 有新评论时，先写入 MySQL 再写入 Redis
-// 需要删除 Redis 中涉及到的视频，采用延迟双删
-func AddComment(comment dal.Comment) error {
-	// Redis 第一次删除视频
-	if err := DeleteVideo(comment.VideoId); err != nil {
+// WriteReplyList 从 MySQL 中读取一级评论的所有回复，用 zset 存储，score 为发表时间
+func WriteReplyList(ctx context.Context, rootId int64) error {
+	replyList, err := dal.Comments.GetReplyByRootId(ctx, rootId)
+	if err != nil {
 		return err
 	}
-	// 写入 MySQL
-	commentId, err := dal.AddComment(comment)
-	if err != nil {
+	listKey := ReplyListKey(rootId)
+	for _, reply := range replyList {
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(reply.CreatedAt), Member: reply.Id}); err != nil {
+			return err
+		}
+		key := CommentKey(reply.Id)
+		if err := RedisStructHash(ctx, reply, key); err != nil {
+			return err
+		}
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+			return err
+		}
+	}
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
 		return err
 	}
-	// 更新生成的自增主键
-	comment.Id = commentId
-	// Redis 第二次删除视频
-	if err := DeleteVideo(comment.VideoId); err != nil {
+	return nil
+}
+
+// ReadReplyList 分页读取一级评论的回复，按发表顺序排列
+// cursor 为上一页最后一条回复的 created_at 和回复 id，首页为零值；返回下一页的 cursor 以及是否还有下一页
+func ReadReplyList(ctx context.Context, rootId int64, cursor Cursor, count int) ([]dal.Comment, Cursor, bool, error) {
+	listKey := ReplyListKey(rootId)
+	n, err := store.Exists(ctx, listKey)
+	if err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	if n <= 0 { // 未命中，只写入这一条一级评论的回复
+		if err := WriteReplyList(ctx, rootId); err != nil {
+			return []dal.Comment{}, cursor, false, err
+		}
+	}
+	zList, nextCursor, hasMore, err := zPage(ctx, listKey, cursor, count, false)
+	if err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
+		return []dal.Comment{}, cursor, false, err
+	}
+	replyList := make([]dal.Comment, 0, len(zList))
+	for _, z := range zList {
+		replyId, err := strconv.ParseInt(z.Member.(string), 10, 64)
+		if err != nil {
+			return []dal.Comment{}, cursor, false, err
+		}
+		reply, err := ReadComment(ctx, replyId)
+		if err != nil {
+			return []dal.Comment{}, cursor, false, err
+		}
+		replyList = append(replyList, reply)
+	}
+	return replyList, nextCursor, hasMore, nil
+}
+
+// ReadComment 读取单条评论及其用户信息，未命中则从 MySQL 中读取
+func ReadComment(ctx context.Context, commentId int64) (dal.Comment, error) {
+	key := CommentKey(commentId)
+	n, err := store.Exists(ctx, key)
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	var comment dal.Comment
+	if n <= 0 { // 未命中，从数据库中读取并写入
+		comment, err = dal.Comments.GetById(ctx, commentId)
+		if err != nil {
+			return dal.Comment{}, err
+		}
+		if err := RedisStructHash(ctx, comment, key); err != nil {
+			return dal.Comment{}, err
+		}
+	} else { // 命中
+		comment, err = ReadCommentFromHash(ctx, key)
+		if err != nil {
+			return dal.Comment{}, err
+		}
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return dal.Comment{}, err
+	}
+	comment.User, err = ReadUser(ctx, comment.UserId)
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	return comment, nil
+}
+
+// addToReplyList 回复列表已缓存时写入新回复，未缓存时等待下次读取从 MySQL 写入
+func addToReplyList(ctx context.Context, reply dal.Comment) error {
+	listKey := ReplyListKey(reply.RootId)
+	n, err := store.Exists(ctx, listKey)
+	if err != nil || n <= 0 {
 		return err
 	}
-	// 分别写入 Redis 的 set 和 hash
-	// 写入 set
+	return store.ZAdd(ctx, listKey, Z{Score: float64(reply.CreatedAt), Member: reply.Id})
+}
+
+// addToCommentList 评论列表已缓存时写入新评论，未缓存时等待下次读取从 MySQL 写入
+func addToCommentList(ctx context.Context, comment dal.Comment) error {
 	listKey := CommentListKey(comment.VideoId)
-	if err := RDB.SAdd(CTX, listKey, commentId).Err(); err != nil {
+	hotKey := CommentHotKey(comment.VideoId)
+	n, err := store.Exists(ctx, listKey, hotKey)
+	if err != nil || n < 2 {
 		return err
 	}
-	// 写入 Hash
-	key := CommentKey(commentId)
-	if err := RedisStructHash(comment, key); err != nil {
+	if err := store.ZAdd(ctx, listKey, Z{Score: float64(comment.CreatedAt), Member: comment.Id}); err != nil {
 		return err
 	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
-		return err
+	return store.ZAdd(ctx, hotKey, Z{Score: float64(comment.LikeCount), Member: comment.Id})
+}
+
+// AddComment 有新评论时，先写入 MySQL 再写入 Redis，并返回写入后的评论
+// 需要删除 Redis 中涉及到的视频，采用延迟双删，第二次删除由发件箱 relay 执行
+// 回复还需删除所属一级评论（回复数变化）
+func AddComment(ctx context.Context, comment dal.Comment) (dal.Comment, error) {
+	// Redis 第一次删除视频
+	if err := DeleteVideo(ctx, comment.VideoId); err != nil {
+		return dal.Comment{}, err
 	}
-	return nil
+	// 写入 MySQL，回复的 RootId 由 MySQL 层确定，同一事务中记录发件箱任务
+	comment, err := dal.Comments.Add(ctx, comment)
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	// 立即同步一次，评论列表或回复列表已缓存时写入，失败时由 relay 补齐
+	if err := syncComment(ctx, dal.CommentChange{CommentId: comment.Id, VideoId: comment.VideoId, RootId: comment.RootId}); err != nil {
+		log.Println(err)
+	}
+	return comment, nil
 }
 
-// DeleteComment 删除评论时，采用延迟双删确保一致性
-// 此处 Redis 需要删除的有：该条评论的 hash、该条评论对应的 set 中的 id、该条评论对应视频的 hash
-func DeleteComment(userId, videoId, commentId int64) error {
-	// Redis 第一次删除评论 hash
+// EditComment 编辑评论时，先写入 MySQL，评论 hash 采用延迟双删
+// 评论列表中只保存 id，不受影响
+func EditComment(ctx context.Context, userId, commentId int64, content, mentionData string) (dal.Comment, error) {
+	// Redis 第一次删除评论
 	key := CommentKey(commentId)
-	if err := RDB.Del(CTX, key).Err(); err != nil {
-		return err
+	if err := store.Del(ctx, key); err != nil {
+		return dal.Comment{}, err
 	}
-	// Redis 第一次删除评论 set
-	listKey := CommentListKey(videoId)
-	if err := RDB.SRem(CTX, listKey, commentId).Err(); err != nil {
+	// 写入 MySQL
+	comment, err := dal.Comments.Edit(ctx, userId, commentId, content, mentionData)
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	// Redis 第二次删除评论
+	if err := store.Del(ctx, key); err != nil {
+		return dal.Comment{}, err
+	}
+	return comment, nil
+}
+
+// DeleteComment 删除评论时，采用延迟双删确保一致性
+// 此处 Redis 需要删除的有：该条评论的 hash、该条评论对应的 zset 中的 id、该条评论对应视频的 hash
+// 删除一级评论时还需删除回复列表，删除回复时需删除所属一级评论的 hash
+// 第二次删除由发件箱 relay 执行，以事务中记录的回复 id 为准
+func DeleteComment(ctx context.Context, userId, videoId, commentId int64) error {
+	comment, err := dal.Comments.GetById(ctx, commentId)
+	if err != nil {
 		return err
 	}
-	// Redis 第一次删除视频
-	if err := DeleteVideo(videoId); err != nil {
+	// Redis 第一次删除
+	if err := removeComment(ctx, comment); err != nil {
 		return err
 	}
-	// MySQL 删除
-	if err := dal.DeleteComment(userId, videoId, commentId); err != nil {
+	// MySQL 删除，同一事务中记录发件箱任务
+	if err := dal.Comments.Delete(ctx, userId, videoId, commentId); err != nil {
 		return err
 	}
-	// Redis 第二次删除视频
-	if err := DeleteVideo(videoId); err != nil {
+	// 立即删除一次，失败时由 relay 补齐
+	if err := removeComment(ctx, comment); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// removeComment 从 Redis 中删除评论及其关联数据
+func removeComment(ctx context.Context, comment dal.Comment) error {
+	if err := store.Del(ctx, CommentKey(comment.Id)); err != nil {
 		return err
 	}
-	// Redis 第二次删除评论 set
-	if err := RDB.SRem(CTX, listKey, commentId).Err(); err != nil {
+	if err := DeleteVideo(ctx, comment.VideoId); err != nil {
 		return err
 	}
-	// Redis 第二次删除评论
-	if err := RDB.Del(CTX, key).Err(); err != nil {
+	if comment.RootId == 0 {
+		if err := store.ZRem(ctx, CommentListKey(comment.VideoId), comment.Id); err != nil {
+			return err
+		}
+		if err := store.ZRem(ctx, CommentHotKey(comment.VideoId), comment.Id); err != nil {
+			return err
+		}
+		// 删除所有回复的 hash 和回复列表
+		replyKey := ReplyListKey(comment.Id)
+		replyIdStrList, err := store.ZRange(ctx, replyKey, 0, -1)
+		if err != nil {
+			return err
+		}
+		for _, replyIdStr := range replyIdStrList {
+			replyId, err := strconv.ParseInt(replyIdStr, 10, 64)
+			if err != nil {
+				return err
+			}
+			if err := store.Del(ctx, CommentKey(replyId)); err != nil {
+				return err
+			}
+		}
+		return store.Del(ctx, replyKey)
+	}
+	if err := store.ZRem(ctx, ReplyListKey(comment.RootId), comment.Id); err != nil {
 		return err
 	}
-	return nil
+	return store.Del(ctx, CommentKey(comment.RootId))
 }
 
 // Old version
 //func ReadComment(videoId int64) ([]dal.Comment, error) {
 //	var commentList []dal.Comment
 //	// 用正则表达式获取全部评论的 key
-//	commentKeyList, err := RDB.Keys(CTX, "comment:*").Result()
+//	commentKeyList, err := store.Keys(ctx, "comment:*")
 //	if err != nil {
 //		return []dal.Comment{}, err
 //	}
 //	for _, commentKey := range commentKeyList {
-//		videoIdHashStr, err := RDB.HGet(CTX, commentKey, "video_id").Result()
+//		videoIdHashStr, err := store.HGet(ctx, commentKey, "video_id")
 //		if err != nil {
 //			return []dal.Comment{}, err
 //		}
@@ -184,7 +366,7 @@ func DeleteComment(userId, videoId, commentId int64) error {
 //			return []dal.Comment{}, err
 //		}
 //		if videoIdHash == videoId { // 找到了该视频的评论
-//			comment, err := ReadCommentFromHash(commentKey)
+//			comment, err := ReadCommentFromHash(ctx, commentKey)
 //			if err != nil {
 //				return []dal.Comment{}, err
 //			}
@@ -192,24 +374,24 @@ func DeleteComment(userId, videoId, commentId int64) error {
 //		}
 //	}
 //	// 获取评论的操作一定是在视频读取之后，因此可以很快速地获取到视频相关信息
-//	video, err := ReadVideo(videoId)
+//	video, err := ReadVideo(ctx, videoId)
 //	if err != nil {
 //		return []dal.Comment{}, err
 //	}
 //	if int64(len(commentList)) < video.CommentCount { // 评论数不够，由于无法判断少了哪条评论，因此只能重新从数据库中获取
-//		commentList, err = dal.GetCommentByVideoId(videoId)
+//		commentList, err = dal.Comments.GetByVideoId(ctx, videoId)
 //		if err != nil {
 //			return []dal.Comment{}, err
 //		}
 //		// 将未放入缓存的评论写入缓存
 //		for _, comment := range commentList {
 //			key := CommentKey(comment.Id)
-//			n, err := RDB.Exists(CTX, key).Result()
+//			n, err := store.Exists(ctx, key)
 //			if err != nil {
 //				return []dal.Comment{}, err
 //			}
 //			if n <= 0 { // 没有找到记录，写入缓存
-//				if err := RedisStructHash(comment, key); err != nil {
+//				if err := RedisStructHash(ctx, comment, key); err != nil {
 //					return []dal.Comment{}, err
 //				}
 //			}
@@ -217,7 +399,7 @@ func DeleteComment(userId, videoId, commentId int64) error {
 //	}
 //	// 对每条评论读取用户信息
 //	for _, comment := range commentList {
-//		user, err := ReadUser(comment.UserId)
+//		user, err := ReadUser(ctx, comment.UserId)
 //		if err != nil {
 //			return []dal.Comment{}, err
 //		}
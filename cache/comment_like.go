@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+)
+
+// WriteCommentLikeList 根据用户 id 从 MySQL 中读取评论点赞信息
+// 根据用户 id 建立 set
+func WriteCommentLikeList(ctx context.Context, userId int64) error {
+	likeList, err := dal.GetCommentLikeByUserId(ctx, userId)
+	if err != nil {
+		return err
+	}
+	key := CommentLikeKey(userId)
+	for _, like := range likeList {
+		if err := store.SAdd(ctx, key, like.CommentId); err != nil {
+			return err
+		}
+	}
+	// 整体设置一次过期时间
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadCommentLike 查询用户是否点赞过评论，未命中则从 MySQL 中读取
+func ReadCommentLike(ctx context.Context, userId, commentId int64) (bool, error) {
+	key := CommentLikeKey(userId)
+	n, err := store.Exists(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	// 未命中，先从数据库中提取用户的评论点赞记录并写入
+	if n <= 0 {
+		if err := WriteCommentLikeList(ctx, userId); err != nil {
+			return false, err
+		}
+	}
+	isLiked, err := store.SIsMember(ctx, key, commentId)
+	if err != nil {
+		return false, err
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return false, err
+	}
+	return isLiked, nil
+}
+
+// incrCommentHot 更新热门评论列表中的点赞数，只更新已缓存的一级评论
+func incrCommentHot(ctx context.Context, commentId int64, delta float64) error {
+	comment, err := dal.Comments.GetById(ctx, commentId)
+	if err != nil || comment.RootId != 0 {
+		return err
+	}
+	err = store.ZIncrXX(ctx, CommentHotKey(comment.VideoId), Z{Score: delta, Member: commentId})
+	if err == Nil { // 评论不在缓存中
+		return nil
+	}
+	return err
+}
+
+// AddCommentLike 点赞评论时，先写入 MySQL 再写入 Redis
+// 需要删除 Redis 中涉及到的评论，采用延迟双删
+func AddCommentLike(ctx context.Context, userId, commentId int64) error {
+	// Redis 第一次删除评论
+	key := CommentKey(commentId)
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	changed, err := dal.AddCommentLike(ctx, userId, commentId)
+	if err != nil {
+		return err
+	}
+	// Redis 第二次删除评论
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	if changed {
+		if err := incrCommentHot(ctx, commentId, 1); err != nil {
+			return err
+		}
+	}
+	// 写入 Redis
+	likeKey := CommentLikeKey(userId)
+	if err := store.SAdd(ctx, likeKey, commentId); err != nil {
+		return err
+	}
+	if err := store.Expire(ctx, likeKey, config.RedisExp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCommentLike 取消点赞评论时，采用延迟双删确保一致性
+func DeleteCommentLike(ctx context.Context, userId, commentId int64) error {
+	// Redis 第一次删除点赞和评论
+	likeKey := CommentLikeKey(userId)
+	if err := store.SRem(ctx, likeKey, commentId); err != nil {
+		return err
+	}
+	key := CommentKey(commentId)
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	// MySQL 删除
+	changed, err := dal.DeleteCommentLike(ctx, userId, commentId)
+	if err != nil {
+		return err
+	}
+	// Redis 第二次删除评论和点赞
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	if changed {
+		if err := incrCommentHot(ctx, commentId, -1); err != nil {
+			return err
+		}
+	}
+	if err := store.SRem(ctx, likeKey, commentId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FillCommentLike 为评论列表填充当前用户是否点赞的信息
+func FillCommentLike(ctx context.Context, userId int64, commentList []dal.Comment) error {
+	for i, comment := range commentList {
+		isLiked, err := ReadCommentLike(ctx, userId, comment.Id)
+		if err != nil {
+			return err
+		}
+		commentList[i].IsLiked = isLiked
+	}
+	return nil
+}
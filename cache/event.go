@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Event 推送给用户的实时事件，Id 为事件在用户事件流中的 id，客户端重连时据此补发错过的事件
+type Event struct {
+	UserId int64           `json:"-"`
+	Id     string          `json:"id,omitempty"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// PublishEvent 将事件追加到用户的事件流中，再通过 pub/sub 通知所有服务实例
+// 事件流只保留最近 EventLogSize 条，用于断线重连后补发
+func PublishEvent(ctx context.Context, userId int64, eventType string, data []byte) error {
+	streamKey := EventStreamKey(userId)
+	id, err := store.XAdd(ctx, streamKey, config.EventLogSize, map[string]interface{}{"type": eventType, "data": data})
+	if err != nil {
+		return err
+	}
+	if err := store.Expire(ctx, streamKey, config.EventLogExp); err != nil {
+		return err
+	}
+	event, err := json.Marshal(Event{Id: id, Type: eventType, Data: data})
+	if err != nil {
+		return err
+	}
+	return store.Publish(ctx, PushChannel(userId), event)
+}
+
+// ReadEventsAfter 读取用户事件流中 lastEventId 之后的事件，最多 count 条
+// 如果 lastEventId 之后的事件已被裁剪，complete 为 false，客户端需要重新拉取完整数据
+func ReadEventsAfter(ctx context.Context, userId int64, lastEventId string, count int64) (eventList []Event, complete bool, err error) {
+	streamKey := EventStreamKey(userId)
+	oldest, err := store.XRange(ctx, streamKey, "-", "+", 1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(oldest) == 0 { // 事件流已过期
+		return nil, false, nil
+	}
+	// lastEventId 本身已被裁剪或过期时，无法确定其后是否有事件丢失
+	complete = CompareEventId(oldest[0].Id, lastEventId) <= 0
+	messageList, err := store.XRange(ctx, streamKey, "("+lastEventId, "+", count)
+	if err != nil {
+		return nil, false, err
+	}
+	eventList = make([]Event, 0, len(messageList))
+	for _, message := range messageList {
+		eventList = append(eventList, Event{Id: message.Id, Type: message.Values["type"], Data: json.RawMessage(message.Values["data"])})
+	}
+	return eventList, complete, nil
+}
+
+// EventSubscription 一个 pub/sub 连接，可以动态订阅和取消订阅用户的事件
+type EventSubscription struct {
+	pubSub PubSub
+}
+
+// SubscribeEvents 创建一个尚未订阅任何用户的 pub/sub 连接
+func SubscribeEvents(ctx context.Context) *EventSubscription {
+	return &EventSubscription{pubSub: store.Subscribe(ctx)}
+}
+
+// Subscribe 订阅用户的事件
+func (sub *EventSubscription) Subscribe(ctx context.Context, userId int64) error {
+	return sub.pubSub.Subscribe(ctx, PushChannel(userId))
+}
+
+// Unsubscribe 取消订阅用户的事件
+func (sub *EventSubscription) Unsubscribe(ctx context.Context, userId int64) error {
+	return sub.pubSub.Unsubscribe(ctx, PushChannel(userId))
+}
+
+// Events 返回已订阅用户的事件，无法解析的消息会被丢弃
+func (sub *EventSubscription) Events() <-chan Event {
+	eventChan := make(chan Event)
+	go func() {
+		defer close(eventChan)
+		for message := range sub.pubSub.Channel() {
+			userId, err := strconv.ParseInt(strings.TrimPrefix(message.Channel, "push:"), 10, 64)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				log.Println(err)
+				continue
+			}
+			event.UserId = userId
+			eventChan <- event
+		}
+	}()
+	return eventChan
+}
+
+// CompareEventId 比较两个事件 id 的先后，id 格式为 "毫秒时间戳-序号"
+func CompareEventId(a, b string) int {
+	aMs, aSeq := splitEventId(a)
+	bMs, bSeq := splitEventId(b)
+	switch {
+	case aMs != bMs:
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	case aSeq != bSeq:
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ValidEventId 检查客户端传入的事件 id 格式是否正确
+func ValidEventId(id string) bool {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return false
+	}
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return false
+	}
+	_, err := strconv.ParseUint(seq, 10, 64)
+	return err == nil
+}
+
+func splitEventId(id string) (uint64, uint64) {
+	msStr, seqStr, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msStr, 10, 64)
+	seq, _ := strconv.ParseUint(seqStr, 10, 64)
+	return ms, seq
+}
@@ -1,182 +1,148 @@
 package cache
 
 import (
+	"context"
 	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/dal"
+	"log"
 	"strconv"
 )
 
 // WriteFavoriteList 根据用户 id 从 MySQL 中读取点赞信息
-// 根据用户 id 建立 set
-func WriteFavoriteList(userId int64) ([]dal.Favorite, error) {
-	favoriteList, err := dal.GetFavoriteByUserId(userId)
+// 根据用户 id 建立 zset，以点赞时间作为 score
+func WriteFavoriteList(ctx context.Context, userId int64) ([]dal.Favorite, error) {
+	favoriteList, err := dal.Favorites.GetByUserId(ctx, userId)
 	if err != nil {
 		return []dal.Favorite{}, err
 	}
 	key := FavoriteKey(userId)
 	for _, favorite := range favoriteList {
-		if err := RDB.SAdd(CTX, key, favorite.VideoId).Err(); err != nil {
+		if err := store.ZAdd(ctx, key, Z{Score: float64(favorite.CreatedAt), Member: favorite.VideoId}); err != nil {
 			return []dal.Favorite{}, err
 		}
 	}
 	// 整体设置一次过期时间
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 		return []dal.Favorite{}, err
 	}
 	return favoriteList, err
 }
 
 // ReadFavorite 查询用户是否点过赞，未命中则从 MySQL 中读取
-func ReadFavorite(userId, videoId int64) (bool, error) {
+func ReadFavorite(ctx context.Context, userId, videoId int64) (bool, error) {
 	key := FavoriteKey(userId)
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
 		return false, err
 	}
 	// 未命中，先从数据库中提取用户的点赞记录并写入
 	if n <= 0 {
-		if _, err := WriteFavoriteList(userId); err != nil {
+		if _, err := WriteFavoriteList(ctx, userId); err != nil {
 			return false, err
 		}
 	}
-	// 在用户点赞 set 中查询是否点赞
+	// 在用户点赞 zset 中查询是否点赞
 	videoIdStr := strconv.FormatInt(videoId, 10)
-	isFavorite, err := RDB.SIsMember(CTX, key, videoIdStr).Result()
-	if err != nil {
+	isFavorite := true
+	if _, err := store.ZScore(ctx, key, videoIdStr); err == Nil {
+		isFavorite = false
+	} else if err != nil {
 		return false, err
 	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 		return false, err
 	}
 	return isFavorite, nil
 }
 
-// ReadFavoriteList 查询用户点赞视频列表，未命中则从 MySQL 中读取
-// userA 是当前登录用户 userB 是查询用户
-func ReadFavoriteList(userAId, userBId int64) ([]dal.Video, error) {
+// ReadFavoriteList 按点赞时间倒序分页查询用户点赞视频列表，未命中则从 MySQL 中读取
+// userA 是当前登录用户 userB 是查询用户，cursor 为上一页最后一个视频的点赞时间和视频 id，首页为零值
+// 返回下一页的 cursor 以及是否还有下一页
+func ReadFavoriteList(ctx context.Context, userAId, userBId int64, cursor Cursor, count int) ([]dal.Video, Cursor, bool, error) {
 	key := FavoriteKey(userBId)
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
-		return []dal.Video{}, err
+		return []dal.Video{}, cursor, false, err
 	}
-	var videoList []dal.Video
 	// 未命中，先从数据库中提取用户的点赞记录并写入
 	if n <= 0 {
-		favoriteList, err := WriteFavoriteList(userBId)
-		if err != nil {
-			return []dal.Video{}, err
+		if _, err := WriteFavoriteList(ctx, userBId); err != nil {
+			return []dal.Video{}, cursor, false, err
 		}
-		for _, favorite := range favoriteList {
-			// 根据 id 查找视频，先查 Redis 再查 MySQL
-			video, err := ReadVideo(favorite.VideoId)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 查找当前登录用户是否点过赞
-			video.IsFavorite, err = ReadFavorite(userAId, video.Id)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 查找视频对应的用户
-			video.Author, err = ReadUser(video.UserId)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 查找是否关注了这个用户
-			video.Author.IsFollow, err = ReadRelation(userAId, video.UserId)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			videoList = append(videoList, video)
+	}
+	zList, nextCursor, hasMore, err := zPage(ctx, key, cursor, count, true)
+	if err != nil {
+		return []dal.Video{}, cursor, false, err
+	}
+	// 更新过期时间
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return []dal.Video{}, cursor, false, err
+	}
+	videoList := make([]dal.Video, 0, len(zList))
+	for _, z := range zList {
+		videoId, err := strconv.ParseInt(z.Member.(string), 10, 64)
+		if err != nil {
+			return []dal.Video{}, cursor, false, err
 		}
-	} else { // 命中
-		videoIdStrList, err := RDB.SMembers(CTX, key).Result()
+		// 根据 id 查找视频，先查 Redis 再查 MySQL
+		video, err := ReadVideo(ctx, videoId)
 		if err != nil {
-			return []dal.Video{}, err
+			return []dal.Video{}, cursor, false, err
 		}
-		// 更新过期时间
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
-			return []dal.Video{}, err
+		// 查找当前登录用户是否点过赞
+		video.IsFavorite, err = ReadFavorite(ctx, userAId, video.Id)
+		if err != nil {
+			return []dal.Video{}, cursor, false, err
 		}
-		for _, videoIdStr := range videoIdStrList {
-			videoId, err := strconv.ParseInt(videoIdStr, 10, 64)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 根据 id 查找视频，先查 Redis 再查 MySQL
-			video, err := ReadVideo(videoId)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 查找当前登录用户是否点过赞
-			video.IsFavorite, err = ReadFavorite(userAId, video.Id)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 查找视频对应的用户
-			video.Author, err = ReadUser(video.UserId)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			// 查找是否关注了这个用户
-			video.Author.IsFollow, err = ReadRelation(userAId, video.UserId)
-			if err != nil {
-				return []dal.Video{}, err
-			}
-			videoList = append(videoList, video)
+		// 查找是否关注了这个用户，作者信息已在 ReadVideo 中读取
+		video.Author.IsFollow, err = ReadRelation(ctx, userAId, video.UserId)
+		if err != nil {
+			return []dal.Video{}, cursor, false, err
 		}
+		videoList = append(videoList, video)
+	}
+	videoList, err = filterVisibleVideos(ctx, userAId, videoList)
+	if err != nil {
+		return []dal.Video{}, cursor, false, err
 	}
-	return videoList, nil
+	return videoList, nextCursor, hasMore, nil
 }
 
-// AddFavorite 有新点赞时，先写入 MySQL 再写入 Redis
-// 需要删除 Redis 中涉及到的视频，采用延迟双删
-func AddFavorite(userId, videoId int64) error {
+// AddFavorite 有新点赞时，先写入 MySQL 再写入 Redis，采用延迟双删
+// 第二次删除由发件箱 relay 在事务提交 OutboxDelay 之后执行，失败时重试
+func AddFavorite(ctx context.Context, userId, videoId int64) error {
 	// Redis 第一次删除视频
-	if err := DeleteVideo(videoId); err != nil {
+	if err := DeleteVideo(ctx, videoId); err != nil {
 		return err
 	}
-	// 写入 MySQL
-	if err := dal.AddFavorite(userId, videoId); err != nil {
+	// 写入 MySQL，同一事务中记录发件箱任务
+	if _, err := dal.Favorites.Add(ctx, userId, videoId); err != nil {
 		return err
 	}
-	// Redis 第二次删除视频
-	if err := DeleteVideo(videoId); err != nil {
-		return err
-	}
-	// 写入 Redis
-	key := FavoriteKey(userId)
-	if err := RDB.SAdd(CTX, key, videoId).Err(); err != nil {
-		return err
-	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
-		return err
+	// 立即同步一次，让用户马上看到结果，失败时由 relay 补齐
+	if err := syncFavorite(ctx, dal.FavoriteChange{UserId: userId, VideoId: videoId}); err != nil {
+		log.Println(err)
 	}
 	return nil
 }
 
-// DeleteFavorite 取消点赞时，采用延迟双删确保一致性
-func DeleteFavorite(userId, videoId int64) error {
+// DeleteFavorite 取消点赞时，采用延迟双删确保一致性，第二次删除由发件箱 relay 执行
+func DeleteFavorite(ctx context.Context, userId, videoId int64) error {
 	// Redis 第一次删除点赞
-	key := FavoriteKey(userId)
-	if err := RDB.SRem(CTX, key, videoId).Err(); err != nil {
+	if err := store.ZRem(ctx, FavoriteKey(userId), videoId); err != nil {
 		return err
 	}
 	// Redis 第一次删除视频
-	if err := DeleteVideo(videoId); err != nil {
+	if err := DeleteVideo(ctx, videoId); err != nil {
 		return err
 	}
-	// MySQL 删除
-	if err := dal.DeleteFavorite(userId, videoId); err != nil {
+	// MySQL 删除，同一事务中记录发件箱任务
+	if err := dal.Favorites.Delete(ctx, userId, videoId); err != nil {
 		return err
 	}
-	// Redis 第二次删除视频
-	if err := DeleteVideo(videoId); err != nil {
-		return err
-	}
-	// Redis 第二次删除点赞
-	if err := RDB.SRem(CTX, key, videoId).Err(); err != nil {
-		return err
+	// 立即同步一次，失败时由 relay 补齐
+	if err := syncFavorite(ctx, dal.FavoriteChange{UserId: userId, VideoId: videoId}); err != nil {
+		log.Println(err)
 	}
 	return nil
 }
@@ -0,0 +1,810 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt    = errors.New("ERR value is not an integer or out of range")
+	errNoKey     = errors.New("ERR no such key")
+	errNoGroup   = errors.New("NOGROUP No such key or consumer group")
+)
+
+// memoryCache 进程内的缓存后端，用于本地开发和测试，数据不在多个实例之间共享
+// 过期的 key 在下次访问时删除
+type memoryCache struct {
+	mu          sync.Mutex
+	data        map[string]*memoryEntry
+	streamWake  chan struct{} // 有新的 stream 消息时关闭并重建，唤醒阻塞中的 XReadGroup
+	subscribers map[string]map[*memoryPubSub]bool
+}
+
+type memoryEntry struct {
+	value    interface{} // string、map[string]string（hash）、map[string]bool（set）、map[string]float64（有序集合）或 *memoryStream
+	expireAt time.Time   // 零值表示不过期
+}
+
+type memoryStream struct {
+	messages []XMessage
+	lastMs   uint64
+	lastSeq  uint64
+	groups   map[string]*memoryGroup
+}
+
+type memoryGroup struct {
+	lastId  string // 最后一条投递给消费者的消息
+	pending map[string]*memoryPending
+}
+
+type memoryPending struct {
+	consumer    string
+	deliveredAt time.Time
+	count       int64
+}
+
+// NewMemoryCache 创建进程内的缓存后端
+func NewMemoryCache() Cache {
+	return &memoryCache{
+		data:        make(map[string]*memoryEntry),
+		streamWake:  make(chan struct{}),
+		subscribers: make(map[string]map[*memoryPubSub]bool),
+	}
+}
+
+// entry 获取未过期的 key，调用时需要持有锁
+func (m *memoryCache) entry(key string) *memoryEntry {
+	entry, ok := m.data[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expireAt.IsZero() && !time.Now().Before(entry.expireAt) {
+		delete(m.data, key)
+		return nil
+	}
+	return entry
+}
+
+// memoryValue 获取 key 中指定类型的值，key 不存在时若 create 为 nil 则返回零值，否则写入 create 的结果
+func memoryValue[T any](m *memoryCache, key string, create func() T) (T, error) {
+	var zero T
+	entry := m.entry(key)
+	if entry == nil {
+		if create == nil {
+			return zero, nil
+		}
+		value := create()
+		m.data[key] = &memoryEntry{value: value}
+		return value, nil
+	}
+	value, ok := entry.value.(T)
+	if !ok {
+		return zero, errWrongType
+	}
+	return value, nil
+}
+
+func newHash() map[string]string  { return make(map[string]string) }
+func newSet() map[string]bool     { return make(map[string]bool) }
+func newZSet() map[string]float64 { return make(map[string]float64) }
+func newStream() *memoryStream    { return &memoryStream{groups: make(map[string]*memoryGroup)} }
+func expireAt(exp time.Duration) time.Time {
+	if exp <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(exp)
+}
+
+func (m *memoryCache) Exists(_ context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if m.entry(key) != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryCache) Del(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.data, key)
+	}
+	return nil
+}
+
+func (m *memoryCache) Expire(_ context.Context, key string, exp time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.entry(key)
+	if entry == nil {
+		return nil
+	}
+	if exp <= 0 {
+		delete(m.data, key)
+		return nil
+	}
+	entry.expireAt = time.Now().Add(exp)
+	return nil
+}
+
+func (m *memoryCache) Rename(_ context.Context, key, newKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.entry(key)
+	if entry == nil {
+		return errNoKey
+	}
+	delete(m.data, key)
+	m.data[newKey] = entry
+	return nil
+}
+
+func (m *memoryCache) Get(_ context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.entry(key)
+	if entry == nil {
+		return "", Nil
+	}
+	str, ok := entry.value.(string)
+	if !ok {
+		return "", errWrongType
+	}
+	return str, nil
+}
+
+func (m *memoryCache) Set(_ context.Context, key string, value interface{}, exp time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = &memoryEntry{value: toString(value), expireAt: expireAt(exp)}
+	return nil
+}
+
+func (m *memoryCache) SetNX(_ context.Context, key string, value interface{}, exp time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entry(key) != nil {
+		return false, nil
+	}
+	m.data[key] = &memoryEntry{value: toString(value), expireAt: expireAt(exp)}
+	return true, nil
+}
+
+func (m *memoryCache) Incr(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.entry(key)
+	if entry == nil {
+		m.data[key] = &memoryEntry{value: "1"}
+		return 1, nil
+	}
+	str, ok := entry.value.(string)
+	if !ok {
+		return 0, errWrongType
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, errNotInt
+	}
+	n++
+	entry.value = strconv.FormatInt(n, 10) // 保留过期时间
+	return n, nil
+}
+
+func (m *memoryCache) HGet(_ context.Context, key, field string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, err := memoryValue[map[string]string](m, key, nil)
+	if err != nil {
+		return "", err
+	}
+	value, ok := hash[field]
+	if !ok {
+		return "", Nil
+	}
+	return value, nil
+}
+
+func (m *memoryCache) HGetAll(_ context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, err := memoryValue[map[string]string](m, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(hash))
+	for field, value := range hash {
+		result[field] = value
+	}
+	return result, nil
+}
+
+func (m *memoryCache) HSet(_ context.Context, key, field string, value interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, err := memoryValue(m, key, newHash)
+	if err != nil {
+		return err
+	}
+	hash[field] = toString(value)
+	return nil
+}
+
+func (m *memoryCache) HSetNX(_ context.Context, key, field string, value interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, err := memoryValue(m, key, newHash)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := hash[field]; ok {
+		return false, nil
+	}
+	hash[field] = toString(value)
+	return true, nil
+}
+
+func (m *memoryCache) HIncrBy(_ context.Context, key, field string, incr int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, err := memoryValue(m, key, newHash)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if str, ok := hash[field]; ok {
+		if n, err = strconv.ParseInt(str, 10, 64); err != nil {
+			return 0, errNotInt
+		}
+	}
+	n += incr
+	hash[field] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (m *memoryCache) SAdd(_ context.Context, key string, members ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, err := memoryValue(m, key, newSet)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		set[toString(member)] = true
+	}
+	return nil
+}
+
+func (m *memoryCache) SRem(_ context.Context, key string, members ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, err := memoryValue[map[string]bool](m, key, nil)
+	if err != nil || set == nil {
+		return err
+	}
+	for _, member := range members {
+		delete(set, toString(member))
+	}
+	if len(set) == 0 { // 与 Redis 一致，空集合会被删除
+		delete(m.data, key)
+	}
+	return nil
+}
+
+func (m *memoryCache) SMembers(_ context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, err := memoryValue[map[string]bool](m, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	memberList := make([]string, 0, len(set))
+	for member := range set {
+		memberList = append(memberList, member)
+	}
+	return memberList, nil
+}
+
+func (m *memoryCache) SIsMember(_ context.Context, key string, member interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, err := memoryValue[map[string]bool](m, key, nil)
+	if err != nil {
+		return false, err
+	}
+	return set[toString(member)], nil
+}
+
+func (m *memoryCache) ZAdd(_ context.Context, key string, members ...Z) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue(m, key, newZSet)
+	if err != nil {
+		return err
+	}
+	for _, z := range members {
+		zset[toString(z.Member)] = z.Score
+	}
+	return nil
+}
+
+func (m *memoryCache) ZIncrXX(_ context.Context, key string, member Z) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue[map[string]float64](m, key, nil)
+	if err != nil {
+		return err
+	}
+	name := toString(member.Member)
+	if score, ok := zset[name]; ok {
+		zset[name] = score + member.Score
+	}
+	return nil
+}
+
+func (m *memoryCache) ZRem(_ context.Context, key string, members ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue[map[string]float64](m, key, nil)
+	if err != nil || zset == nil {
+		return err
+	}
+	for _, member := range members {
+		delete(zset, toString(member))
+	}
+	if len(zset) == 0 {
+		delete(m.data, key)
+	}
+	return nil
+}
+
+func (m *memoryCache) ZRemRangeByRank(_ context.Context, key string, start, stop int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue[map[string]float64](m, key, nil)
+	if err != nil || zset == nil {
+		return err
+	}
+	for _, z := range rankRange(sortZSet(zset, false), start, stop) {
+		delete(zset, z.Member.(string))
+	}
+	if len(zset) == 0 {
+		delete(m.data, key)
+	}
+	return nil
+}
+
+func (m *memoryCache) ZScore(_ context.Context, key string, member interface{}) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue[map[string]float64](m, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := zset[toString(member)]
+	if !ok {
+		return 0, Nil
+	}
+	return score, nil
+}
+
+func (m *memoryCache) ZCard(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue[map[string]float64](m, key, nil)
+	return int64(len(zset)), err
+}
+
+func (m *memoryCache) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return members(m.ZRangeWithScores(ctx, key, start, stop))
+}
+
+func (m *memoryCache) ZRevRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return members(m.ZRevRangeWithScores(ctx, key, start, stop))
+}
+
+func (m *memoryCache) ZRangeWithScores(_ context.Context, key string, start, stop int64) ([]Z, error) {
+	return m.zRange(key, false, func(zList []Z) []Z { return rankRange(zList, start, stop) })
+}
+
+func (m *memoryCache) ZRevRangeWithScores(_ context.Context, key string, start, stop int64) ([]Z, error) {
+	return m.zRange(key, true, func(zList []Z) []Z { return rankRange(zList, start, stop) })
+}
+
+func (m *memoryCache) ZRangeByScore(ctx context.Context, key string, by ZRangeBy) ([]string, error) {
+	return members(m.ZRangeByScoreWithScores(ctx, key, by))
+}
+
+func (m *memoryCache) ZRangeByScoreWithScores(_ context.Context, key string, by ZRangeBy) ([]Z, error) {
+	return m.zRange(key, false, func(zList []Z) []Z { return scoreRange(zList, by) })
+}
+
+func (m *memoryCache) ZRevRangeByScore(ctx context.Context, key string, by ZRangeBy) ([]string, error) {
+	return members(m.ZRevRangeByScoreWithScores(ctx, key, by))
+}
+
+func (m *memoryCache) ZRevRangeByScoreWithScores(_ context.Context, key string, by ZRangeBy) ([]Z, error) {
+	return m.zRange(key, true, func(zList []Z) []Z { return scoreRange(zList, by) })
+}
+
+// zRange 将有序集合排序后交给 pick 选出结果
+func (m *memoryCache) zRange(key string, rev bool, pick func(zList []Z) []Z) ([]Z, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zset, err := memoryValue[map[string]float64](m, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pick(sortZSet(zset, rev)), nil
+}
+
+// sortZSet 与 Redis 一致，按分数排序，分数相同时按成员的字典序排序
+func sortZSet(zset map[string]float64, rev bool) []Z {
+	zList := make([]Z, 0, len(zset))
+	for member, score := range zset {
+		zList = append(zList, Z{Score: score, Member: member})
+	}
+	sort.Slice(zList, func(i, j int) bool {
+		less := zList[i].Score < zList[j].Score ||
+			(zList[i].Score == zList[j].Score && zList[i].Member.(string) < zList[j].Member.(string))
+		if rev {
+			return !less
+		}
+		return less
+	})
+	return zList
+}
+
+// rankRange 按排名选出 [start, stop]，负数表示从末尾开始
+func rankRange(zList []Z, start, stop int64) []Z {
+	n := int64(len(zList))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []Z{}
+	}
+	return zList[start : stop+1]
+}
+
+// scoreRange 按分数范围选出，zList 已按读取的方向排序
+func scoreRange(zList []Z, by ZRangeBy) []Z {
+	min, minOpen := parseScoreBound(by.Min)
+	max, maxOpen := parseScoreBound(by.Max)
+	result := []Z{}
+	for _, z := range zList {
+		if z.Score < min || (minOpen && z.Score == min) || z.Score > max || (maxOpen && z.Score == max) {
+			continue
+		}
+		result = append(result, z)
+	}
+	if by.Offset == 0 && by.Count == 0 {
+		return result
+	}
+	if by.Offset >= int64(len(result)) {
+		return []Z{}
+	}
+	result = result[by.Offset:]
+	if by.Count >= 0 && by.Count < int64(len(result)) {
+		result = result[:by.Count]
+	}
+	return result
+}
+
+// parseScoreBound 解析分数边界，返回边界值和是否不包含边界，无法解析时视为 NaN，不匹配任何分数
+func parseScoreBound(bound string) (float64, bool) {
+	open := strings.HasPrefix(bound, "(")
+	bound = strings.TrimPrefix(bound, "(")
+	switch bound {
+	case "-inf":
+		return math.Inf(-1), open
+	case "+inf", "inf":
+		return math.Inf(1), open
+	}
+	score, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return math.NaN(), open
+	}
+	return score, open
+}
+
+func members(zList []Z, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	memberList := make([]string, 0, len(zList))
+	for _, z := range zList {
+		memberList = append(memberList, z.Member.(string))
+	}
+	return memberList, nil
+}
+
+func (m *memoryCache) XAdd(_ context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := memoryValue(m, stream, newStream)
+	if err != nil {
+		return "", err
+	}
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= s.lastMs {
+		s.lastSeq++
+	} else {
+		s.lastMs, s.lastSeq = ms, 0
+	}
+	id := strconv.FormatUint(s.lastMs, 10) + "-" + strconv.FormatUint(s.lastSeq, 10)
+	message := XMessage{Id: id, Values: make(map[string]string, len(values))}
+	for field, value := range values {
+		message.Values[field] = toString(value)
+	}
+	s.messages = append(s.messages, message)
+	if maxLen > 0 && int64(len(s.messages)) > maxLen {
+		s.messages = append([]XMessage(nil), s.messages[int64(len(s.messages))-maxLen:]...)
+	}
+	close(m.streamWake)
+	m.streamWake = make(chan struct{})
+	return id, nil
+}
+
+func (m *memoryCache) XRange(_ context.Context, stream, start, stop string, count int64) ([]XMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := memoryValue[*memoryStream](m, stream, nil)
+	if err != nil || s == nil {
+		return []XMessage{}, err
+	}
+	messageList := []XMessage{}
+	for _, message := range s.messages {
+		if count > 0 && int64(len(messageList)) >= count {
+			break
+		}
+		if afterStart(message.Id, start) && beforeStop(message.Id, stop) {
+			messageList = append(messageList, message)
+		}
+	}
+	return messageList, nil
+}
+
+func afterStart(id, start string) bool {
+	if start == "-" {
+		return true
+	}
+	if strings.HasPrefix(start, "(") {
+		return CompareEventId(id, start[1:]) > 0
+	}
+	return CompareEventId(id, start) >= 0
+}
+
+func beforeStop(id, stop string) bool {
+	if stop == "+" {
+		return true
+	}
+	if strings.HasPrefix(stop, "(") {
+		return CompareEventId(id, stop[1:]) < 0
+	}
+	return CompareEventId(id, stop) <= 0
+}
+
+func (m *memoryCache) XGroupCreate(_ context.Context, stream, group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, err := memoryValue(m, stream, newStream)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.groups[group]; ok {
+		return nil
+	}
+	lastId := "0-0"
+	if len(s.messages) > 0 {
+		lastId = s.messages[len(s.messages)-1].Id
+	}
+	s.groups[group] = &memoryGroup{lastId: lastId, pending: make(map[string]*memoryPending)}
+	return nil
+}
+
+// group 获取消费者组，调用时需要持有锁
+func (m *memoryCache) group(stream, group string) (*memoryStream, *memoryGroup, error) {
+	s, err := memoryValue[*memoryStream](m, stream, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s == nil || s.groups[group] == nil {
+		return nil, nil, errNoGroup
+	}
+	return s, s.groups[group], nil
+}
+
+func (m *memoryCache) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]XMessage, error) {
+	var timeout <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		m.mu.Lock()
+		s, g, err := m.group(stream, group)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		var messageList []XMessage
+		for _, message := range s.messages {
+			if count > 0 && int64(len(messageList)) >= count {
+				break
+			}
+			if CompareEventId(message.Id, g.lastId) <= 0 {
+				continue
+			}
+			g.pending[message.Id] = &memoryPending{consumer: consumer, deliveredAt: time.Now(), count: 1}
+			g.lastId = message.Id
+			messageList = append(messageList, message)
+		}
+		wake := m.streamWake
+		m.mu.Unlock()
+		if len(messageList) > 0 {
+			return messageList, nil
+		}
+		select {
+		case <-wake:
+		case <-timeout:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *memoryCache) XAutoClaim(_ context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]XMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, g, err := m.group(stream, group)
+	if err != nil {
+		return nil, err
+	}
+	messageList := []XMessage{}
+	for _, message := range s.messages {
+		if count > 0 && int64(len(messageList)) >= count {
+			break
+		}
+		pending, ok := g.pending[message.Id]
+		if !ok || time.Since(pending.deliveredAt) < minIdle {
+			continue
+		}
+		pending.consumer = consumer
+		pending.deliveredAt = time.Now()
+		pending.count++
+		messageList = append(messageList, message)
+	}
+	// 与 Redis 一致，已被裁剪的消息不再待确认
+	for id := range g.pending {
+		if len(s.messages) == 0 || CompareEventId(id, s.messages[0].Id) < 0 {
+			delete(g.pending, id)
+		}
+	}
+	return messageList, nil
+}
+
+func (m *memoryCache) XDeliveries(_ context.Context, stream, group, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, g, err := m.group(stream, group)
+	if err != nil {
+		return 0, err
+	}
+	if pending, ok := g.pending[id]; ok {
+		return pending.count, nil
+	}
+	return 0, nil
+}
+
+func (m *memoryCache) XAck(_ context.Context, stream, group string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, g, err := m.group(stream, group)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		delete(g.pending, id)
+	}
+	return nil
+}
+
+func (m *memoryCache) Publish(_ context.Context, channel string, message interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	payload := toString(message)
+	for sub := range m.subscribers[channel] {
+		select {
+		case sub.channel <- Message{Channel: channel, Payload: payload}:
+		default: // 与 Redis 一致，消费过慢的订阅者会丢失消息
+		}
+	}
+	return nil
+}
+
+func (m *memoryCache) Subscribe(ctx context.Context, channels ...string) PubSub {
+	sub := &memoryPubSub{m: m, channel: make(chan Message, 100)}
+	_ = sub.Subscribe(ctx, channels...)
+	return sub
+}
+
+type memoryPubSub struct {
+	m       *memoryCache
+	channel chan Message
+	closed  bool
+}
+
+func (p *memoryPubSub) Subscribe(_ context.Context, channels ...string) error {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	if p.closed {
+		return errors.New("cache: pubsub is closed")
+	}
+	for _, channel := range channels {
+		if p.m.subscribers[channel] == nil {
+			p.m.subscribers[channel] = make(map[*memoryPubSub]bool)
+		}
+		p.m.subscribers[channel][p] = true
+	}
+	return nil
+}
+
+func (p *memoryPubSub) Unsubscribe(_ context.Context, channels ...string) error {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	for _, channel := range channels {
+		p.m.unsubscribe(channel, p)
+	}
+	return nil
+}
+
+// unsubscribe 调用时需要持有锁
+func (m *memoryCache) unsubscribe(channel string, p *memoryPubSub) {
+	delete(m.subscribers[channel], p)
+	if len(m.subscribers[channel]) == 0 {
+		delete(m.subscribers, channel)
+	}
+}
+
+func (p *memoryPubSub) Channel() <-chan Message {
+	return p.channel
+}
+
+func (p *memoryPubSub) Close() error {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	for channel, subs := range p.m.subscribers {
+		if subs[p] {
+			p.m.unsubscribe(channel, p)
+		}
+	}
+	p.closed = true
+	close(p.channel)
+	return nil
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryNil(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	m.Set(ctx, "string", "value", 0)
+	m.HSet(ctx, "hash", "field", "value")
+	m.ZAdd(ctx, "zset", Z{Score: 1, Member: "member"})
+	tests := []struct {
+		name string
+		read func() error
+	}{
+		{"Get 不存在的 key", func() error { _, err := m.Get(ctx, "missing"); return err }},
+		{"HGet 不存在的 key", func() error { _, err := m.HGet(ctx, "missing", "field"); return err }},
+		{"HGet 不存在的字段", func() error { _, err := m.HGet(ctx, "hash", "missing"); return err }},
+		{"ZScore 不存在的 key", func() error { _, err := m.ZScore(ctx, "missing", "member"); return err }},
+		{"ZScore 不存在的成员", func() error { _, err := m.ZScore(ctx, "zset", "missing"); return err }},
+	}
+	for _, test := range tests {
+		if err := test.read(); !errors.Is(err, Nil) {
+			t.Errorf("%s 返回 %v，应为 Nil", test.name, err)
+		}
+	}
+	// 读取集合类型时不存在的 key 视为空集合
+	if all, err := m.HGetAll(ctx, "missing"); err != nil || len(all) != 0 {
+		t.Errorf("HGetAll 不存在的 key 返回 %v %v", all, err)
+	}
+	if members, err := m.SMembers(ctx, "missing"); err != nil || len(members) != 0 {
+		t.Errorf("SMembers 不存在的 key 返回 %v %v", members, err)
+	}
+	if n, err := m.ZCard(ctx, "missing"); err != nil || n != 0 {
+		t.Errorf("ZCard 不存在的 key 返回 %v %v", n, err)
+	}
+	// 类型不符时返回错误而不是 Nil
+	if _, err := m.HGet(ctx, "string", "field"); err == nil || errors.Is(err, Nil) {
+		t.Errorf("对字符串执行 HGet 返回 %v", err)
+	}
+}
+
+func TestMemoryZRangeBy(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	// 分数相同的成员按字典序排列
+	m.ZAdd(ctx, "zset", Z{Score: 1, Member: "a"}, Z{Score: 2, Member: "b"}, Z{Score: 2, Member: "c"}, Z{Score: 3, Member: "d"}, Z{Score: 4, Member: "e"})
+	tests := []struct {
+		by      ZRangeBy
+		reverse bool
+		want    []string
+	}{
+		{ZRangeBy{Min: "-inf", Max: "+inf"}, false, []string{"a", "b", "c", "d", "e"}},
+		{ZRangeBy{Min: "2", Max: "3"}, false, []string{"b", "c", "d"}},
+		{ZRangeBy{Min: "(2", Max: "3"}, false, []string{"d"}},
+		{ZRangeBy{Min: "2", Max: "(3"}, false, []string{"b", "c"}},
+		{ZRangeBy{Min: "(1", Max: "(4"}, false, []string{"b", "c", "d"}},
+		{ZRangeBy{Min: "-inf", Max: "+inf", Offset: 1, Count: 2}, false, []string{"b", "c"}},
+		{ZRangeBy{Min: "-inf", Max: "+inf", Offset: 4, Count: 2}, false, []string{"e"}},
+		{ZRangeBy{Min: "-inf", Max: "+inf", Offset: 5}, false, []string{}},
+		{ZRangeBy{Min: "-inf", Max: "+inf"}, true, []string{"e", "d", "c", "b", "a"}},
+		{ZRangeBy{Min: "-inf", Max: "(3"}, true, []string{"c", "b", "a"}},
+		{ZRangeBy{Min: "(1", Max: "3", Offset: 1, Count: 1}, true, []string{"c"}},
+		{ZRangeBy{Min: "5", Max: "+inf"}, false, []string{}},
+	}
+	for _, test := range tests {
+		var got []string
+		var err error
+		if test.reverse {
+			got, err = m.ZRevRangeByScore(ctx, "zset", test.by)
+		} else {
+			got, err = m.ZRangeByScore(ctx, "zset", test.by)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v（reverse=%v）返回 %v，应为 %v", test.by, test.reverse, got, test.want)
+		}
+	}
+	zList, err := m.ZRevRangeByScoreWithScores(ctx, "zset", ZRangeBy{Min: "2", Max: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Z{{Score: 2, Member: "c"}, {Score: 2, Member: "b"}}; !reflect.DeepEqual(zList, want) {
+		t.Errorf("ZRevRangeByScoreWithScores 返回 %v，应为 %v", zList, want)
+	}
+}
+
+func TestMemoryZIncrXX(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	// key 不存在时不创建
+	if err := m.ZIncrXX(ctx, "zset", Z{Score: 1, Member: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := m.Exists(ctx, "zset"); n != 0 {
+		t.Fatal("ZIncrXX 创建了不存在的 key")
+	}
+	m.ZAdd(ctx, "zset", Z{Score: 1, Member: "a"})
+	// 成员不存在时不添加
+	if err := m.ZIncrXX(ctx, "zset", Z{Score: 1, Member: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ZScore(ctx, "zset", "b"); !errors.Is(err, Nil) {
+		t.Fatalf("ZIncrXX 添加了不存在的成员：%v", err)
+	}
+	if err := m.ZIncrXX(ctx, "zset", Z{Score: 2, Member: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if score, err := m.ZScore(ctx, "zset", "a"); err != nil || score != 3 {
+		t.Fatalf("ZIncrXX 后分数为 %v %v", score, err)
+	}
+}
+
+func TestMemoryStreamGroup(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	values := map[string]interface{}{"data": "x"}
+	// 消费者组只接收创建之后的消息
+	m.XAdd(ctx, "stream", 100, values)
+	if err := m.XGroupCreate(ctx, "stream", "group"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.XGroupCreate(ctx, "stream", "group"); err != nil {
+		t.Fatalf("重复创建消费者组返回 %v", err)
+	}
+	first, _ := m.XAdd(ctx, "stream", 100, values)
+	second, _ := m.XAdd(ctx, "stream", 100, values)
+
+	messageList, err := m.XReadGroup(ctx, "stream", "group", "a", 10, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messageList) != 2 || messageList[0].Id != first || messageList[1].Id != second || messageList[0].Values["data"] != "x" {
+		t.Fatalf("XReadGroup 返回 %+v", messageList)
+	}
+	// 已投递的消息不再返回，没有新消息时阻塞 block 后返回空
+	if messageList, err := m.XReadGroup(ctx, "stream", "group", "a", 10, time.Millisecond); err != nil || len(messageList) != 0 {
+		t.Fatalf("再次 XReadGroup 返回 %+v %v", messageList, err)
+	}
+	for _, id := range []string{first, second} {
+		if n, err := m.XDeliveries(ctx, "stream", "group", id); err != nil || n != 1 {
+			t.Fatalf("%s 的投递次数为 %d %v", id, n, err)
+		}
+	}
+	if err := m.XAck(ctx, "stream", "group", first); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := m.XDeliveries(ctx, "stream", "group", first); n != 0 {
+		t.Fatalf("确认后的投递次数为 %d", n)
+	}
+	// 未超过 minIdle 的消息不能认领，超过后由新的消费者认领并增加投递次数
+	if messageList, err := m.XAutoClaim(ctx, "stream", "group", "b", time.Hour, 10); err != nil || len(messageList) != 0 {
+		t.Fatalf("XAutoClaim 认领了未超时的消息：%+v %v", messageList, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	messageList, err = m.XAutoClaim(ctx, "stream", "group", "b", time.Millisecond, 10)
+	if err != nil || len(messageList) != 1 || messageList[0].Id != second {
+		t.Fatalf("XAutoClaim 返回 %+v %v", messageList, err)
+	}
+	if n, _ := m.XDeliveries(ctx, "stream", "group", second); n != 2 {
+		t.Fatalf("认领后的投递次数为 %d", n)
+	}
+	// 不同的消费者组各自独立投递
+	if err := m.XGroupCreate(ctx, "stream", "other"); err != nil {
+		t.Fatal(err)
+	}
+	third, _ := m.XAdd(ctx, "stream", 100, values)
+	for _, group := range []string{"group", "other"} {
+		messageList, err := m.XReadGroup(ctx, "stream", group, "a", 10, time.Millisecond)
+		if err != nil || len(messageList) != 1 || messageList[0].Id != third {
+			t.Fatalf("消费者组 %s 读取到 %+v %v", group, messageList, err)
+		}
+	}
+	// 不存在的消费者组返回错误
+	if _, err := m.XReadGroup(ctx, "stream", "missing", "a", 10, time.Millisecond); err == nil {
+		t.Fatal("读取不存在的消费者组没有返回错误")
+	}
+}
+
+func TestMemoryExpire(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	m.Set(ctx, "string", "value", 10*time.Millisecond)
+	m.HSet(ctx, "hash", "field", "value")
+	m.Expire(ctx, "hash", 10*time.Millisecond)
+	m.Set(ctx, "forever", "value", 0)
+	if n, _ := m.Exists(ctx, "string", "hash", "forever"); n != 3 {
+		t.Fatalf("过期之前存在 %d 个 key", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n, _ := m.Exists(ctx, "string", "hash", "forever"); n != 1 {
+		t.Fatalf("过期之后存在 %d 个 key", n)
+	}
+	if _, err := m.Get(ctx, "string"); !errors.Is(err, Nil) {
+		t.Fatalf("读取过期的 key 返回 %v", err)
+	}
+	// 过期的 key 可以重新写入，SetNX 视为不存在
+	if ok, err := m.SetNX(ctx, "string", "again", 0); err != nil || !ok {
+		t.Fatalf("SetNX 过期的 key 返回 %v %v", ok, err)
+	}
+	// 重新写入不带过期时间的值时清除原有的过期时间
+	m.Set(ctx, "renew", "value", 10*time.Millisecond)
+	m.Set(ctx, "renew", "value", 0)
+	time.Sleep(20 * time.Millisecond)
+	if n, _ := m.Exists(ctx, "renew"); n != 1 {
+		t.Fatal("重新写入后仍然过期")
+	}
+}
+
+func TestMemoryPubSub(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	pubsub := m.Subscribe(ctx, "channel")
+	defer pubsub.Close()
+	m.Publish(ctx, "other", "ignored")
+	m.Publish(ctx, "channel", "hello")
+	select {
+	case message := <-pubsub.Channel():
+		if message.Channel != "channel" || message.Payload != "hello" {
+			t.Fatalf("收到 %+v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("没有收到消息")
+	}
+}
@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"gorm.io/gorm"
+	"strconv"
+)
+
+// writeMessage 将私信写入 hash
+func writeMessage(ctx context.Context, message dal.Message) error {
+	key := MessageKey(message.Id)
+	if err := RedisStructHash(ctx, message, key); err != nil {
+		return err
+	}
+	return store.Expire(ctx, key, config.RedisExp)
+}
+
+// ReadMessage 先在 Redis 中查找私信，若无则从 MySQL 中读取
+func ReadMessage(ctx context.Context, messageId int64) (dal.Message, error) {
+	key := MessageKey(messageId)
+	n, err := store.Exists(ctx, key)
+	if err != nil {
+		return dal.Message{}, err
+	}
+	if n <= 0 { // 未命中，读取 MySQL
+		message, err := dal.GetMessageById(ctx, messageId)
+		if err != nil {
+			return dal.Message{}, err
+		}
+		return message, writeMessage(ctx, message)
+	}
+	message, err := ReadMessageFromHash(ctx, key)
+	if err != nil {
+		return dal.Message{}, err
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return dal.Message{}, err
+	}
+	return message, nil
+}
+
+// WriteChat 从 MySQL 中读取两人之间最新的 MessageCacheSize 条私信，以发送时间作为 score 写入 zset
+func WriteChat(ctx context.Context, userAId, userBId int64) error {
+	messageList, err := dal.GetMessageList(ctx, userAId, userBId, 0, config.MessageCacheSize)
+	if err != nil {
+		return err
+	}
+	chatKey := ChatKey(dal.ChatId(userAId, userBId))
+	for _, message := range messageList {
+		if err := store.ZAdd(ctx, chatKey, Z{Score: float64(message.CreateTime), Member: message.Id}); err != nil {
+			return err
+		}
+		if err := writeMessage(ctx, message); err != nil {
+			return err
+		}
+	}
+	// zset 整体设置一次过期时间即可
+	return store.Expire(ctx, chatKey, config.RedisExp)
+}
+
+// ReadMessageList 读取两人之间的私信，按发送时间正序排列
+// preMsgTime 大于 0 时返回其后发送的最多 count 条，否则返回最新的 count 条
+// Redis 中只缓存最新的 MessageCacheSize 条，更早的私信从 MySQL 中读取
+func ReadMessageList(ctx context.Context, userAId, userBId, preMsgTime int64, count int) ([]dal.Message, error) {
+	chatKey := ChatKey(dal.ChatId(userAId, userBId))
+	n, err := store.Exists(ctx, chatKey)
+	if err != nil {
+		return []dal.Message{}, err
+	}
+	if n <= 0 { // 未命中，从数据库中读取并写入
+		if err := WriteChat(ctx, userAId, userBId); err != nil {
+			return []dal.Message{}, err
+		}
+	}
+	if err := store.Expire(ctx, chatKey, config.RedisExp); err != nil {
+		return []dal.Message{}, err
+	}
+	var messageIdStrList []string
+	if preMsgTime > 0 {
+		// 缓存已满且 preMsgTime 早于缓存中最早的私信时，缓存中可能缺少部分私信
+		card, err := store.ZCard(ctx, chatKey)
+		if err != nil {
+			return []dal.Message{}, err
+		}
+		if card >= config.MessageCacheSize {
+			oldest, err := store.ZRangeWithScores(ctx, chatKey, 0, 0)
+			if err != nil {
+				return []dal.Message{}, err
+			}
+			if len(oldest) > 0 && float64(preMsgTime) < oldest[0].Score {
+				return dal.GetMessageList(ctx, userAId, userBId, preMsgTime, count)
+			}
+		}
+		opt := ZRangeBy{Min: "(" + strconv.FormatInt(preMsgTime, 10), Max: "+inf", Count: int64(count)}
+		messageIdStrList, err = store.ZRangeByScore(ctx, chatKey, opt)
+		if err != nil {
+			return []dal.Message{}, err
+		}
+	} else {
+		messageIdStrList, err = store.ZRevRange(ctx, chatKey, 0, int64(count)-1)
+		if err != nil {
+			return []dal.Message{}, err
+		}
+		for i, j := 0, len(messageIdStrList)-1; i < j; i, j = i+1, j-1 {
+			messageIdStrList[i], messageIdStrList[j] = messageIdStrList[j], messageIdStrList[i]
+		}
+	}
+	messageList := make([]dal.Message, 0, len(messageIdStrList))
+	for _, messageIdStr := range messageIdStrList {
+		messageId, err := strconv.ParseInt(messageIdStr, 10, 64)
+		if err != nil {
+			return []dal.Message{}, err
+		}
+		message, err := ReadMessage(ctx, messageId)
+		if err != nil {
+			return []dal.Message{}, err
+		}
+		messageList = append(messageList, message)
+	}
+	return messageList, nil
+}
+
+// deleteConversations 删除双方的会话 hash，下次读取时从 MySQL 重新写入
+func deleteConversations(ctx context.Context, userAId, userBId int64) error {
+	return store.Del(ctx, ConversationKey(userAId, userBId), ConversationKey(userBId, userAId))
+}
+
+// AddMessage 发送私信，先写入 MySQL 再写入 Redis
+// 双方的会话 hash 采用延迟双删，会话列表和私信列表已缓存时直接加入
+func AddMessage(ctx context.Context, message dal.Message) (dal.Message, error) {
+	// Redis 第一次删除会话
+	if err := deleteConversations(ctx, message.FromUserId, message.ToUserId); err != nil {
+		return dal.Message{}, err
+	}
+	// 写入 MySQL
+	message, err := dal.AddMessage(ctx, message)
+	if err != nil {
+		return dal.Message{}, err
+	}
+	// Redis 第二次删除会话
+	if err := deleteConversations(ctx, message.FromUserId, message.ToUserId); err != nil {
+		return dal.Message{}, err
+	}
+	// 加入私信列表，只保留最新的 MessageCacheSize 条
+	chatKey := ChatKey(message.ChatId)
+	n, err := store.Exists(ctx, chatKey)
+	if err != nil {
+		return dal.Message{}, err
+	}
+	if n > 0 {
+		if err := writeMessage(ctx, message); err != nil {
+			return dal.Message{}, err
+		}
+		if err := store.ZAdd(ctx, chatKey, Z{Score: float64(message.CreateTime), Member: message.Id}); err != nil {
+			return dal.Message{}, err
+		}
+		if err := store.ZRemRangeByRank(ctx, chatKey, 0, -config.MessageCacheSize-1); err != nil {
+			return dal.Message{}, err
+		}
+	}
+	// 双方的会话移到会话列表最前面
+	for _, userId := range []int64{message.FromUserId, message.ToUserId} {
+		peerId := message.ToUserId
+		if userId == message.ToUserId {
+			peerId = message.FromUserId
+		}
+		listKey := ConversationListKey(userId)
+		n, err := store.Exists(ctx, listKey)
+		if err != nil {
+			return dal.Message{}, err
+		}
+		if n <= 0 {
+			continue
+		}
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(message.CreateTime), Member: peerId}); err != nil {
+			return dal.Message{}, err
+		}
+	}
+	return message, nil
+}
+
+// writeConversation 将会话写入 hash
+func writeConversation(ctx context.Context, conversation dal.Conversation) error {
+	key := ConversationKey(conversation.UserId, conversation.PeerId)
+	if err := RedisStructHash(ctx, conversation, key); err != nil {
+		return err
+	}
+	return store.Expire(ctx, key, config.RedisExp)
+}
+
+// ReadConversation 先在 Redis 中查找会话，若无则从 MySQL 中读取
+func ReadConversation(ctx context.Context, userId, peerId int64) (dal.Conversation, error) {
+	key := ConversationKey(userId, peerId)
+	n, err := store.Exists(ctx, key)
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	if n <= 0 { // 未命中，读取 MySQL
+		conversation, err := dal.GetConversation(ctx, userId, peerId)
+		if err != nil {
+			return dal.Conversation{}, err
+		}
+		return conversation, writeConversation(ctx, conversation)
+	}
+	conversation, err := ReadConversationFromHash(ctx, key)
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return dal.Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// WriteConversationList 从 MySQL 中读取用户最近的会话，以最后一条私信的时间作为 score 写入 zset
+func WriteConversationList(ctx context.Context, userId int64) error {
+	conversationList, err := dal.GetConversationList(ctx, userId, config.MaxConversations)
+	if err != nil {
+		return err
+	}
+	listKey := ConversationListKey(userId)
+	for _, conversation := range conversationList {
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(conversation.LastTime), Member: conversation.PeerId}); err != nil {
+			return err
+		}
+		if err := writeConversation(ctx, conversation); err != nil {
+			return err
+		}
+	}
+	return store.Expire(ctx, listKey, config.RedisExp)
+}
+
+// ReadConversationList 读取用户最近的会话及聊天对象的信息，按最后一条私信的时间倒序排列
+func ReadConversationList(ctx context.Context, userId int64) ([]dal.Conversation, error) {
+	listKey := ConversationListKey(userId)
+	n, err := store.Exists(ctx, listKey)
+	if err != nil {
+		return []dal.Conversation{}, err
+	}
+	if n <= 0 { // 未命中，从数据库中读取并写入
+		if err := WriteConversationList(ctx, userId); err != nil {
+			return []dal.Conversation{}, err
+		}
+	}
+	peerIdStrList, err := store.ZRevRange(ctx, listKey, 0, config.MaxConversations-1)
+	if err != nil {
+		return []dal.Conversation{}, err
+	}
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
+		return []dal.Conversation{}, err
+	}
+	conversationList := make([]dal.Conversation, 0, len(peerIdStrList))
+	for _, peerIdStr := range peerIdStrList {
+		peerId, err := strconv.ParseInt(peerIdStr, 10, 64)
+		if err != nil {
+			return []dal.Conversation{}, err
+		}
+		conversation, err := ReadConversation(ctx, userId, peerId)
+		if err != nil {
+			return []dal.Conversation{}, err
+		}
+		conversation.Peer, err = ReadUser(ctx, peerId)
+		if err != nil {
+			return []dal.Conversation{}, err
+		}
+		conversationList = append(conversationList, conversation)
+	}
+	return conversationList, nil
+}
+
+// ClearUnread 将会话标记为已读，没有未读私信时不写入 MySQL，采用延迟双删
+// 返回是否有未读私信被标记为已读
+func ClearUnread(ctx context.Context, userId, peerId int64) (bool, error) {
+	conversation, err := ReadConversation(ctx, userId, peerId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && conversation.UnreadCount == 0) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	key := ConversationKey(userId, peerId)
+	// Redis 第一次删除
+	if err := store.Del(ctx, key); err != nil {
+		return false, err
+	}
+	// 写入 MySQL
+	if err := dal.ClearUnread(ctx, userId, peerId); err != nil {
+		return false, err
+	}
+	// Redis 第二次删除
+	return true, store.Del(ctx, key)
+}
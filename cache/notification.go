@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"strconv"
+)
+
+// ReadNotificationSetting 先在 Redis 中查找用户的通知开关，若无则从 MySQL 中读取
+func ReadNotificationSetting(ctx context.Context, userId int64) (dal.NotificationSetting, error) {
+	key := NotificationSettingKey(userId)
+	n, err := store.Exists(ctx, key)
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	if n <= 0 { // 未命中，读取 MySQL
+		setting, err := dal.GetNotificationSetting(ctx, userId)
+		if err != nil {
+			return dal.NotificationSetting{}, err
+		}
+		if err := RedisStructHash(ctx, setting, key); err != nil {
+			return dal.NotificationSetting{}, err
+		}
+		return setting, store.Expire(ctx, key, config.RedisExp)
+	}
+	setting, err := ReadNotificationSettingFromHash(ctx, key)
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	return setting, nil
+}
+
+// EditNotificationSetting 修改用户的通知开关，采用延迟双删
+func EditNotificationSetting(ctx context.Context, setting dal.NotificationSetting) error {
+	key := NotificationSettingKey(setting.UserId)
+	// Redis 第一次删除
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.EditNotificationSetting(ctx, setting); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return store.Del(ctx, key)
+}
+
+// ReadUnreadNotificationCount 先在 Redis 中查找用户的未读通知数，若无则从 MySQL 中统计
+func ReadUnreadNotificationCount(ctx context.Context, userId int64) (int64, error) {
+	key := NotificationUnreadKey(userId)
+	str, err := store.Get(ctx, key)
+	if err == nil {
+		return strconv.ParseInt(str, 10, 64)
+	} else if err != Nil {
+		return 0, err
+	}
+	// 未命中，读取 MySQL
+	count, err := dal.GetUnreadNotificationCount(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	return count, store.Set(ctx, key, count, config.RedisExp)
+}
+
+// AddNotification 记录通知，未读通知数采用延迟双删
+func AddNotification(ctx context.Context, notification dal.Notification) (dal.Notification, bool, error) {
+	key := NotificationUnreadKey(notification.UserId)
+	// Redis 第一次删除
+	if err := store.Del(ctx, key); err != nil {
+		return dal.Notification{}, false, err
+	}
+	// 写入 MySQL
+	notification, changed, err := dal.AddNotification(ctx, notification)
+	if err != nil {
+		return dal.Notification{}, false, err
+	}
+	// Redis 第二次删除
+	return notification, changed, store.Del(ctx, key)
+}
+
+// ReadNotifications 将通知标记为已读，notificationId 为 0 时标记所有通知，未读通知数采用延迟双删
+func ReadNotifications(ctx context.Context, userId, notificationId int64) error {
+	key := NotificationUnreadKey(userId)
+	// Redis 第一次删除
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.ReadNotifications(ctx, userId, notificationId); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	return store.Del(ctx, key)
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"gorm.io/gorm"
+	"log"
+)
+
+// RelayOutbox 执行到期的发件箱任务，将 MySQL 中已提交的变化同步到 Redis
+// 执行失败的任务按指数退避重试，直到成功为止
+func RelayOutbox(ctx context.Context) error {
+	outboxList, err := dal.GetDueOutbox(ctx, config.OutboxBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, outbox := range outboxList {
+		claimed, err := dal.ClaimOutbox(ctx, &outbox)
+		if err != nil {
+			return err
+		}
+		if !claimed { // 已被其他实例认领
+			continue
+		}
+		if err := applyOutbox(ctx, outbox); err != nil {
+			log.Println(err)
+			if err := dal.RetryOutbox(ctx, outbox, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := dal.DeleteOutbox(ctx, outbox.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOutbox 按任务类型同步缓存
+func applyOutbox(ctx context.Context, outbox dal.Outbox) error {
+	switch outbox.Kind {
+	case dal.OutboxFavorite:
+		var change dal.FavoriteChange
+		if err := json.Unmarshal([]byte(outbox.Payload), &change); err != nil {
+			return err
+		}
+		return syncFavorite(ctx, change)
+	case dal.OutboxFollow:
+		var change dal.FollowChange
+		if err := json.Unmarshal([]byte(outbox.Payload), &change); err != nil {
+			return err
+		}
+		return syncFollow(ctx, change)
+	case dal.OutboxComment:
+		var change dal.CommentChange
+		if err := json.Unmarshal([]byte(outbox.Payload), &change); err != nil {
+			return err
+		}
+		return syncComment(ctx, change)
+	default:
+		return errors.New("未知的发件箱任务类型：" + outbox.Kind)
+	}
+}
+
+// syncFavorite 删除视频 hash（点赞数变化），用户点赞 zset 已缓存时按 MySQL 中的点赞状态加入或移除
+// zset 未缓存时不写入，避免只含部分点赞的 zset 被当作完整数据读取
+func syncFavorite(ctx context.Context, change dal.FavoriteChange) error {
+	if err := DeleteVideo(ctx, change.VideoId); err != nil {
+		return err
+	}
+	key := FavoriteKey(change.UserId)
+	n, err := store.Exists(ctx, key)
+	if err != nil || n <= 0 {
+		return err
+	}
+	favorite, err := dal.Favorites.Get(ctx, change.UserId, change.VideoId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return store.ZRem(ctx, key, change.VideoId)
+	} else if err != nil {
+		return err
+	}
+	return store.ZAdd(ctx, key, Z{Score: float64(favorite.CreatedAt), Member: change.VideoId})
+}
+
+// syncFollow 删除双方的用户 hash（关注数、粉丝数变化）
+// A 的关注集合和 B 的粉丝集合已缓存时，按 MySQL 中的关注状态加入或移除
+func syncFollow(ctx context.Context, change dal.FollowChange) error {
+	if err := DeleteUser(ctx, change.UserAId); err != nil {
+		return err
+	}
+	if err := DeleteUser(ctx, change.UserBId); err != nil {
+		return err
+	}
+	isFollow, err := dal.Relations.IsFollow(ctx, change.UserAId, change.UserBId)
+	if err != nil {
+		return err
+	}
+	for key, member := range map[string]int64{
+		FollowKey(change.UserAId):   change.UserBId,
+		FollowerKey(change.UserBId): change.UserAId,
+	} {
+		n, err := store.Exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			continue
+		}
+		if isFollow {
+			err = store.SAdd(ctx, key, member)
+		} else {
+			err = store.SRem(ctx, key, member)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncComment 删除评论 hash、视频 hash（评论数变化）以及所属一级评论的 hash（回复数变化）
+// 评论仍存在时加入已缓存的列表，已删除时从列表中移除，删除一级评论时同时删除其回复
+func syncComment(ctx context.Context, change dal.CommentChange) error {
+	if err := store.Del(ctx, CommentKey(change.CommentId)); err != nil {
+		return err
+	}
+	if err := DeleteVideo(ctx, change.VideoId); err != nil {
+		return err
+	}
+	if change.RootId != 0 {
+		if err := store.Del(ctx, CommentKey(change.RootId)); err != nil {
+			return err
+		}
+	}
+	comment, err := dal.Comments.GetById(ctx, change.CommentId)
+	if err == nil {
+		if comment.RootId == 0 {
+			return addToCommentList(ctx, comment)
+		}
+		return addToReplyList(ctx, comment)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if change.RootId != 0 {
+		return store.ZRem(ctx, ReplyListKey(change.RootId), change.CommentId)
+	}
+	if err := store.ZRem(ctx, CommentListKey(change.VideoId), change.CommentId); err != nil {
+		return err
+	}
+	if err := store.ZRem(ctx, CommentHotKey(change.VideoId), change.CommentId); err != nil {
+		return err
+	}
+	for _, replyId := range change.ReplyIds {
+		if err := store.Del(ctx, CommentKey(replyId)); err != nil {
+			return err
+		}
+	}
+	return store.Del(ctx, ReplyListKey(change.CommentId))
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"log"
+	"strconv"
+)
+
+// 播放量先累计在 Redis 的 hash 中（视频 id -> 新增播放量），由定时任务批量写入 MySQL
+// 写入时先将 pending 重命名为 flushing，新的播放量继续累计到新的 pending 中，互不影响
+// flushing 中的 PlayBatchField 记录批次 id，MySQL 按批次 id 去重，因此同一批次重复写入不会重复计数
+const (
+	PlayPendingKey  = "play_count_pending"
+	PlayFlushingKey = "play_count_flushing"
+	PlayBatchField  = "batch"
+	PlayFlushLock   = "play_count_flush_lock"
+)
+
+// AddPlay 记录一次播放，viewer 在去重窗口内重复播放不计数
+// 返回本次播放是否被计数
+func AddPlay(ctx context.Context, videoId int64, viewer string) (bool, error) {
+	// SETNX 成功说明窗口内首次播放
+	ok, err := store.SetNX(ctx, PlayDedupKey(videoId, viewer), 1, config.PlayDedupExp)
+	if err != nil || !ok {
+		return false, err
+	}
+	if _, err := store.HIncrBy(ctx, PlayPendingKey, strconv.FormatInt(videoId, 10), 1); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readPendingPlayCount 读取尚未写入 MySQL 的播放量
+func readPendingPlayCount(ctx context.Context, videoId int64) (int64, error) {
+	field := strconv.FormatInt(videoId, 10)
+	var total int64
+	for _, key := range []string{PlayPendingKey, PlayFlushingKey} {
+		str, err := store.HGet(ctx, key, field)
+		if err == Nil {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		count, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// FlushPlayCounts 将累计的播放量写入 MySQL，并删除相关视频的缓存
+// 如果上次写入中途失败，flushing 会保留下来，本次先重新写入这一批，再写入新累计的播放量
+// 多个实例中同一时间只有一个执行，避免重命名时覆盖其他实例正在写入的 flushing
+func FlushPlayCounts(ctx context.Context) error {
+	ok, err := store.SetNX(ctx, PlayFlushLock, 1, config.PlayFlushCycle)
+	if err != nil || !ok {
+		return err
+	}
+	defer func() {
+		if err := store.Del(ctx, PlayFlushLock); err != nil {
+			log.Println(err)
+		}
+	}()
+	n, err := store.Exists(ctx, PlayFlushingKey)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		if err := flushPlayBatch(ctx); err != nil {
+			return err
+		}
+	}
+	n, err = store.Exists(ctx, PlayPendingKey)
+	if err != nil || n <= 0 {
+		return err
+	}
+	if err := store.Rename(ctx, PlayPendingKey, PlayFlushingKey); err != nil {
+		return err
+	}
+	return flushPlayBatch(ctx)
+}
+
+// flushPlayBatch 将 flushing 中的播放量作为一个批次写入 MySQL，写入成功后删除 flushing
+// 批次 id 只在第一次处理时生成，多个实例同时处理同一批次时也只会写入一次
+func flushPlayBatch(ctx context.Context) error {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	if _, err := store.HSetNX(ctx, PlayFlushingKey, PlayBatchField, hex.EncodeToString(buf)); err != nil {
+		return err
+	}
+	countStrMap, err := store.HGetAll(ctx, PlayFlushingKey)
+	if err != nil {
+		return err
+	}
+	batchId := countStrMap[PlayBatchField]
+	delete(countStrMap, PlayBatchField)
+	countMap := make(map[int64]int64, len(countStrMap))
+	for videoIdStr, countStr := range countStrMap {
+		videoId, err := strconv.ParseInt(videoIdStr, 10, 64)
+		if err != nil { // 无法解析的数据跳过，避免这一批永远无法写入
+			log.Println(err)
+			continue
+		}
+		count, err := strconv.ParseInt(countStr, 10, 64)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		countMap[videoId] = count
+	}
+	// 写入 MySQL，同一批次只写入一次
+	if err := dal.Videos.AddPlayCounts(ctx, batchId, countMap); err != nil {
+		return err
+	}
+	if err := store.Del(ctx, PlayFlushingKey); err != nil {
+		return err
+	}
+	// 删除视频缓存，下次读取时从 MySQL 获取最新播放量
+	for videoId := range countMap {
+		if err := DeleteVideo(ctx, videoId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
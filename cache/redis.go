@@ -0,0 +1,407 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnectRDB 连接 Redis，并将其设置为缓存后端
+// 不清空已有数据，事件 stream、推送事件记录、未写入 MySQL 的播放量等在重启后仍然保留
+func ConnectRDB() error {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     config.RedisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	if _, err := rdb.Ping(ctx).Result(); err != nil {
+		return err
+	}
+	if err := migrateLegacyKeys(ctx, rdb); err != nil {
+		return err
+	}
+	Use(NewRedisCache(rdb))
+	return nil
+}
+
+// migrateLegacyKeys 删除旧版本残留的、与当前结构不兼容的缓存，下次读取时从 MySQL 重新写入
+// 用户点赞列表和视频评论列表由 set 改为 zset，读取旧 set 会返回 WRONGTYPE
+// 视频和评论 hash 增加了字段，缺少字段时读取会返回 Nil
+func migrateLegacyKeys(ctx context.Context, rdb *redis.Client) error {
+	isSet := func(key string) (bool, error) {
+		keyType, err := rdb.Type(ctx, key).Result()
+		return keyType == "set", err
+	}
+	if err := deleteKeys(ctx, rdb, "favorite:*", isSet); err != nil {
+		return err
+	}
+	if err := deleteKeys(ctx, rdb, "comment_list:*", isSet); err != nil {
+		return err
+	}
+	if err := deleteKeys(ctx, rdb, "video:*", lacksFields(ctx, rdb, structHashFields(dal.Video{}))); err != nil {
+		return err
+	}
+	return deleteKeys(ctx, rdb, "comment:*", lacksFields(ctx, rdb, structHashFields(dal.Comment{})))
+}
+
+// deleteKeys 遍历匹配 pattern 的 key，删除 stale 判断为过时的 key
+func deleteKeys(ctx context.Context, rdb *redis.Client, pattern string, stale func(key string) (bool, error)) error {
+	iter := rdb.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		ok, err := stale(iter.Val())
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := rdb.Del(ctx, iter.Val()).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Err()
+}
+
+// lacksFields 判断 key 是否不是 hash 或缺少 fields 中的字段
+func lacksFields(ctx context.Context, rdb *redis.Client, fields []string) func(key string) (bool, error) {
+	return func(key string) (bool, error) {
+		keyType, err := rdb.Type(ctx, key).Result()
+		if err != nil {
+			return false, err
+		}
+		if keyType != "hash" {
+			return keyType != "none", nil
+		}
+		values, err := rdb.HMGet(ctx, key, fields...).Result()
+		if err != nil {
+			return false, err
+		}
+		for _, value := range values {
+			if value == nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// redisCache 以 Redis 作为缓存后端
+type redisCache struct {
+	rdb *redis.Client
+}
+
+// NewRedisCache 使用已连接的 Redis 客户端创建缓存后端
+func NewRedisCache(rdb *redis.Client) Cache {
+	return &redisCache{rdb: rdb}
+}
+
+// redisErr 将 redis.Nil 转换为 Nil
+func redisErr(err error) error {
+	if err == redis.Nil {
+		return Nil
+	}
+	return err
+}
+
+func (r *redisCache) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return r.rdb.Exists(ctx, keys...).Result()
+}
+
+func (r *redisCache) Del(ctx context.Context, keys ...string) error {
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
+func (r *redisCache) Expire(ctx context.Context, key string, exp time.Duration) error {
+	return r.rdb.Expire(ctx, key, exp).Err()
+}
+
+func (r *redisCache) Rename(ctx context.Context, key, newKey string) error {
+	return r.rdb.Rename(ctx, key, newKey).Err()
+}
+
+func (r *redisCache) Get(ctx context.Context, key string) (string, error) {
+	str, err := r.rdb.Get(ctx, key).Result()
+	return str, redisErr(err)
+}
+
+func (r *redisCache) Set(ctx context.Context, key string, value interface{}, exp time.Duration) error {
+	return r.rdb.Set(ctx, key, value, exp).Err()
+}
+
+func (r *redisCache) SetNX(ctx context.Context, key string, value interface{}, exp time.Duration) (bool, error) {
+	return r.rdb.SetNX(ctx, key, value, exp).Result()
+}
+
+func (r *redisCache) Incr(ctx context.Context, key string) (int64, error) {
+	return r.rdb.Incr(ctx, key).Result()
+}
+
+func (r *redisCache) HGet(ctx context.Context, key, field string) (string, error) {
+	str, err := r.rdb.HGet(ctx, key, field).Result()
+	return str, redisErr(err)
+}
+
+func (r *redisCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.rdb.HGetAll(ctx, key).Result()
+}
+
+func (r *redisCache) HSet(ctx context.Context, key, field string, value interface{}) error {
+	return r.rdb.HSet(ctx, key, field, value).Err()
+}
+
+func (r *redisCache) HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	return r.rdb.HSetNX(ctx, key, field, value).Result()
+}
+
+func (r *redisCache) HIncrBy(ctx context.Context, key, field string, incr int64) (int64, error) {
+	return r.rdb.HIncrBy(ctx, key, field, incr).Result()
+}
+
+func (r *redisCache) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return r.rdb.SAdd(ctx, key, members...).Err()
+}
+
+func (r *redisCache) SRem(ctx context.Context, key string, members ...interface{}) error {
+	return r.rdb.SRem(ctx, key, members...).Err()
+}
+
+func (r *redisCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	return r.rdb.SMembers(ctx, key).Result()
+}
+
+func (r *redisCache) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return r.rdb.SIsMember(ctx, key, member).Result()
+}
+
+func (r *redisCache) ZAdd(ctx context.Context, key string, members ...Z) error {
+	zList := make([]*redis.Z, 0, len(members))
+	for _, z := range members {
+		zList = append(zList, &redis.Z{Score: z.Score, Member: z.Member})
+	}
+	return r.rdb.ZAdd(ctx, key, zList...).Err()
+}
+
+func (r *redisCache) ZIncrXX(ctx context.Context, key string, member Z) error {
+	err := r.rdb.ZIncrXX(ctx, key, &redis.Z{Score: member.Score, Member: member.Member}).Err()
+	if err == redis.Nil { // 成员不存在
+		return nil
+	}
+	return err
+}
+
+func (r *redisCache) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	return r.rdb.ZRem(ctx, key, members...).Err()
+}
+
+func (r *redisCache) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error {
+	return r.rdb.ZRemRangeByRank(ctx, key, start, stop).Err()
+}
+
+func (r *redisCache) ZScore(ctx context.Context, key string, member interface{}) (float64, error) {
+	score, err := r.rdb.ZScore(ctx, key, toString(member)).Result()
+	return score, redisErr(err)
+}
+
+func (r *redisCache) ZCard(ctx context.Context, key string) (int64, error) {
+	return r.rdb.ZCard(ctx, key).Result()
+}
+
+func (r *redisCache) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.rdb.ZRange(ctx, key, start, stop).Result()
+}
+
+func (r *redisCache) ZRevRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.rdb.ZRevRange(ctx, key, start, stop).Result()
+}
+
+func (r *redisCache) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return toZList(r.rdb.ZRangeWithScores(ctx, key, start, stop).Result())
+}
+
+func (r *redisCache) ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) ([]Z, error) {
+	return toZList(r.rdb.ZRevRangeWithScores(ctx, key, start, stop).Result())
+}
+
+func (r *redisCache) ZRangeByScore(ctx context.Context, key string, by ZRangeBy) ([]string, error) {
+	return r.rdb.ZRangeByScore(ctx, key, toRangeBy(by)).Result()
+}
+
+func (r *redisCache) ZRevRangeByScore(ctx context.Context, key string, by ZRangeBy) ([]string, error) {
+	return r.rdb.ZRevRangeByScore(ctx, key, toRangeBy(by)).Result()
+}
+
+func (r *redisCache) ZRangeByScoreWithScores(ctx context.Context, key string, by ZRangeBy) ([]Z, error) {
+	return toZList(r.rdb.ZRangeByScoreWithScores(ctx, key, toRangeBy(by)).Result())
+}
+
+func (r *redisCache) ZRevRangeByScoreWithScores(ctx context.Context, key string, by ZRangeBy) ([]Z, error) {
+	return toZList(r.rdb.ZRevRangeByScoreWithScores(ctx, key, toRangeBy(by)).Result())
+}
+
+func toRangeBy(by ZRangeBy) *redis.ZRangeBy {
+	return &redis.ZRangeBy{Min: by.Min, Max: by.Max, Offset: by.Offset, Count: by.Count}
+}
+
+func toZList(zList []redis.Z, err error) ([]Z, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Z, 0, len(zList))
+	for _, z := range zList {
+		result = append(result, Z{Score: z.Score, Member: z.Member})
+	}
+	return result, nil
+}
+
+func (r *redisCache) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error) {
+	return r.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: values,
+	}).Result()
+}
+
+func (r *redisCache) XRange(ctx context.Context, stream, start, stop string, count int64) ([]XMessage, error) {
+	return toXMessages(r.rdb.XRangeN(ctx, stream, start, stop, count).Result())
+}
+
+func (r *redisCache) XGroupCreate(ctx context.Context, stream, group string) error {
+	err := r.rdb.XGroupCreateMkStream(ctx, stream, group, "$").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (r *redisCache) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]XMessage, error) {
+	streamList, err := r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var messageList []XMessage
+	for _, s := range streamList {
+		list, _ := toXMessages(s.Messages, nil)
+		messageList = append(messageList, list...)
+	}
+	return messageList, nil
+}
+
+// XAutoClaim 直接发送命令并解析结果
+// Redis 7 的返回值多了已删除消息的 id 列表，go-redis v8 的 XAutoClaim 无法解析
+func (r *redisCache) XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]XMessage, error) {
+	reply, err := r.rdb.Do(ctx, "xautoclaim", stream, group, consumer, minIdle.Milliseconds(), "0-0", "count", count).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 2 {
+		return nil, nil
+	}
+	entryList, _ := reply[1].([]interface{})
+	messageList := make([]XMessage, 0, len(entryList))
+	for _, entry := range entryList {
+		pair, _ := entry.([]interface{})
+		if len(pair) < 2 {
+			continue
+		}
+		id, _ := pair[0].(string)
+		fieldList, _ := pair[1].([]interface{})
+		if fieldList == nil { // 消息已被 MAXLEN 裁剪
+			continue
+		}
+		values := make(map[string]string, len(fieldList)/2)
+		for i := 0; i+1 < len(fieldList); i += 2 {
+			field, _ := fieldList[i].(string)
+			values[field], _ = fieldList[i+1].(string)
+		}
+		messageList = append(messageList, XMessage{Id: id, Values: values})
+	}
+	return messageList, nil
+}
+
+func (r *redisCache) XDeliveries(ctx context.Context, stream, group, id string) (int64, error) {
+	pendingList, err := r.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil || len(pendingList) == 0 {
+		return 0, err
+	}
+	return pendingList[0].RetryCount, nil
+}
+
+func (r *redisCache) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	return r.rdb.XAck(ctx, stream, group, ids...).Err()
+}
+
+func toXMessages(messageList []redis.XMessage, err error) ([]XMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make([]XMessage, 0, len(messageList))
+	for _, message := range messageList {
+		values := make(map[string]string, len(message.Values))
+		for field, value := range message.Values {
+			values[field], _ = value.(string)
+		}
+		result = append(result, XMessage{Id: message.ID, Values: values})
+	}
+	return result, nil
+}
+
+func (r *redisCache) Publish(ctx context.Context, channel string, message interface{}) error {
+	return r.rdb.Publish(ctx, channel, message).Err()
+}
+
+func (r *redisCache) Subscribe(ctx context.Context, channels ...string) PubSub {
+	return &redisPubSub{pubSub: r.rdb.Subscribe(ctx, channels...)}
+}
+
+type redisPubSub struct {
+	pubSub  *redis.PubSub
+	once    sync.Once
+	channel chan Message
+}
+
+func (p *redisPubSub) Subscribe(ctx context.Context, channels ...string) error {
+	return p.pubSub.Subscribe(ctx, channels...)
+}
+
+func (p *redisPubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	return p.pubSub.Unsubscribe(ctx, channels...)
+}
+
+func (p *redisPubSub) Channel() <-chan Message {
+	p.once.Do(func() {
+		p.channel = make(chan Message)
+		go func() {
+			defer close(p.channel)
+			for message := range p.pubSub.Channel() {
+				p.channel <- Message{Channel: message.Channel, Payload: message.Payload}
+			}
+		}()
+	})
+	return p.channel
+}
+
+func (p *redisPubSub) Close() error {
+	return p.pubSub.Close()
+}
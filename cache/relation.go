@@ -1,41 +1,43 @@
 package cache
 
 import (
+	"context"
 	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/dal"
+	"log"
 	"strconv"
 )
 
 // WriteRelation 从数据库中读取关注粉丝列表并写入
 // 由于涉及到关注粉丝两个数组，比较麻烦，因此不返回数组，重新查询缓存即可
-func WriteRelation(userId int64) error {
+func WriteRelation(ctx context.Context, userId int64) error {
 	// 查找关注列表
-	followList, err := dal.GetFollowList(userId)
+	followList, err := dal.Relations.GetFollowList(ctx, userId)
 	if err != nil {
 		return err
 	}
 	// 写入 Redis
 	key := FollowKey(userId)
 	for _, id := range followList {
-		if err := RDB.SAdd(CTX, key, id).Err(); err != nil {
+		if err := store.SAdd(ctx, key, id); err != nil {
 			return err
 		}
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return err
 		}
 	}
 	// 查找粉丝列表
-	followerList, err := dal.GetFollowerList(userId)
+	followerList, err := dal.Relations.GetFollowerList(ctx, userId)
 	if err != nil {
 		return err
 	}
 	// 写入 Redis
 	key = FollowerKey(userId)
 	for _, id := range followerList {
-		if err := RDB.SAdd(CTX, key, id).Err(); err != nil {
+		if err := store.SAdd(ctx, key, id); err != nil {
 			return err
 		}
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return err
 		}
 	}
@@ -44,45 +46,54 @@ func WriteRelation(userId int64) error {
 
 // ReadRelation 查找是否存在某条关注信息，不存在则从数据库写入
 // userA 是当前登录用户，因此优先查询和写入
-func ReadRelation(userAId, userBId int64) (bool, error) {
+func ReadRelation(ctx context.Context, userAId, userBId int64) (bool, error) {
 	key := FollowKey(userAId)
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
 		return false, err
 	}
 	// 未命中，先从数据库中提取 A 的关注粉丝记录
 	if n <= 0 {
-		if err := WriteRelation(userAId); err != nil {
+		if err := WriteRelation(ctx, userAId); err != nil {
 			return false, err
 		}
 	}
 	// 再查询是否存在 A 关注 B 的记录
-	isFollow, err := RDB.SIsMember(CTX, key, userBId).Result()
+	isFollow, err := store.SIsMember(ctx, key, userBId)
 	if err != nil {
 		return false, err
 	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 		return false, err
 	}
 	return isFollow, nil
 }
 
+// ReadFriend 判断两个用户是否互相关注
+func ReadFriend(ctx context.Context, userAId, userBId int64) (bool, error) {
+	isFollow, err := ReadRelation(ctx, userAId, userBId)
+	if err != nil || !isFollow {
+		return false, err
+	}
+	return ReadRelation(ctx, userBId, userAId)
+}
+
 // ReadFollow 读取用户关注列表，并判断列表中用户是否被关注
-func ReadFollow(userAId, userBId int64) ([]dal.User, error) {
+func ReadFollow(ctx context.Context, userAId, userBId int64) ([]dal.User, error) {
 	var followList []dal.User
 	key := FollowKey(userBId) // 查看的是用户 B 的信息
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
 		return []dal.User{}, err
 	}
 	// 未命中，先从数据库中提取 B 的关注粉丝记录
 	if n <= 0 {
-		if err := WriteRelation(userBId); err != nil {
+		if err := WriteRelation(ctx, userBId); err != nil {
 			return []dal.User{}, err
 		}
 	}
 	// 再查询所有关注信息
-	followIdStrList, err := RDB.SMembers(CTX, key).Result()
+	followIdStrList, err := store.SMembers(ctx, key)
 	if err != nil {
 		return []dal.User{}, err
 	}
@@ -91,12 +102,12 @@ func ReadFollow(userAId, userBId int64) ([]dal.User, error) {
 		if err != nil {
 			return []dal.User{}, err
 		}
-		user, err := ReadUser(followId)
+		user, err := ReadUser(ctx, followId)
 		if err != nil {
 			return []dal.User{}, err
 		}
 		// 查找当前登录用户（userA）是否关注了该用户
-		user.IsFollow, err = ReadRelation(userAId, user.Id)
+		user.IsFollow, err = ReadRelation(ctx, userAId, user.Id)
 		if err != nil {
 			return []dal.User{}, err
 		}
@@ -106,21 +117,21 @@ func ReadFollow(userAId, userBId int64) ([]dal.User, error) {
 }
 
 // ReadFollower 读取用户粉丝列表，并判断列表中用户是否被关注
-func ReadFollower(userAId, userBId int64) ([]dal.User, error) {
+func ReadFollower(ctx context.Context, userAId, userBId int64) ([]dal.User, error) {
 	var followList []dal.User
 	key := FollowerKey(userBId) // 查看的是用户 B 的信息
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
 		return []dal.User{}, err
 	}
 	// 未命中，先从数据库中提取 B 的关注粉丝记录
 	if n <= 0 {
-		if err := WriteRelation(userBId); err != nil {
+		if err := WriteRelation(ctx, userBId); err != nil {
 			return []dal.User{}, err
 		}
 	}
 	// 再查询所有粉丝的信息
-	followIdStrList, err := RDB.SMembers(CTX, key).Result()
+	followIdStrList, err := store.SMembers(ctx, key)
 	if err != nil {
 		return []dal.User{}, err
 	}
@@ -129,12 +140,12 @@ func ReadFollower(userAId, userBId int64) ([]dal.User, error) {
 		if err != nil {
 			return []dal.User{}, err
 		}
-		user, err := ReadUser(followId)
+		user, err := ReadUser(ctx, followId)
 		if err != nil {
 			return []dal.User{}, err
 		}
 		// 查找当前登录用户（userA）是否关注了该用户
-		user.IsFollow, err = ReadRelation(userAId, user.Id)
+		user.IsFollow, err = ReadRelation(ctx, userAId, user.Id)
 		if err != nil {
 			return []dal.User{}, err
 		}
@@ -144,73 +155,50 @@ func ReadFollower(userAId, userBId int64) ([]dal.User, error) {
 }
 
 // AddFollow 有新关注时，先写入 MySQL 再写入 Redis
-// 需要删除 Redis 中涉及到的用户，采用延迟双删
+// 需要删除 Redis 中涉及到的用户，采用延迟双删，第二次删除由发件箱 relay 执行
 // 和上面一样，把主体放在 userA 上
-func AddFollow(userAId, userBId int64) error {
+func AddFollow(ctx context.Context, userAId, userBId int64) error {
 	// 第一次删除 Redis 中的用户
-	if err := DeleteUser(userAId); err != nil {
-		return err
-	}
-	if err := DeleteUser(userBId); err != nil {
-		return err
-	}
-	// 写入 MySQL
-	if err := dal.AddFollow(userAId, userBId); err != nil {
-		return err
-	}
-	// 第二次删除 Redis 中的用户
-	if err := DeleteUser(userAId); err != nil {
+	if err := DeleteUser(ctx, userAId); err != nil {
 		return err
 	}
-	if err := DeleteUser(userBId); err != nil {
+	if err := DeleteUser(ctx, userBId); err != nil {
 		return err
 	}
-	// 写入 Redis
-	key := FollowKey(userAId)
-	if err := RDB.SAdd(CTX, key, userBId).Err(); err != nil {
+	// 写入 MySQL，同一事务中记录发件箱任务
+	if err := dal.Relations.AddFollow(ctx, userAId, userBId); err != nil {
 		return err
 	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
-		return err
+	// 立即同步一次，同时更新 A 的关注集合和 B 的粉丝集合，失败时由 relay 补齐
+	if err := syncFollow(ctx, dal.FollowChange{UserAId: userAId, UserBId: userBId}); err != nil {
+		log.Println(err)
 	}
 	return nil
 }
 
-// DeleteFollow 删除关注时，采用延迟双删确保一致性
-func DeleteFollow(userAId, userBId int64) error {
+// DeleteFollow 删除关注时，采用延迟双删确保一致性，第二次删除由发件箱 relay 执行
+func DeleteFollow(ctx context.Context, userAId, userBId int64) error {
 	// Redis 第一次删除关注
-	keyA := FollowKey(userAId)
-	if err := RDB.SRem(CTX, keyA, userBId).Err(); err != nil {
+	if err := store.SRem(ctx, FollowKey(userAId), userBId); err != nil {
 		return err
 	}
-	keyB := FollowerKey(userBId)
-	if err := RDB.SRem(CTX, keyB, userAId).Err(); err != nil {
+	if err := store.SRem(ctx, FollowerKey(userBId), userAId); err != nil {
 		return err
 	}
 	// Redis 第一次删除用户
-	if err := DeleteUser(userAId); err != nil {
-		return err
-	}
-	if err := DeleteUser(userBId); err != nil {
+	if err := DeleteUser(ctx, userAId); err != nil {
 		return err
 	}
-	// MySQL 删除
-	if err := dal.DeleteFollow(userAId, userBId); err != nil {
+	if err := DeleteUser(ctx, userBId); err != nil {
 		return err
 	}
-	// Redis 第二次删除用户
-	if err := DeleteUser(userAId); err != nil {
+	// MySQL 删除，同一事务中记录发件箱任务
+	if err := dal.Relations.DeleteFollow(ctx, userAId, userBId); err != nil {
 		return err
 	}
-	if err := DeleteUser(userBId); err != nil {
-		return err
-	}
-	// Redis 第二次删除关注
-	if err := RDB.SRem(CTX, keyA, userBId).Err(); err != nil {
-		return err
-	}
-	if err := RDB.SRem(CTX, keyB, userAId).Err(); err != nil {
-		return err
+	// 立即同步一次，失败时由 relay 补齐
+	if err := syncFollow(ctx, dal.FollowChange{UserAId: userAId, UserBId: userBId}); err != nil {
+		log.Println(err)
 	}
 	return nil
 }
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"strconv"
+)
+
+// 反垃圾检查结果
+const (
+	SpamNone      = ""
+	SpamRate      = "spam_rate"      // 发布过于频繁
+	SpamDuplicate = "spam_duplicate" // 短时间内重复发布相同内容
+)
+
+// CheckSpam 检查用户在 scene 中发布的内容是否疑似垃圾内容，返回命中的规则，未命中返回 SpamNone
+// 发布频率使用固定窗口计数，重复内容根据内容的哈希值判断，每种内容分别统计
+// 这里只检查不记录，内容发布成功后再调用 RecordSpam，被拒绝或发布失败的内容不计入
+func CheckSpam(ctx context.Context, userId int64, scene, text string) (string, error) {
+	countStr, err := store.Get(ctx, SpamRateKey(userId, scene))
+	if err != nil && err != Nil {
+		return SpamNone, err
+	}
+	if err == nil {
+		count, err := strconv.ParseInt(countStr, 10, 64)
+		if err != nil {
+			return SpamNone, err
+		}
+		if count >= config.SpamRateLimit {
+			return SpamRate, nil
+		}
+	}
+	if text == "" { // 空内容不检查重复
+		return SpamNone, nil
+	}
+	n, err := store.Exists(ctx, SpamTextKey(userId, scene, spamHash(text)))
+	if err != nil {
+		return SpamNone, err
+	}
+	if n > 0 {
+		return SpamDuplicate, nil
+	}
+	return SpamNone, nil
+}
+
+// RecordSpam 内容发布成功后记录发布次数和内容的哈希值，供之后的 CheckSpam 使用
+func RecordSpam(ctx context.Context, userId int64, scene, text string) error {
+	rateKey := SpamRateKey(userId, scene)
+	count, err := store.Incr(ctx, rateKey)
+	if err != nil {
+		return err
+	}
+	if count == 1 { // 窗口内第一次发布，设置窗口过期时间
+		if err := store.Expire(ctx, rateKey, config.SpamRateWindow); err != nil {
+			return err
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	return store.Set(ctx, SpamTextKey(userId, scene, spamHash(text)), 1, config.SpamDuplicateExp)
+}
+
+func spamHash(text string) string {
+	sum := sha1.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func SpamRateKey(userId int64, scene string) string {
+	return "spam_rate:" + scene + ":" + strconv.FormatInt(userId, 10)
+}
+
+func SpamTextKey(userId int64, scene, hash string) string {
+	return "spam_text:" + scene + ":" + strconv.FormatInt(userId, 10) + ":" + hash
+}
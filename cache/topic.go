@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"strconv"
+	"time"
+)
+
+// WriteTopicVideoList 从 MySQL 中读取话题下的视频写入 zset，视频信息写入 hash
+// 按时间排序的列表随发布、删除实时更新；按热度排序的列表只缓存 TopicHotExp，过期后按最新点赞数重新排行
+func WriteTopicVideoList(ctx context.Context, topicId int64, sortType string) error {
+	videoList, err := dal.GetTopicVideos(ctx, topicId, sortType, config.MaxFeedSizeRedis)
+	if err != nil {
+		return err
+	}
+	listKey := TopicVideoKey(topicId)
+	exp := config.RedisExp
+	if sortType == dal.TopicSortHot {
+		listKey = TopicHotKey(topicId)
+		exp = config.TopicHotExp
+	}
+	for _, video := range videoList {
+		score := float64(video.CreateTime)
+		if sortType == dal.TopicSortHot {
+			score = float64(video.FavoriteCount)
+		}
+		if err := store.ZAdd(ctx, listKey, Z{Score: score, Member: video.Id}); err != nil {
+			return err
+		}
+		key := VideoKey(video.Id)
+		if err := RedisStructHash(ctx, video, key); err != nil {
+			return err
+		}
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
+			return err
+		}
+	}
+	// zset 整体设置一次过期时间即可
+	return store.Expire(ctx, listKey, exp)
+}
+
+// ReadTopicVideoList 分页读取话题下的视频，没有则从数据库写入，只返回当前用户有权限查看的视频
+// cursor 为上一页最后一个视频的排序分数（按时间排序时为 create_time，按热度排序时为热度）和视频 id，首页为零值
+// 返回下一页的 cursor 以及是否还有下一页
+func ReadTopicVideoList(ctx context.Context, userId, topicId int64, sortType string, cursor Cursor, count int) ([]dal.Video, Cursor, bool, error) {
+	listKey := TopicVideoKey(topicId)
+	if sortType == dal.TopicSortHot {
+		listKey = TopicHotKey(topicId)
+	}
+	n, err := store.Exists(ctx, listKey)
+	if err != nil {
+		return []dal.Video{}, cursor, false, err
+	}
+	if n <= 0 { // 未命中，从数据库中读取并写入
+		if err := WriteTopicVideoList(ctx, topicId, sortType); err != nil {
+			return []dal.Video{}, cursor, false, err
+		}
+	}
+	// 下一页的 cursor 在过滤无权限的视频之前计算
+	zList, nextCursor, hasMore, err := zPage(ctx, listKey, cursor, count, true)
+	if err == nil && sortType != dal.TopicSortHot { // 热门列表的过期时间不随读取更新
+		err = store.Expire(ctx, listKey, config.RedisExp)
+	}
+	if err != nil {
+		return []dal.Video{}, cursor, false, err
+	}
+	videoList := make([]dal.Video, 0, len(zList))
+	for _, z := range zList {
+		videoId, err := strconv.ParseInt(z.Member.(string), 10, 64)
+		if err != nil {
+			return []dal.Video{}, cursor, false, err
+		}
+		video, err := ReadVideo(ctx, videoId)
+		if err != nil {
+			return []dal.Video{}, cursor, false, err
+		}
+		videoList = append(videoList, video)
+	}
+	videoList, err = filterVisibleVideos(ctx, userId, videoList)
+	if err != nil {
+		return []dal.Video{}, cursor, false, err
+	}
+	return videoList, nextCursor, hasMore, nil
+}
+
+// addToTopicLists 将已发布的视频加入其话题的最新列表，列表未缓存时跳过，下次读取时从数据库写入
+// 热门列表等过期后重新排行
+func addToTopicLists(ctx context.Context, video dal.Video, topicIdList []int64) error {
+	if video.Status != dal.StatusPublished {
+		return nil
+	}
+	for _, topicId := range topicIdList {
+		listKey := TopicVideoKey(topicId)
+		n, err := store.Exists(ctx, listKey)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			continue
+		}
+		if err := store.ZAdd(ctx, listKey, Z{Score: float64(video.CreateTime), Member: video.Id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFromTopicLists 将视频从其话题的最新列表和热门列表中移除
+func removeFromTopicLists(ctx context.Context, videoId int64, topicIdList []int64) error {
+	for _, topicId := range topicIdList {
+		if err := store.ZRem(ctx, TopicVideoKey(topicId), videoId); err != nil {
+			return err
+		}
+		if err := store.ZRem(ctx, TopicHotKey(topicId), videoId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetVideoTopics 设置视频关联的话题，并同步更新话题的视频列表
+func SetVideoTopics(ctx context.Context, video dal.Video, names []string) error {
+	addedIdList, removedIdList, err := dal.SetVideoTopics(ctx, video.Id, names)
+	if err != nil {
+		return err
+	}
+	if err := removeFromTopicLists(ctx, video.Id, removedIdList); err != nil {
+		return err
+	}
+	return addToTopicLists(ctx, video, addedIdList)
+}
+
+// ReadTrendingTopics 读取热门话题，按 TrendingTopicWindow 内新增视频数排行
+// 排行用 zset 缓存 TrendingTopicExp，过期后从 MySQL 重新统计
+func ReadTrendingTopics(ctx context.Context) ([]dal.Topic, error) {
+	n, err := store.Exists(ctx, "topic_trending")
+	if err != nil {
+		return []dal.Topic{}, err
+	}
+	if n <= 0 { // 未命中，从数据库中统计并写入
+		since := time.Now().Add(-config.TrendingTopicWindow).Unix()
+		topicList, err := dal.GetTrendingTopics(ctx, since, config.TrendingTopicSize)
+		if err != nil {
+			return []dal.Topic{}, err
+		}
+		for _, topic := range topicList {
+			if err := store.ZAdd(ctx, "topic_trending", Z{Score: float64(topic.Heat), Member: topic.Id}); err != nil {
+				return []dal.Topic{}, err
+			}
+		}
+		if err := store.Expire(ctx, "topic_trending", config.TrendingTopicExp); err != nil {
+			return []dal.Topic{}, err
+		}
+		return topicList, nil
+	}
+	zList, err := store.ZRevRangeWithScores(ctx, "topic_trending", 0, -1)
+	if err != nil {
+		return []dal.Topic{}, err
+	}
+	topicIdList := make([]int64, len(zList))
+	heatMap := make(map[int64]int64, len(zList))
+	for i, z := range zList {
+		topicId, err := strconv.ParseInt(z.Member.(string), 10, 64)
+		if err != nil {
+			return []dal.Topic{}, err
+		}
+		topicIdList[i] = topicId
+		heatMap[topicId] = int64(z.Score)
+	}
+	// 话题名和视频数从 MySQL 读取，热度以缓存的排行为准
+	topicList, err := dal.GetTopicsByIds(ctx, topicIdList)
+	if err != nil {
+		return []dal.Topic{}, err
+	}
+	for i, topic := range topicList {
+		topicList[i].Heat = heatMap[topic.Id]
+	}
+	return topicList, nil
+}
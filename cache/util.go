@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"github.com/zenpk/mini-douyin-ex/dal"
 	"reflect"
 	"strconv"
@@ -26,8 +27,21 @@ func convertCase(in string) string {
 	return string(out)
 }
 
+// structHashFields 返回 RedisStructHash 写入 hash 的字段名
+func structHashFields(t interface{}) []string {
+	refType := reflect.TypeOf(t)
+	fields := make([]string, 0, refType.NumField())
+	for i := 0; i < refType.NumField(); i++ {
+		if refType.Field(i).Tag.Get(TagName) == NoHash {
+			continue
+		}
+		fields = append(fields, convertCase(refType.Field(i).Name))
+	}
+	return fields
+}
+
 // RedisStructHash - Automatically create hash from struct
-func RedisStructHash(t interface{}, key string) error {
+func RedisStructHash(ctx context.Context, t interface{}, key string) error {
 	ref := reflect.ValueOf(t)
 	for i := 0; i < ref.NumField(); i++ {
 		tag := ref.Type().Field(i).Tag.Get(TagName)
@@ -36,15 +50,15 @@ func RedisStructHash(t interface{}, key string) error {
 		}
 		fieldName := ref.Type().Field(i).Name
 		dbFieldName := convertCase(fieldName)
-		if err := RDB.HSet(CTX, key, dbFieldName, ref.Field(i).Interface()).Err(); err != nil {
+		if err := store.HSet(ctx, key, dbFieldName, ref.Field(i).Interface()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func hGetInt64(key, field string) (int64, error) {
-	str, err := RDB.HGet(CTX, key, field).Result()
+func hGetInt64(ctx context.Context, key, field string) (int64, error) {
+	str, err := store.HGet(ctx, key, field)
 	if err != nil {
 		return 0, err
 	}
@@ -55,40 +69,85 @@ func hGetInt64(key, field string) (int64, error) {
 	return id, nil
 }
 
+// hGetBool bool 写入 Redis 后为 "1" 或 "0"
+func hGetBool(ctx context.Context, key, field string) (bool, error) {
+	str, err := store.HGet(ctx, key, field)
+	if err != nil {
+		return false, err
+	}
+	return str == "1", nil
+}
+
 // ReadVideoFromHash 从 Redis 的 hash 中读取视频信息
-func ReadVideoFromHash(key string) (dal.Video, error) {
+func ReadVideoFromHash(ctx context.Context, key string) (dal.Video, error) {
 	var video dal.Video
 	var err error
 
-	video.Id, err = hGetInt64(key, "id")
+	video.Id, err = hGetInt64(ctx, key, "id")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.UserId, err = hGetInt64(ctx, key, "user_id")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.PlayUrl, err = store.HGet(ctx, key, "play_url")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.CoverUrl, err = store.HGet(ctx, key, "cover_url")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.FavoriteCount, err = hGetInt64(ctx, key, "favorite_count")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.CommentCount, err = hGetInt64(ctx, key, "comment_count")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.PlayCount, err = hGetInt64(ctx, key, "play_count")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.Title, err = store.HGet(ctx, key, "title")
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.Description, err = store.HGet(ctx, key, "description")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.UserId, err = hGetInt64(key, "user_id")
+	visibility, err := hGetInt64(ctx, key, "visibility")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.PlayUrl, err = RDB.HGet(CTX, key, "play_url").Result()
+	video.Visibility = int32(visibility)
+	status, err := hGetInt64(ctx, key, "status")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.CoverUrl, err = RDB.HGet(CTX, key, "cover_url").Result()
+	video.Status = int32(status)
+	video.ReleaseTime, err = hGetInt64(ctx, key, "release_time")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.FavoriteCount, err = hGetInt64(key, "favorite_count")
+	video.PinnedCommentId, err = hGetInt64(ctx, key, "pinned_comment_id")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.CommentCount, err = hGetInt64(key, "comment_count")
+	permission, err := hGetInt64(ctx, key, "comment_permission")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.Title, err = RDB.HGet(CTX, key, "title").Result()
+	video.CommentPermission = int32(permission)
+	video.MentionData, err = store.HGet(ctx, key, "mention_data")
 	if err != nil {
 		return dal.Video{}, err
 	}
-	video.CreateTime, err = hGetInt64(key, "create_time")
+	video.FormatDisplay()
+	video.CreateTime, err = hGetInt64(ctx, key, "create_time")
 	if err != nil {
 		return dal.Video{}, err
 	}
@@ -96,57 +155,291 @@ func ReadVideoFromHash(key string) (dal.Video, error) {
 }
 
 // ReadUserFromHash 从 Redis 的 hash 中读取用户信息
-func ReadUserFromHash(key string) (dal.User, error) {
+func ReadUserFromHash(ctx context.Context, key string) (dal.User, error) {
 	var user dal.User
 	var err error
 
-	user.Id, err = hGetInt64(key, "id")
+	user.Id, err = hGetInt64(ctx, key, "id")
 	if err != nil {
 		return dal.User{}, err
 	}
-	user.Name, err = RDB.HGet(CTX, key, "name").Result()
+	user.Name, err = store.HGet(ctx, key, "name")
 	if err != nil {
 		return dal.User{}, err
 	}
-	user.FollowCount, err = hGetInt64(key, "follow_count")
+	user.FollowCount, err = hGetInt64(ctx, key, "follow_count")
 	if err != nil {
 		return dal.User{}, err
 	}
-	user.FollowerCount, err = hGetInt64(key, "follower_count")
+	user.FollowerCount, err = hGetInt64(ctx, key, "follower_count")
 	if err != nil {
 		return dal.User{}, err
 	}
 	return user, nil
 }
 
+// ReadCollectionFromHash 从 Redis 的 hash 中读取收藏夹信息
+func ReadCollectionFromHash(ctx context.Context, key string) (dal.Collection, error) {
+	var collection dal.Collection
+	var err error
+
+	collection.Id, err = hGetInt64(ctx, key, "id")
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	collection.UserId, err = hGetInt64(ctx, key, "user_id")
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	collection.Name, err = store.HGet(ctx, key, "name")
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	// bool 写入 Redis 后为 "1" 或 "0"
+	isPublic, err := store.HGet(ctx, key, "is_public")
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	collection.IsPublic = isPublic == "1"
+	collection.VideoCount, err = hGetInt64(ctx, key, "video_count")
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	collection.CreatedAt, err = hGetInt64(ctx, key, "created_at")
+	if err != nil {
+		return dal.Collection{}, err
+	}
+	return collection, nil
+}
+
+// ReadMessageFromHash 从 Redis 的 hash 中读取私信
+func ReadMessageFromHash(ctx context.Context, key string) (dal.Message, error) {
+	var message dal.Message
+	var err error
+
+	message.Id, err = hGetInt64(ctx, key, "id")
+	if err != nil {
+		return dal.Message{}, err
+	}
+	message.ChatId, err = store.HGet(ctx, key, "chat_id")
+	if err != nil {
+		return dal.Message{}, err
+	}
+	message.FromUserId, err = hGetInt64(ctx, key, "from_user_id")
+	if err != nil {
+		return dal.Message{}, err
+	}
+	message.ToUserId, err = hGetInt64(ctx, key, "to_user_id")
+	if err != nil {
+		return dal.Message{}, err
+	}
+	message.Content, err = store.HGet(ctx, key, "content")
+	if err != nil {
+		return dal.Message{}, err
+	}
+	message.CreateTime, err = hGetInt64(ctx, key, "create_time")
+	if err != nil {
+		return dal.Message{}, err
+	}
+	return message, nil
+}
+
+// ReadConversationFromHash 从 Redis 的 hash 中读取会话
+func ReadConversationFromHash(ctx context.Context, key string) (dal.Conversation, error) {
+	var conversation dal.Conversation
+	var err error
+
+	conversation.UserId, err = hGetInt64(ctx, key, "user_id")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	conversation.PeerId, err = hGetInt64(ctx, key, "peer_id")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	conversation.LastMessageId, err = hGetInt64(ctx, key, "last_message_id")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	conversation.LastFromUserId, err = hGetInt64(ctx, key, "last_from_user_id")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	conversation.LastContent, err = store.HGet(ctx, key, "last_content")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	conversation.LastTime, err = hGetInt64(ctx, key, "last_time")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	conversation.UnreadCount, err = hGetInt64(ctx, key, "unread_count")
+	if err != nil {
+		return dal.Conversation{}, err
+	}
+	return conversation, nil
+}
+
 // ReadCommentFromHash 从 Redis 的 hash 中读取评论信息
-func ReadCommentFromHash(key string) (dal.Comment, error) {
+func ReadCommentFromHash(ctx context.Context, key string) (dal.Comment, error) {
 	var comment dal.Comment
 	var err error
 
-	comment.Id, err = hGetInt64(key, "id")
+	comment.Id, err = hGetInt64(ctx, key, "id")
 	if err != nil {
 		return dal.Comment{}, err
 	}
-	comment.UserId, err = hGetInt64(key, "user_id")
+	comment.UserId, err = hGetInt64(ctx, key, "user_id")
 	if err != nil {
 		return dal.Comment{}, err
 	}
-	comment.VideoId, err = hGetInt64(key, "video_id")
+	comment.VideoId, err = hGetInt64(ctx, key, "video_id")
 	if err != nil {
 		return dal.Comment{}, err
 	}
-	comment.Content, err = RDB.HGet(CTX, key, "content").Result()
+	comment.RootId, err = hGetInt64(ctx, key, "root_id")
 	if err != nil {
 		return dal.Comment{}, err
 	}
-	comment.CreateDate, err = RDB.HGet(CTX, key, "create_date").Result()
+	comment.ParentId, err = hGetInt64(ctx, key, "parent_id")
 	if err != nil {
 		return dal.Comment{}, err
 	}
+	comment.ReplyToUserId, err = hGetInt64(ctx, key, "reply_to_user_id")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.ReplyCount, err = hGetInt64(ctx, key, "reply_count")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.LikeCount, err = hGetInt64(ctx, key, "like_count")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.Content, err = store.HGet(ctx, key, "content")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.CreatedAt, err = hGetInt64(ctx, key, "created_at")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.EditedAt, err = hGetInt64(ctx, key, "edited_at")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.MentionData, err = store.HGet(ctx, key, "mention_data")
+	if err != nil {
+		return dal.Comment{}, err
+	}
+	comment.FormatDisplay()
 	return comment, nil
 }
 
+// ReadNotificationSettingFromHash 从 Redis 的 hash 中读取通知开关
+func ReadNotificationSettingFromHash(ctx context.Context, key string) (dal.NotificationSetting, error) {
+	var setting dal.NotificationSetting
+	var err error
+
+	setting.UserId, err = hGetInt64(ctx, key, "user_id")
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	setting.Like, err = hGetBool(ctx, key, "like")
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	setting.Comment, err = hGetBool(ctx, key, "comment")
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	setting.Follow, err = hGetBool(ctx, key, "follow")
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	setting.Mention, err = hGetBool(ctx, key, "mention")
+	if err != nil {
+		return dal.NotificationSetting{}, err
+	}
+	return setting, nil
+}
+
+// Cursor 分页游标，Score 为上一页最后一项的分数，Id 为其 id，首页为零值
+// 分数相同的项按 id 区分先后，避免分数相同的项在翻页时被跳过
+type Cursor struct {
+	Score int64
+	Id    int64
+}
+
+// zPage 按分数分页读取 zset，cursor 为上一页最后一项，reverse 为 true 时按分数从大到小
+// 分数相同的成员按 Redis 中的顺序（成员字典序）排列，从 cursor 所在的分数开始读取并跳过已读过的成员
+// 没有 Id 的旧游标跳过所有分数相同的成员，与之前的行为一致
+// 返回本页的成员、下一页的游标以及是否还有下一页
+func zPage(ctx context.Context, key string, cursor Cursor, count int, reverse bool) ([]Z, Cursor, bool, error) {
+	by := ZRangeBy{Min: "-inf", Max: "+inf"}
+	first := cursor == Cursor{}
+	if !first {
+		if reverse {
+			by.Max = strconv.FormatInt(cursor.Score, 10)
+		} else {
+			by.Min = strconv.FormatInt(cursor.Score, 10)
+		}
+	}
+	member := strconv.FormatInt(cursor.Id, 10)
+	// seen 判断分数与 cursor 相同的成员是否已在之前的页中返回
+	seen := func(z Z) bool {
+		if first || int64(z.Score) != cursor.Score {
+			return false
+		}
+		if cursor.Id == 0 {
+			return true
+		}
+		if reverse {
+			return z.Member.(string) >= member
+		}
+		return z.Member.(string) <= member
+	}
+	// 多读一条用于判断是否还有下一页，跳过的成员较多时继续往后读
+	var page []Z
+	for {
+		by.Count = int64(count + 1 - len(page))
+		var zList []Z
+		var err error
+		if reverse {
+			zList, err = store.ZRevRangeByScoreWithScores(ctx, key, by)
+		} else {
+			zList, err = store.ZRangeByScoreWithScores(ctx, key, by)
+		}
+		if err != nil {
+			return nil, cursor, false, err
+		}
+		for _, z := range zList {
+			if !seen(z) {
+				page = append(page, z)
+			}
+		}
+		by.Offset += int64(len(zList))
+		if int64(len(zList)) < by.Count || len(page) > count {
+			break
+		}
+	}
+	hasMore := len(page) > count
+	if hasMore {
+		page = page[:count]
+	}
+	next := cursor
+	if len(page) > 0 {
+		last := page[len(page)-1]
+		id, err := strconv.ParseInt(last.Member.(string), 10, 64)
+		if err != nil {
+			return nil, cursor, false, err
+		}
+		next = Cursor{Score: int64(last.Score), Id: id}
+	}
+	return page, next, hasMore, nil
+}
+
 func UserKey(userId int64) string {
 	return "user:" + strconv.FormatInt(userId, 10)
 }
@@ -167,6 +460,21 @@ func CommentListKey(videoId int64) string {
 	return "comment_list:" + strconv.FormatInt(videoId, 10)
 }
 
+// CommentHotKey 视频按点赞数排序的一级评论列表
+func CommentHotKey(videoId int64) string {
+	return "comment_hot:" + strconv.FormatInt(videoId, 10)
+}
+
+// ReplyListKey 一级评论的回复列表
+func ReplyListKey(rootId int64) string {
+	return "reply_list:" + strconv.FormatInt(rootId, 10)
+}
+
+// CommentLikeKey 用户点赞过的评论
+func CommentLikeKey(userId int64) string {
+	return "comment_like:" + strconv.FormatInt(userId, 10)
+}
+
 func FollowKey(userId int64) string {
 	return "follow:" + strconv.FormatInt(userId, 10)
 }
@@ -178,3 +486,82 @@ func FollowerKey(userId int64) string {
 func FavoriteKey(userId int64) string {
 	return "favorite:" + strconv.FormatInt(userId, 10)
 }
+
+// PlayDedupKey viewer 为 "user:<id>" 或 "device:<id>" 形式，用于播放去重
+func PlayDedupKey(videoId int64, viewer string) string {
+	return "play_dedup:" + strconv.FormatInt(videoId, 10) + ":" + viewer
+}
+
+// TopicVideoKey 话题下按发布时间排序的视频列表
+func TopicVideoKey(topicId int64) string {
+	return "topic_video:" + strconv.FormatInt(topicId, 10)
+}
+
+// TopicHotKey 话题下按点赞数排序的视频列表
+func TopicHotKey(topicId int64) string {
+	return "topic_hot:" + strconv.FormatInt(topicId, 10)
+}
+
+// CollectionKey 收藏夹信息
+func CollectionKey(collectionId int64) string {
+	return "collection:" + strconv.FormatInt(collectionId, 10)
+}
+
+// CollectionListKey 用户创建的收藏夹列表
+func CollectionListKey(userId int64) string {
+	return "collection_list:" + strconv.FormatInt(userId, 10)
+}
+
+// CollectionItemKey 收藏夹中按位置排序的视频
+func CollectionItemKey(collectionId int64) string {
+	return "collection_item:" + strconv.FormatInt(collectionId, 10)
+}
+
+func MessageKey(messageId int64) string {
+	return "message:" + strconv.FormatInt(messageId, 10)
+}
+
+// ChatKey 两人之间最新的私信，chatId 由 dal.ChatId 生成
+func ChatKey(chatId string) string {
+	return "chat:" + chatId
+}
+
+// ConversationKey 用户与某个聊天对象的会话
+func ConversationKey(userId, peerId int64) string {
+	return "conversation:" + strconv.FormatInt(userId, 10) + ":" + strconv.FormatInt(peerId, 10)
+}
+
+// ConversationListKey 用户按最后一条私信时间排序的会话列表
+func ConversationListKey(userId int64) string {
+	return "conversation_list:" + strconv.FormatInt(userId, 10)
+}
+
+// NotificationSettingKey 用户的通知开关
+func NotificationSettingKey(userId int64) string {
+	return "notification_setting:" + strconv.FormatInt(userId, 10)
+}
+
+// NotificationUnreadKey 用户的未读通知数
+func NotificationUnreadKey(userId int64) string {
+	return "notification_unread:" + strconv.FormatInt(userId, 10)
+}
+
+// BusStreamKey 事件总线中某种事件的 stream
+func BusStreamKey(eventName string) string {
+	return "bus:" + eventName
+}
+
+// BusDeadKey 某种事件多次处理失败后的死信 stream
+func BusDeadKey(eventName string) string {
+	return "bus_dead:" + eventName
+}
+
+// EventStreamKey 用户最近的实时事件，用于断线重连后补发
+func EventStreamKey(userId int64) string {
+	return "events:" + strconv.FormatInt(userId, 10)
+}
+
+// PushChannel 用户实时事件的 pub/sub 频道
+func PushChannel(userId int64) string {
+	return "push:" + strconv.FormatInt(userId, 10)
+}
@@ -1,20 +1,22 @@
 package cache
 
 import (
-	"github.com/go-redis/redis/v8"
+	"context"
+	"errors"
 	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/dal"
+	"gorm.io/gorm"
 	"strconv"
 )
 
 // WriteFeed 将视频流 **首次** 写入 Redis
-func WriteFeed(latestTime int64) error {
+func WriteFeed(ctx context.Context, latestTime int64) error {
 	// 检查是否已有数据
-	if n, err := RDB.Exists(CTX, "feed").Result(); err != nil || n > 0 {
+	if n, err := store.Exists(ctx, "feed"); err != nil || n > 0 {
 		return err
 	}
 	// 读取一定数量的视频流
-	videoList, err := dal.GetFeed(latestTime, config.MaxFeedSizeRedis)
+	videoList, err := dal.Videos.GetFeed(ctx, latestTime, config.MaxFeedSizeRedis)
 	if err != nil {
 		return err
 	}
@@ -23,34 +25,34 @@ func WriteFeed(latestTime int64) error {
 	}
 	// 将视频 id 写入 feed，视频信息写入 hash，同时记录作者信息
 	for _, video := range videoList {
-		if err := RDB.ZAdd(CTX, "feed", &redis.Z{Score: float64(video.CreateTime), Member: video.Id}).Err(); err != nil {
+		if err := store.ZAdd(ctx, "feed", Z{Score: float64(video.CreateTime), Member: video.Id}); err != nil {
 			return err
 		}
 		key := VideoKey(video.Id)
-		if err := RedisStructHash(video, key); err != nil {
+		if err := RedisStructHash(ctx, video, key); err != nil {
 			return err
 		}
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return err
 		}
 		// 读取视频对应评论并写入 Redis
-		if _, err := WriteCommentList(video.Id); err != nil {
+		if _, err := WriteCommentList(ctx, video.Id); err != nil {
 			return err
 		}
 		// 读取视频作者信息并写入 Redis
-		if _, err := WriteUser(video.UserId); err != nil {
+		if _, err := WriteUser(ctx, video.UserId); err != nil {
 			return err
 		}
 		// 读取视频作者的关注粉丝信息并写入 Redis
-		if err := WriteRelation(video.UserId); err != nil {
+		if err := WriteRelation(ctx, video.UserId); err != nil {
 			return err
 		}
 		// 读取视频作者的投稿信息并写入 Redis
-		if _, err := WritePublishList(video.UserId); err != nil {
+		if _, err := WritePublishList(ctx, video.UserId); err != nil {
 			return err
 		}
 		// 读取视频作者的点赞信息并写入 Redis
-		if _, err := WriteFavoriteList(video.UserId); err != nil {
+		if _, err := WriteFavoriteList(ctx, video.UserId); err != nil {
 			return err
 		}
 	}
@@ -59,29 +61,29 @@ func WriteFeed(latestTime int64) error {
 
 // WritePublishList 根据用户 id 从 MySQL 中读取投稿信息
 // 根据用户 id 建立 set
-func WritePublishList(userId int64) ([]dal.Video, error) {
+func WritePublishList(ctx context.Context, userId int64) ([]dal.Video, error) {
 	// 数据库读取投稿信息
-	videoList, err := dal.GetPublishList(userId)
+	videoList, err := dal.Videos.GetPublishList(ctx, userId)
 	if err != nil {
 		return []dal.Video{}, err
 	}
 	// listKey 值是 userId 决定的，这样才能方便地查询每个用户的投稿视频
 	listKey := PublishListKey(userId)
 	for _, video := range videoList {
-		if err := RDB.SAdd(CTX, listKey, video.Id).Err(); err != nil {
+		if err := store.SAdd(ctx, listKey, video.Id); err != nil {
 			return []dal.Video{}, err
 		}
 		// 同时还需要将每个 video 单独存储在 hash 中
 		key := VideoKey(video.Id)
-		if err := RedisStructHash(video, key); err != nil {
+		if err := RedisStructHash(ctx, video, key); err != nil {
 			return []dal.Video{}, err
 		}
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return []dal.Video{}, err
 		}
 	}
 	// set 整体设置一次过期时间即可
-	if err := RDB.Expire(CTX, listKey, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
 		return []dal.Video{}, err
 	}
 
@@ -89,47 +91,53 @@ func WritePublishList(userId int64) ([]dal.Video, error) {
 }
 
 // WriteVideo 从 MySQL 中读取视频信息写入 Redis
-func WriteVideo(videoId int64) (dal.Video, error) {
+func WriteVideo(ctx context.Context, videoId int64) (dal.Video, error) {
 	key := VideoKey(videoId)
-	video, err := dal.GetVideoById(videoId)
+	video, err := dal.Videos.GetById(ctx, videoId)
 	if err != nil {
 		return dal.Video{}, err
 	}
 	// 写入 Redis
-	if err := RedisStructHash(video, key); err != nil {
+	if err := RedisStructHash(ctx, video, key); err != nil {
 		return dal.Video{}, err
 	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 		return dal.Video{}, err
 	}
 	return video, nil
 }
 
 // ReadVideo 先在 Redis 中查找视频信息，若无则从 MySQL 中读取
-func ReadVideo(videoId int64) (dal.Video, error) {
+func ReadVideo(ctx context.Context, videoId int64) (dal.Video, error) {
 	var video dal.Video
 	key := VideoKey(videoId)
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
 		return dal.Video{}, err
 	}
 	if n <= 0 { // 没有此视频的缓存，从 MySQL 中读取并写入
-		video, err = WriteVideo(videoId)
+		video, err = WriteVideo(ctx, videoId)
 		if err != nil {
 			return dal.Video{}, err
 		}
 	} else { // 有此缓存
-		video, err = ReadVideoFromHash(key)
+		video, err = ReadVideoFromHash(ctx, key)
 		if err != nil {
 			return dal.Video{}, err
 		}
 		// 更新过期时间
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return dal.Video{}, err
 		}
 	}
+	// 加上尚未写入 MySQL 的播放量
+	pending, err := readPendingPlayCount(ctx, videoId)
+	if err != nil {
+		return dal.Video{}, err
+	}
+	video.PlayCount += pending
 	// 查询该视频对应的用户
-	user, err := ReadUser(video.UserId)
+	user, err := ReadUser(ctx, video.UserId)
 	if err != nil {
 		return dal.Video{}, err
 	}
@@ -137,80 +145,147 @@ func ReadVideo(videoId int64) (dal.Video, error) {
 	return video, nil
 }
 
-// ReadFeed 从 Redis 中读取视频流，包括 id、视频信息、作者信息
-// 没有的数据从 MySQL 中读取并写入 Redis
-func ReadFeed(latestTime int64) ([]dal.Video, error) {
-	// 读取视频流 id
-	opt := redis.ZRangeBy{
-		Min:    "0",
-		Max:    strconv.FormatInt(latestTime, 10),
-		Offset: 0,
-		Count:  config.MaxFeedSize,
-	}
-	videoIdList, err := RDB.ZRevRangeByScore(CTX, "feed", &opt).Result()
+// CanViewVideo 判断用户是否有权限查看视频，作者本人总是可以查看
+// 未登录用户的 userId 为 0，只能查看公开视频；草稿和定时发布的视频仅作者可见
+func CanViewVideo(ctx context.Context, userId int64, video dal.Video) (bool, error) {
+	if userId == video.UserId {
+		return true, nil
+	}
+	if video.Status != dal.StatusPublished {
+		return false, nil
+	}
+	switch video.Visibility {
+	case dal.VisibilityPublic:
+		return true, nil
+	case dal.VisibilityFollower:
+		if userId == 0 {
+			return false, nil
+		}
+		return ReadRelation(ctx, userId, video.UserId)
+	case dal.VisibilityFriend:
+		if userId == 0 {
+			return false, nil
+		}
+		return ReadFriend(ctx, userId, video.UserId)
+	default:
+		return false, nil
+	}
+}
+
+// ReadVisibleVideo 读取用户有权限查看的视频，无权限时与视频不存在返回相同的错误
+func ReadVisibleVideo(ctx context.Context, userId, videoId int64) (dal.Video, error) {
+	video, err := ReadVideo(ctx, videoId)
 	if err != nil {
-		return []dal.Video{}, err
+		return dal.Video{}, err
 	}
-	if err := RDB.Expire(CTX, "feed", config.RedisExp).Err(); err != nil {
-		return []dal.Video{}, err
+	canView, err := CanViewVideo(ctx, userId, video)
+	if err != nil {
+		return dal.Video{}, err
 	}
-	//if len(videoIdList) < config.MaxFeedSize { // 比较少见的场景，即用户请求的视频流 id 超出了缓存中的范围
-	//	if !(len(videoIdList) != 0 && videoIdList[len(videoIdList)-1] == "0") { // 非视频总数不够的情况
-	//	}
-	//}
-	videoList := make([]dal.Video, len(videoIdList))
-	for i, videoIdStr := range videoIdList {
-		videoId, err := strconv.ParseInt(videoIdStr, 10, 64)
+	if !canView {
+		return dal.Video{}, gorm.ErrRecordNotFound
+	}
+	return video, nil
+}
+
+// filterVisibleVideos 过滤掉用户无权限查看的视频
+func filterVisibleVideos(ctx context.Context, userId int64, videoList []dal.Video) ([]dal.Video, error) {
+	visibleList := make([]dal.Video, 0, len(videoList))
+	for _, video := range videoList {
+		canView, err := CanViewVideo(ctx, userId, video)
 		if err != nil {
 			return []dal.Video{}, err
 		}
-		video, err := ReadVideo(videoId)
+		if canView {
+			visibleList = append(visibleList, video)
+		}
+	}
+	return visibleList, nil
+}
+
+// ReadFeed 从 Redis 中读取视频流，包括 id、视频信息、作者信息
+// 没有的数据从 MySQL 中读取并写入 Redis，只返回当前用户有权限查看的视频
+// 无权限查看的视频不计入数量，继续往后读取，直到凑满 MaxFeedSize 个或读完视频流
+func ReadFeed(ctx context.Context, userId, latestTime int64) ([]dal.Video, error) {
+	// 分数为整数秒，以 latestTime+1 作为不含 id 的游标，即读取 create_time <= latestTime 的视频
+	cursor := Cursor{Score: latestTime + 1}
+	videoList := make([]dal.Video, 0, config.MaxFeedSize)
+	for len(videoList) < config.MaxFeedSize {
+		// 读取视频流 id
+		zList, nextCursor, hasMore, err := zPage(ctx, "feed", cursor, config.MaxFeedSize-len(videoList), true)
 		if err != nil {
 			return []dal.Video{}, err
 		}
-		videoList[i] = video
+		for _, z := range zList {
+			videoId, err := strconv.ParseInt(z.Member.(string), 10, 64)
+			if err != nil {
+				return []dal.Video{}, err
+			}
+			video, err := ReadVideo(ctx, videoId)
+			if err != nil {
+				return []dal.Video{}, err
+			}
+			canView, err := CanViewVideo(ctx, userId, video)
+			if err != nil {
+				return []dal.Video{}, err
+			}
+			if canView {
+				videoList = append(videoList, video)
+			}
+		}
+		if !hasMore {
+			break
+		}
+		cursor = nextCursor
 	}
+	if err := store.Expire(ctx, "feed", config.RedisExp); err != nil {
+		return []dal.Video{}, err
+	}
+	//if len(videoIdList) < config.MaxFeedSize { // 比较少见的场景，即用户请求的视频流 id 超出了缓存中的范围
+	//	if !(len(videoIdList) != 0 && videoIdList[len(videoIdList)-1] == "0") { // 非视频总数不够的情况
+	//	}
+	//}
 	return videoList, nil
 }
 
 // ReadPublishList 读取用户投稿视频
 // userA 是当前登录用户，userB 是查看的用户
-func ReadPublishList(userAId, userBId int64) ([]dal.Video, error) {
+func ReadPublishList(ctx context.Context, userAId, userBId int64) ([]dal.Video, error) {
 	key := PublishListKey(userBId)
-	n, err := RDB.Exists(CTX, key).Result()
+	n, err := store.Exists(ctx, key)
 	if err != nil {
 		return []dal.Video{}, err
 	}
 	var videoList []dal.Video
 	if n <= 0 { // 未命中，先从数据库中提取用户的投稿记录并写入
-		videoList, err = WritePublishList(userBId)
+		videoList, err = WritePublishList(ctx, userBId)
 		if err != nil {
 			return []dal.Video{}, err
 		}
 		for i, video := range videoList {
 			// 查找当前登录用户是否点过赞
-			videoList[i].IsFavorite, err = ReadFavorite(userAId, video.Id)
+			videoList[i].IsFavorite, err = ReadFavorite(ctx, userAId, video.Id)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 			// 查找视频对应的用户
-			videoList[i].Author, err = ReadUser(videoList[i].UserId)
+			videoList[i].Author, err = ReadUser(ctx, videoList[i].UserId)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 			// 查找是否关注了这个用户
-			videoList[i].Author.IsFollow, err = ReadRelation(userAId, videoList[i].UserId)
+			videoList[i].Author.IsFollow, err = ReadRelation(ctx, userAId, videoList[i].UserId)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 		}
 	} else { // 命中
-		videoIdStrList, err := RDB.SMembers(CTX, key).Result()
+		videoIdStrList, err := store.SMembers(ctx, key)
 		if err != nil {
 			return []dal.Video{}, err
 		}
 		// 更新过期时间
-		if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+		if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 			return []dal.Video{}, err
 		}
 		for _, videoIdStr := range videoIdStrList {
@@ -219,64 +294,316 @@ func ReadPublishList(userAId, userBId int64) ([]dal.Video, error) {
 				return []dal.Video{}, err
 			}
 			// 根据 id 查找视频，先查 Redis 再查 MySQL
-			video, err := ReadVideo(videoId)
+			video, err := ReadVideo(ctx, videoId)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 			// 查找当前登录用户是否点过赞
-			video.IsFavorite, err = ReadFavorite(userAId, video.Id)
+			video.IsFavorite, err = ReadFavorite(ctx, userAId, video.Id)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 			// 查找视频对应的用户
-			video.Author, err = ReadUser(video.UserId)
+			video.Author, err = ReadUser(ctx, video.UserId)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 			// 查找是否关注了这个用户
-			video.Author.IsFollow, err = ReadRelation(userAId, video.UserId)
+			video.Author.IsFollow, err = ReadRelation(ctx, userAId, video.UserId)
 			if err != nil {
 				return []dal.Video{}, err
 			}
 			videoList = append(videoList, video)
 		}
 	}
-	return videoList, nil
+	return filterVisibleVideos(ctx, userAId, videoList)
 }
 
 // AddVideo 将新发布的视频分别写入 Redis 的 feed 和视频 hash 中
-// 同时还需要写入用户的投稿列表中
+// 同时还需要写入用户的投稿列表和话题视频列表中，草稿和定时发布的视频不写入 feed 和话题视频列表
 // 由于发布视频的用户一定是登录了的用户，因此不用重新向 Redis 中写入作者
-func AddVideo(video dal.Video) error {
+func AddVideo(ctx context.Context, video dal.Video) error {
 	// 写入 feed
-	if err := RDB.ZAdd(CTX, "feed", &redis.Z{Score: float64(video.CreateTime), Member: video.Id}).Err(); err != nil {
-		return err
-	}
-	if err := RDB.Expire(CTX, "feed", config.RedisExp).Err(); err != nil {
-		return err
+	if video.Status == dal.StatusPublished {
+		if err := store.ZAdd(ctx, "feed", Z{Score: float64(video.CreateTime), Member: video.Id}); err != nil {
+			return err
+		}
+		if err := store.Expire(ctx, "feed", config.RedisExp); err != nil {
+			return err
+		}
+		topicIdList, err := dal.GetTopicIdsByVideoId(ctx, video.Id)
+		if err != nil {
+			return err
+		}
+		if err := addToTopicLists(ctx, video, topicIdList); err != nil {
+			return err
+		}
 	}
 	// 写入 hash
 	key := VideoKey(video.Id)
-	if err := RedisStructHash(video, key); err != nil {
+	if err := RedisStructHash(ctx, video, key); err != nil {
 		return err
 	}
-	if err := RDB.Expire(CTX, key, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, key, config.RedisExp); err != nil {
 		return err
 	}
 	// 写入 set
 	listKey := PublishListKey(video.UserId)
-	if err := RDB.SAdd(CTX, listKey, video.Id).Err(); err != nil {
+	if err := store.SAdd(ctx, listKey, video.Id); err != nil {
 		return err
 	}
-	if err := RDB.Expire(CTX, listKey, config.RedisExp).Err(); err != nil {
+	if err := store.Expire(ctx, listKey, config.RedisExp); err != nil {
 		return err
 	}
 	return nil
 }
 
 // DeleteVideo 涉及到 FavoriteCount 和 CommentCount 变化时要删除视频
-func DeleteVideo(videoId int64) error {
+func DeleteVideo(ctx context.Context, videoId int64) error {
 	key := VideoKey(videoId)
-	err := RDB.Del(CTX, key).Err()
+	err := store.Del(ctx, key)
 	return err
 }
+
+// EditVideo 修改视频标题、简介和可见范围，采用延迟双删
+func EditVideo(ctx context.Context, userId, videoId int64, title, mentionData, description string, visibility int32) error {
+	// Redis 第一次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.Videos.Edit(ctx, userId, videoId, title, mentionData, description, visibility); err != nil {
+		return err
+	}
+	// Redis 第二次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PinComment 作者置顶评论，commentId 为 0 时取消置顶，采用延迟双删
+func PinComment(ctx context.Context, userId, videoId, commentId int64) error {
+	// Redis 第一次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.Videos.PinComment(ctx, userId, videoId, commentId); err != nil {
+		return err
+	}
+	// Redis 第二次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetCommentPermission 作者设置评论权限，采用延迟双删
+func SetCommentPermission(ctx context.Context, userId, videoId int64, permission int32) error {
+	// Redis 第一次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return err
+	}
+	// 写入 MySQL
+	if err := dal.Videos.SetCommentPermission(ctx, userId, videoId, permission); err != nil {
+		return err
+	}
+	// Redis 第二次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CanComment 判断用户是否可以在视频下发表评论，视频作者总是可以评论
+func CanComment(ctx context.Context, userId int64, video dal.Video) (bool, error) {
+	if userId == video.UserId {
+		return true, nil
+	}
+	switch video.CommentPermission {
+	case dal.CommentEveryone:
+		return true, nil
+	case dal.CommentFollower:
+		return ReadRelation(ctx, userId, video.UserId)
+	default:
+		return false, nil
+	}
+}
+
+// removeVideoRefs 从 Redis 中移除视频的所有引用：feed、话题视频列表、投稿列表、所有用户的点赞列表、收藏夹、评论及回复列表和视频本身
+func removeVideoRefs(ctx context.Context, userId, videoId int64, favoriteList []dal.Favorite) error {
+	if err := store.ZRem(ctx, "feed", videoId); err != nil {
+		return err
+	}
+	topicIdList, err := dal.GetTopicIdsByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	if err := removeFromTopicLists(ctx, videoId, topicIdList); err != nil {
+		return err
+	}
+	if err := store.SRem(ctx, PublishListKey(userId), videoId); err != nil {
+		return err
+	}
+	for _, favorite := range favoriteList {
+		if err := store.ZRem(ctx, FavoriteKey(favorite.UserId), videoId); err != nil {
+			return err
+		}
+	}
+	collectionIdList, err := dal.GetCollectionIdsByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	for _, collectionId := range collectionIdList {
+		if err := store.ZRem(ctx, CollectionItemKey(collectionId), videoId); err != nil {
+			return err
+		}
+	}
+	// 评论 hash 需要根据评论列表逐个删除，评论列表可能已过期，一级评论从 MySQL 读取
+	listKey := CommentListKey(videoId)
+	commentIdStrList, err := store.ZRange(ctx, listKey, 0, -1)
+	if err != nil {
+		return err
+	}
+	commentList, err := dal.Comments.GetByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	rootIdList := make([]int64, 0, len(commentIdStrList)+len(commentList))
+	for _, commentIdStr := range commentIdStrList {
+		commentId, err := strconv.ParseInt(commentIdStr, 10, 64)
+		if err != nil {
+			return err
+		}
+		rootIdList = append(rootIdList, commentId)
+	}
+	for _, comment := range commentList {
+		rootIdList = append(rootIdList, comment.Id)
+	}
+	// 回复 hash 根据每条一级评论的回复列表逐个删除，回复列表已过期的回复 hash 等待自然过期
+	for _, rootId := range rootIdList {
+		replyKey := ReplyListKey(rootId)
+		replyIdStrList, err := store.ZRange(ctx, replyKey, 0, -1)
+		if err != nil {
+			return err
+		}
+		for _, replyIdStr := range replyIdStrList {
+			replyId, err := strconv.ParseInt(replyIdStr, 10, 64)
+			if err != nil {
+				return err
+			}
+			if err := store.Del(ctx, CommentKey(replyId)); err != nil {
+				return err
+			}
+		}
+		if err := store.Del(ctx, CommentKey(rootId), replyKey); err != nil {
+			return err
+		}
+	}
+	if err := store.Del(ctx, listKey, CommentHotKey(videoId)); err != nil {
+		return err
+	}
+	return DeleteVideo(ctx, videoId)
+}
+
+// RemoveVideo 作者删除视频，采用延迟双删
+// 需要从 feed、投稿列表、点赞了该视频的用户的点赞列表、评论列表中删除
+// 先检查是否是作者本人，避免在 MySQL 拒绝删除之前就修改了 Redis
+func RemoveVideo(ctx context.Context, userId, videoId int64) error {
+	video, err := dal.Videos.GetById(ctx, videoId)
+	if err != nil || video.UserId != userId {
+		return errors.New("无法删除视频")
+	}
+	favoriteList, err := dal.Favorites.GetByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	// Redis 第一次删除
+	if err := removeVideoRefs(ctx, userId, videoId, favoriteList); err != nil {
+		return err
+	}
+	// MySQL 软删除
+	if err := dal.Videos.Delete(ctx, userId, videoId); err != nil {
+		return err
+	}
+	// Redis 第二次删除
+	if err := removeVideoRefs(ctx, userId, videoId, favoriteList); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RestoreVideo 恢复被删除的视频，重新写入 feed 和投稿列表
+// 点赞了该视频的用户的点赞列表和收藏了该视频的收藏夹直接删除，下次读取时从 MySQL 重新写入
+// 只有作者本人可以恢复，检查通过后才修改 Redis
+func RestoreVideo(ctx context.Context, userId, videoId int64) error {
+	video, err := dal.Videos.Restore(ctx, userId, videoId)
+	if err != nil {
+		return err
+	}
+	if video.UserId != userId {
+		return errors.New("不存在已删除的视频")
+	}
+	if err := AddVideo(ctx, video); err != nil {
+		return err
+	}
+	favoriteList, err := dal.Favorites.GetByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	for _, favorite := range favoriteList {
+		if err := store.Del(ctx, FavoriteKey(favorite.UserId)); err != nil {
+			return err
+		}
+	}
+	collectionIdList, err := dal.GetCollectionIdsByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	for _, collectionId := range collectionIdList {
+		if err := store.Del(ctx, CollectionItemKey(collectionId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseVideo 发布草稿或修改定时发布时间，采用延迟双删后重新写入，并返回更新后的视频
+// 先检查是否是作者本人，避免在 MySQL 拒绝发布之前就修改了 Redis
+func ReleaseVideo(ctx context.Context, userId, videoId, releaseTime int64) (dal.Video, error) {
+	if video, err := dal.Videos.GetById(ctx, videoId); err != nil || video.UserId != userId {
+		return dal.Video{}, errors.New("不存在未发布的视频")
+	}
+	// Redis 第一次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return dal.Video{}, err
+	}
+	// 写入 MySQL
+	video, err := dal.Videos.Release(ctx, userId, videoId, releaseTime)
+	if err != nil {
+		return dal.Video{}, err
+	}
+	// Redis 第二次删除视频
+	if err := DeleteVideo(ctx, videoId); err != nil {
+		return dal.Video{}, err
+	}
+	return video, AddVideo(ctx, video)
+}
+
+// ReleaseScheduledVideos 发布所有到期的定时视频，并写入 feed 和投稿列表，返回本次发布的视频
+func ReleaseScheduledVideos(ctx context.Context) ([]dal.Video, error) {
+	videoList, err := dal.Videos.ReleaseScheduled(ctx)
+	if err != nil {
+		return []dal.Video{}, err
+	}
+	for _, video := range videoList {
+		if err := DeleteVideo(ctx, video.Id); err != nil {
+			return []dal.Video{}, err
+		}
+		if err := AddVideo(ctx, video); err != nil {
+			return []dal.Video{}, err
+		}
+	}
+	return videoList, nil
+}
@@ -1,28 +1,60 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
 	"github.com/zenpk/mini-douyin-ex/cache"
 	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/controller"
 	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/filter"
+	"github.com/zenpk/mini-douyin-ex/search"
+	"github.com/zenpk/mini-douyin-ex/service"
 	"log"
 	"time"
 )
 
 func main() {
-	// 连接 MySQL 数据库并创建表格
+	// 搜索索引文件和事件总线的消费者组以实例 id 区分，需要固定的实例 id
+	if config.InstanceId == "" {
+		log.Fatalln("未设置实例 id，需要通过 DOUYIN_INSTANCE 设置固定的实例 id")
+	}
+	// 连接数据库并创建表格，开发模式下使用 SQLite
 	if err := dal.ConnectDB(); err != nil {
 		log.Fatalln(err)
 	}
-	// 连接 Redis
-	if err := cache.ConnectRDB(); err != nil {
+	// 连接 Redis，开发模式下使用进程内缓存
+	if err := cache.Connect(); err != nil {
 		log.Fatalln(err)
 	}
 	// 将视频流预缓存至 Redis
-	if err := cache.WriteFeed(time.Now().Unix()); err != nil {
+	if err := cache.WriteFeed(context.Background(), time.Now().Unix()); err != nil {
+		log.Fatalln(err)
+	}
+	// 加载敏感词表，并在文件修改后自动重新加载
+	wordAction, ok := filter.ParseAction(config.WordAction)
+	if !ok {
+		log.Fatalln("无效的敏感词默认处理方式：" + config.WordAction)
+	}
+	if err := filter.Load(config.WordFile, wordAction); err != nil {
+		log.Fatalln(err)
+	}
+	go filter.Watch(config.WordFile, wordAction, config.WordReloadCycle)
+	// 加载搜索索引，并定时将增量修改保存到当前实例的索引文件
+	if err := search.Load(config.SearchInstanceFile, config.SearchIndexFile); err != nil {
+		log.Fatalln(err)
+	}
+	go search.Watch(config.SearchInstanceFile, config.SearchIndexFile, config.SearchSaveCycle)
+	// 注册事件订阅者并开始处理事件
+	service.RegisterSubscribers()
+	if err := bus.Run(); err != nil {
 		log.Fatalln(err)
 	}
+	// 启动定时任务
+	service.RunScheduler()
+	// 订阅实时推送事件
+	service.RunPushHub()
 	// 初始化 Gin
 	r := gin.Default()
 	controller.InitRouter(r)
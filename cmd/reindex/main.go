@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/search"
+	"log"
+)
+
+// 从 MySQL 重建搜索索引，运行中的服务会在下一个 SearchSaveCycle 自动重新加载
+// 并重放重建开始之后的增量修改，重建需要在 SearchJournalKeep 内完成
+func main() {
+	ctx := context.Background()
+	if err := dal.ConnectDB(); err != nil {
+		log.Fatalln(err)
+	}
+	search.Reset()
+	videoList, err := dal.Videos.GetPublished(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, video := range videoList {
+		search.Index(search.TypeVideo, video.Id, video.Title)
+	}
+	userList, err := dal.Users.GetAll(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, user := range userList {
+		search.Index(search.TypeUser, user.Id, user.Name)
+	}
+	topicList, err := dal.GetAllTopics(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, topic := range topicList {
+		search.Index(search.TypeTopic, topic.Id, topic.Name)
+	}
+	if err := search.Save(config.SearchIndexFile); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("搜索索引已重建：%d 个视频，%d 个用户，%d 个话题", len(videoList), len(userList), len(topicList))
+}
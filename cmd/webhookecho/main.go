@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"github.com/zenpk/mini-douyin-ex/webhook"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 本地的回调接收方，用于联调第三方回调：校验签名并打印收到的事件
+// -fail 指定前几次请求返回 500，用于观察重试、投递日志和自动停用
+func main() {
+	addr := flag.String("addr", ":8081", "监听地址")
+	secret := flag.String("secret", "", "创建回调时返回的 secret")
+	fail := flag.Int64("fail", 0, "前 fail 次请求返回 500")
+	maxAge := flag.Duration("max-age", 5*time.Minute, "拒绝时间戳早于该时长的请求")
+	flag.Parse()
+
+	var count int64
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&count, 1)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		event := r.Header.Get(webhook.HeaderEvent)
+		delivery := r.Header.Get(webhook.HeaderDelivery)
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+		if err != nil || time.Since(time.Unix(timestamp, 0)) > *maxAge {
+			log.Printf("#%d 投递 %s 时间戳无效", n, delivery)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !webhook.Verify(*secret, timestamp, body, r.Header.Get(webhook.HeaderSignature)) {
+			log.Printf("#%d 投递 %s 签名校验失败", n, delivery)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if n <= *fail {
+			log.Printf("#%d 投递 %s（%s）模拟失败", n, delivery, event)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Printf("#%d 投递 %s（%s）：%s", n, delivery, event, body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	log.Println("webhookecho 监听 " + *addr)
+	log.Fatalln(http.ListenAndServe(*addr, nil))
+}
@@ -1,6 +1,12 @@
 package config
 
-import "time"
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // 保存一些常量
 
@@ -16,9 +22,166 @@ const (
 	RedisAddr        = "localhost:6379"            // Redis 地址
 	MaxFeedSize      = 30                          // 单次视频流请求最多推送个数
 	MaxFeedSizeRedis = 10000                       // 从 MySQL 将视频流读入 Redis 时的最多推送个数
+	CommentPageSize  = 20                          // 单页评论个数
+	FavoritePageSize = 20                          // 点赞列表单页视频个数
+	CommentEditExp   = 10 * time.Minute            // 评论发表后可编辑的期限
 	RedisExp         = 24 * time.Hour              // Redis 数据过期时间
+	VideoRestoreExp  = 7 * 24 * time.Hour          // 视频删除后可恢复的期限
+	VideoPurgeCycle  = time.Hour                   // 清理超期已删除视频的周期
+	ReleaseCycle     = 10 * time.Second            // 检查定时发布视频的周期
+	PlayDedupExp     = 30 * time.Minute            // 同一用户或 IP 重复播放不计数的时间窗口
+	PlayFlushCycle   = time.Minute                 // 将 Redis 中的播放量写入 MySQL 的周期
+	PlayBatchKeep    = 7 * 24 * time.Hour          // 已写入的播放量批次记录的保留时间，用于识别重复写入
+)
+
+// 内容过滤和反垃圾
+const (
+	WordFile         = "./config/sensitive_words.txt" // 敏感词表文件
+	WordAction       = "mask"                         // 敏感词表中未指定处理方式时的默认处理方式：reject、mask 或 review
+	WordReloadCycle  = 30 * time.Second               // 检查敏感词表是否修改的周期
+	SpamRateWindow   = time.Minute                    // 发布频率的统计窗口
+	SpamRateLimit    = 10                             // 统计窗口内每种内容（评论、视频标题）最多发布的条数
+	SpamDuplicateExp = 10 * time.Minute               // 重复内容检测的时间窗口
+)
+
+// 话题
+const (
+	MaxVideoTopics      = 10               // 单个视频最多关联的话题数
+	MaxTopicNameLen     = 32               // 话题名最大字符数
+	TopicPageSize       = 20               // 话题页单页视频个数
+	TopicHotExp         = 10 * time.Minute // 话题热门视频排行的缓存时间，过期后按最新点赞数重新排行
+	TrendingTopicSize   = 20               // 热门话题个数
+	TrendingTopicWindow = 24 * time.Hour   // 热门话题按此时间窗口内的新增视频数排行
+	TrendingTopicExp    = 5 * time.Minute  // 热门话题排行的缓存时间
+)
+
+// 收藏夹
+const (
+	MaxCollections       = 100  // 每个用户最多创建的收藏夹数
+	MaxCollectionSize    = 1000 // 每个收藏夹最多收藏的视频数
+	MaxCollectionNameLen = 32   // 收藏夹名称最大字符数
+	CollectionPageSize   = 20   // 收藏夹单页视频个数
+)
+
+// 私信
+const (
+	MessageFriendOnly = true // 是否只允许互相关注的好友之间发送私信
+	MaxMessageLen     = 1000 // 单条私信最大字符数
+	MessagePreviewLen = 30   // 会话列表中最后一条私信的预览字符数
+	MessagePageSize   = 50   // 单次获取私信的最多条数
+	MessageCacheSize  = 200  // 每个会话在 Redis 中缓存的最新私信条数
+	MaxConversations  = 100  // 会话列表最多返回的会话数
+)
+
+// 实时推送
+const (
+	PushPingCycle   = 30 * time.Second // 向客户端发送心跳的周期
+	PushPongWait    = 60 * time.Second // 超过该时间未收到客户端的心跳回复则断开连接
+	PushWriteWait   = 10 * time.Second // 单次写入的超时时间
+	PushSendBuffer  = 64               // 每个连接待发送事件的缓冲区大小，写满说明客户端过慢，断开连接由客户端重连补发
+	EventLogSize    = 1000             // 每个用户保留的最近事件数
+	EventLogExp     = 24 * time.Hour   // 用户事件流的过期时间
+	EventReplaySize = 500              // 重连时最多补发的事件数
+)
+
+// 通知
+const (
+	NotificationPageSize     = 20 // 通知列表单页个数
+	NotificationActorPreview = 3  // 每条通知展示的最近操作者个数
+)
+
+// 事件总线
+const (
+	EventBusQueueSize  = 1024             // 每个进程内订阅者的事件队列长度，写满时丢弃新事件，避免拖慢原请求
+	EventBusStreamSize = 10000            // 每种事件的 stream 保留的最近事件数
+	EventBusBatchSize  = 10               // 每次从 stream 中读取的事件数
+	EventBusBlock      = 5 * time.Second  // 没有新事件时阻塞等待的时间
+	EventBusRetryIdle  = 30 * time.Second // 事件处理失败或未确认超过该时间后重新投递
+	EventBusMaxRetries = 5                // 超过该投递次数仍失败的事件移入死信 stream
+)
+
+// 发件箱
+const (
+	OutboxDelay      = time.Second            // 事务提交后延迟同步 Redis 的时间，即延迟双删中第二次删除的延迟
+	OutboxRelayCycle = 500 * time.Millisecond // relay 检查到期任务的周期
+	OutboxBatchSize  = 100                    // relay 每次最多执行的任务数
+	OutboxLease      = 30 * time.Second       // relay 认领任务后的租约，超时未完成时可被重新认领
+	OutboxRetryBase  = time.Second            // 执行失败后第一次重试的间隔，之后每次翻倍
+	OutboxRetryMax   = 5 * time.Minute        // 重试的最长间隔
+)
+
+// Webhook
+const (
+	WebhookCycle         = time.Second      // 检查到期回调的周期
+	WebhookBatchSize     = 20               // 每次最多发送的回调数，同一批并发发送
+	WebhookTimeout       = 5 * time.Second  // 单次回调的超时时间
+	WebhookLease         = time.Minute      // 认领回调后的租约，超时未完成时可被重新认领
+	WebhookRetryBase     = 10 * time.Second // 回调失败后第一次重试的间隔，之后每次翻倍
+	WebhookRetryMax      = time.Hour        // 重试的最长间隔
+	WebhookMaxAttempts   = 8                // 单个回调的最多尝试次数，之后标记为失败
+	WebhookMaxFailures   = 20               // 连续失败的尝试次数达到该值时自动停用订阅
+	WebhookResponseLimit = 1024             // 投递日志中保存的响应内容长度
+	WebhookPageSize      = 20               // 投递日志单页个数
+)
+
+// 搜索
+const (
+	SearchIndexFile        = "./search.index" // reindex 命令从 MySQL 重建的搜索索引文件，所有实例共用，各实例检测到重建后重新加载
+	SearchSaveCycle        = time.Minute      // 将修改过的搜索索引保存到文件的周期
+	SearchJournalKeep      = time.Hour        // 保留搜索索引增量修改的时长，需要长于 reindex 重建所需的时间
+	SearchCandidateSize    = 100              // 按文本相关度取出的候选结果数，再结合热度重新排序
+	SearchPageSize         = 20               // 搜索结果单页个数
+	SearchPopularityWeight = 0.2              // 热度在排序中的权重，得分为相关度 * (1 + 权重 * ln(1 + 热度))
+	SuggestSize            = 10               // 搜索补全的最多个数
+)
+
+// 运行模式
+const (
+	ModeProd = "prod" // 使用 MySQL 和 Redis
+	ModeDev  = "dev"  // 使用 SQLite 和进程内缓存，不依赖任何外部服务，方便本地开发和 CI
 )
 
 var (
-	Secret = []byte("mini-douyin") // JWT token 加密
+	Mode         = getEnv("DOUYIN_MODE", ModeProd)                // 运行模式，取值见 ModeProd 等常量
+	SQLiteFile   = getEnv("DOUYIN_SQLITE_FILE", "./douyin.db")    // 开发模式下的 SQLite 数据库文件，删除即可清空数据
+	Secret       = []byte("mini-douyin")                          // JWT token 加密
+	AdminUserIds = getEnvIds("DOUYIN_ADMIN_USER_IDS")             // 管理员用户 id，以逗号分隔，可以调用 /douyin/admin/ 下的接口
+	InstanceId   = getEnv("DOUYIN_INSTANCE", defaultInstanceId()) // 实例 id，多实例部署时各不相同，重启后保持不变，生产模式下必须设置
+	// 当前实例的搜索索引文件，保存本实例增量修改后的索引，不与其他实例共用
+	SearchInstanceFile = "./search." + InstanceId + ".index"
 )
+
+// defaultInstanceId 开发模式只有一个实例，默认为 dev；生产模式没有默认值
+// 不使用主机名，因为容器每次重启主机名都会变化，会在 stream 上留下无人消费的消费者组
+func defaultInstanceId() string {
+	if Mode == ModeDev {
+		return ModeDev
+	}
+	return ""
+}
+
+// getEnvIds 读取以逗号分隔的 id 列表，无法解析的 id 记录日志后跳过
+func getEnvIds(key string) []int64 {
+	idList := []int64{}
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Println("无效的用户 id：" + key + "=" + field)
+			continue
+		}
+		idList = append(idList, id)
+	}
+	return idList
+}
+
+// getEnv 读取环境变量，未设置时返回默认值
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
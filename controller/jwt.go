@@ -61,3 +61,18 @@ func AuthMiddlewareAlt() gin.HandlerFunc {
 		}
 	}
 }
+
+// AdminMiddleware 只允许 config.AdminUserIds 中的用户通过，需要在 AuthMiddleware 之后使用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := util.GetTokenUserId(c)
+		for _, adminId := range config.AdminUserIds {
+			if userId != 0 && userId == adminId {
+				c.Next()
+				return
+			}
+		}
+		service.ResponseFailed(c, "没有权限")
+		c.Abort()
+	}
+}
@@ -23,6 +23,24 @@ func InitRouter(r *gin.Engine) {
 	apiRouter.GET("/feed/", AuthMiddlewareAlt(), service.Feed) // 视频流比较特殊，是否登录需要做不同处理
 	apiRouter.POST("/publish/action/", AuthMiddleware(), service.Publish)
 	apiRouter.GET("/publish/list/", AuthMiddleware(), service.PublishList) // ?
+	apiRouter.POST("/publish/edit/", AuthMiddleware(), service.EditVideo)
+	apiRouter.POST("/publish/delete/", AuthMiddleware(), service.DeleteVideo)
+	apiRouter.POST("/publish/release/", AuthMiddleware(), service.ReleaseVideo)
+	apiRouter.GET("/video/", AuthMiddlewareAlt(), service.VideoDetail) // 分享链接可能未登录
+	apiRouter.POST("/video/play/", AuthMiddlewareAlt(), service.PlayVideo)
+
+	// search
+	apiRouter.GET("/search/", AuthMiddlewareAlt(), service.Search)
+	apiRouter.GET("/search/suggest/", AuthMiddlewareAlt(), service.Suggest)
+
+	// topic
+	apiRouter.GET("/topic/video/list/", AuthMiddlewareAlt(), service.TopicVideoList)
+	apiRouter.GET("/topic/trending/", AuthMiddlewareAlt(), service.TrendingTopic)
+
+	// collection
+	// 公开的收藏夹可以分享给未登录的用户
+	apiRouter.GET("/collection/list/", AuthMiddlewareAlt(), service.CollectionList)
+	apiRouter.GET("/collection/item/list/", AuthMiddlewareAlt(), service.CollectionItemList)
 
 	// 以下功能均需使用 JWT 中间件
 	authRouter := apiRouter.Group("/")
@@ -32,14 +50,52 @@ func InitRouter(r *gin.Engine) {
 		authRouter.POST("/favorite/action/", service.FavoriteAction)
 		authRouter.GET("/favorite/list/", service.FavoriteList)
 
+		// collection
+		authRouter.POST("/collection/action/", service.CollectionAction)
+		authRouter.POST("/collection/item/action/", service.CollectionItemAction)
+		authRouter.POST("/collection/item/move/", service.MoveCollectionItem)
+
 		// comment
 		authRouter.POST("/comment/action/", service.CommentAction)
 		authRouter.GET("/comment/list/", service.CommentList)
+		authRouter.GET("/comment/reply/list/", service.ReplyList)
+		authRouter.POST("/comment/like/action/", service.CommentLikeAction)
+		authRouter.POST("/comment/pin/", service.PinComment)
+		authRouter.POST("/comment/permission/", service.CommentPermission)
 
 		// relation
 		authRouter.POST("/relation/action/", service.RelationAction)
 		authRouter.GET("/relation/follow/list/", service.FollowList)
 		authRouter.GET("/relation/follower/list/", service.FollowerList)
+		authRouter.POST("/relation/block/action/", service.BlockAction)
+
+		// message
+		authRouter.POST("/message/action/", service.MessageAction)
+		authRouter.GET("/message/chat/", service.MessageChat)
+		authRouter.GET("/message/conversation/list/", service.ConversationList)
+
+		// mention
+		authRouter.GET("/mention/list/", service.MentionList)
+
+		// notification
+		authRouter.GET("/notification/list/", service.NotificationList)
+		authRouter.POST("/notification/read/", service.NotificationRead)
+		authRouter.GET("/notification/setting/", service.NotificationSetting)
+		authRouter.POST("/notification/setting/", service.EditNotificationSetting)
+
+		// push
+		authRouter.GET("/push/", service.Push)
+	}
+
+	// 以下功能只允许管理员使用
+	adminRouter := apiRouter.Group("/admin")
+	adminRouter.Use(AuthMiddleware(), AdminMiddleware())
+	{
+		// webhook
+		adminRouter.POST("/webhook/action/", service.WebhookAction)
+		adminRouter.GET("/webhook/list/", service.WebhookList)
+		adminRouter.GET("/webhook/delivery/list/", service.WebhookDeliveryList)
+		adminRouter.POST("/webhook/delivery/redeliver/", service.RedeliverWebhook)
 	}
 
 }
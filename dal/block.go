@@ -0,0 +1,39 @@
+package dal
+
+import (
+	"context"
+	"gorm.io/gorm/clause"
+)
+
+// Block 用户拉黑关系，使用复合主键
+// 一行数据代表 "User 拉黑了 BlockedUser"，被拉黑的用户无法 @ 提及对方
+type Block struct {
+	UserId        int64 `gorm:"primaryKey;autoIncrement:false"`
+	BlockedUserId int64 `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt     int64 `gorm:"autoCreateTime:milli"`
+}
+
+// AddBlock 拉黑用户，已拉黑时不做任何操作
+func AddBlock(ctx context.Context, userId, blockedUserId int64) error {
+	db := DB.WithContext(ctx)
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Block{UserId: userId, BlockedUserId: blockedUserId}).Error
+}
+
+// DeleteBlock 取消拉黑
+func DeleteBlock(ctx context.Context, userId, blockedUserId int64) error {
+	db := DB.WithContext(ctx)
+	return db.Where("user_id = ? AND blocked_user_id = ?", userId, blockedUserId).Delete(&Block{}).Error
+}
+
+// GetBlockers 返回 userIdList 中拉黑了 blockedUserId 的用户
+func GetBlockers(ctx context.Context, userIdList []int64, blockedUserId int64) ([]int64, error) {
+	db := DB.WithContext(ctx)
+	var blockerList []int64
+	if len(userIdList) == 0 {
+		return blockerList, nil
+	}
+	err := db.Model(&Block{}).Where("user_id IN ? AND blocked_user_id = ?", userIdList, blockedUserId).
+		Pluck("user_id", &blockerList).Error
+	return blockerList, err
+}
@@ -0,0 +1,215 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"gorm.io/gorm"
+)
+
+// Collection 用户的收藏夹，与点赞相互独立，不影响视频的点赞数
+type Collection struct {
+	Id         int64  `json:"id" gorm:"primaryKey"`
+	UserId     int64  `json:"user_id" gorm:"not null;index"`
+	Name       string `json:"name" gorm:"not null"`
+	IsPublic   bool   `json:"is_public" gorm:"not null;default:false"` // 公开的收藏夹可以分享给其他用户查看
+	VideoCount int64  `json:"video_count" gorm:"not null;default:0"`   // 收藏的视频总数，用于限制收藏夹容量，返回前替换为查看者可见的视频数
+	CreatedAt  int64  `json:"created_at" gorm:"autoCreateTime:milli"`
+}
+
+// CollectionItem 收藏夹中的视频，按 Position 从大到小排列，新收藏的视频排在最前面
+type CollectionItem struct {
+	Id           int64 `gorm:"primaryKey"`
+	CollectionId int64 `gorm:"not null;uniqueIndex:idx_collection_video"`
+	VideoId      int64 `gorm:"not null;uniqueIndex:idx_collection_video;index"`
+	Position     int64 `gorm:"not null"`
+	CreatedAt    int64 `gorm:"autoCreateTime:milli"`
+}
+
+// AddCollection 创建收藏夹，每个用户最多创建 MaxCollections 个
+func AddCollection(ctx context.Context, collection Collection) (Collection, error) {
+	db := DB.WithContext(ctx)
+	var count int64
+	if err := db.Model(&Collection{}).Where("user_id = ?", collection.UserId).Count(&count).Error; err != nil {
+		return Collection{}, err
+	}
+	if count >= config.MaxCollections {
+		return Collection{}, errors.New("收藏夹数量已达上限")
+	}
+	err := db.Create(&collection).Error
+	return collection, err
+}
+
+// EditCollection 修改收藏夹名称和是否公开，只有创建者可以修改
+func EditCollection(ctx context.Context, userId, collectionId int64, name string, isPublic bool) error {
+	db := DB.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", collectionId, userId).Find(&Collection{}).RowsAffected <= 0 {
+		return errors.New("无法修改收藏夹")
+	}
+	return db.Model(&Collection{}).Where("id = ?", collectionId).Updates(map[string]interface{}{
+		"name":      name,
+		"is_public": isPublic,
+	}).Error
+}
+
+// DeleteCollection 删除收藏夹及其中的收藏记录，只有创建者可以删除
+func DeleteCollection(ctx context.Context, userId, collectionId int64) error {
+	db := DB.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", collectionId, userId).Find(&Collection{}).RowsAffected <= 0 {
+		return errors.New("无法删除收藏夹")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collectionId).Delete(&CollectionItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Collection{}, collectionId).Error
+	})
+}
+
+func GetCollectionById(ctx context.Context, collectionId int64) (Collection, error) {
+	db := DB.WithContext(ctx)
+	var collection Collection
+	err := db.First(&collection, collectionId).Error
+	return collection, err
+}
+
+// GetCollectionByUserId 获取用户创建的所有收藏夹
+func GetCollectionByUserId(ctx context.Context, userId int64) ([]Collection, error) {
+	db := DB.WithContext(ctx)
+	var collectionList []Collection
+	err := db.Where("user_id = ?", userId).Order("created_at desc").Find(&collectionList).Error
+	return collectionList, err
+}
+
+// AddCollectionItem 将视频加入收藏夹的最前面，只有创建者可以操作
+func AddCollectionItem(ctx context.Context, userId, collectionId, videoId int64) error {
+	db := DB.WithContext(ctx)
+	var collection Collection
+	if db.Where("id = ? AND user_id = ?", collectionId, userId).First(&collection).RowsAffected <= 0 {
+		return errors.New("不存在该收藏夹")
+	}
+	if collection.VideoCount >= config.MaxCollectionSize {
+		return errors.New("收藏夹已满")
+	}
+	if db.Where("collection_id = ? AND video_id = ?", collectionId, videoId).Find(&CollectionItem{}).RowsAffected > 0 {
+		return errors.New("已经收藏过")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var maxPosition int64
+		if err := tx.Model(&CollectionItem{}).Select("COALESCE(MAX(position), -1)").Where("collection_id = ?", collectionId).Scan(&maxPosition).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&CollectionItem{CollectionId: collectionId, VideoId: videoId, Position: maxPosition + 1}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Collection{}).Where("id = ?", collectionId).UpdateColumn("video_count", gorm.Expr("video_count + ?", 1)).Error
+	})
+}
+
+// DeleteCollectionItem 将视频移出收藏夹，只有创建者可以操作
+func DeleteCollectionItem(ctx context.Context, userId, collectionId, videoId int64) error {
+	db := DB.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", collectionId, userId).Find(&Collection{}).RowsAffected <= 0 {
+		return errors.New("不存在该收藏夹")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("collection_id = ? AND video_id = ?", collectionId, videoId).Delete(&CollectionItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected <= 0 {
+			return errors.New("不存在该收藏记录")
+		}
+		return tx.Model(&Collection{}).Where("id = ?", collectionId).UpdateColumn("video_count", gorm.Expr("video_count - ?", 1)).Error
+	})
+}
+
+// MoveCollectionItem 将收藏夹中的视频移动到第 toIndex 个位置（从 0 开始），超出范围时移动到最后
+// 重新为位置发生变化的视频编号，只有创建者可以操作
+func MoveCollectionItem(ctx context.Context, userId, collectionId, videoId int64, toIndex int) error {
+	db := DB.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", collectionId, userId).Find(&Collection{}).RowsAffected <= 0 {
+		return errors.New("不存在该收藏夹")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var itemList []CollectionItem
+		if err := tx.Where("collection_id = ?", collectionId).Order("position desc").Find(&itemList).Error; err != nil {
+			return err
+		}
+		from := -1
+		for i, item := range itemList {
+			if item.VideoId == videoId {
+				from = i
+				break
+			}
+		}
+		if from < 0 {
+			return errors.New("不存在该收藏记录")
+		}
+		if toIndex < 0 {
+			toIndex = 0
+		} else if toIndex >= len(itemList) {
+			toIndex = len(itemList) - 1
+		}
+		moved := itemList[from]
+		itemList = append(itemList[:from], itemList[from+1:]...)
+		itemList = append(itemList[:toIndex], append([]CollectionItem{moved}, itemList[toIndex:]...)...)
+		// 第 i 个视频的位置为 n-1-i，只更新位置变化的视频
+		n := int64(len(itemList))
+		for i, item := range itemList {
+			position := n - 1 - int64(i)
+			if item.Position == position {
+				continue
+			}
+			if err := tx.Model(&CollectionItem{}).Where("id = ?", item.Id).UpdateColumn("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetCollectionItems 按位置获取收藏夹中的视频，不包括已删除的视频
+func GetCollectionItems(ctx context.Context, collectionId int64) ([]CollectionItem, error) {
+	db := DB.WithContext(ctx)
+	var itemList []CollectionItem
+	err := db.Where("collection_id = ? AND video_id IN (?)", collectionId, db.Model(&Video{}).Select("id")).Order("position desc").Find(&itemList).Error
+	return itemList, err
+}
+
+// GetCollectionIdsByVideoId 获取收藏了该视频的所有收藏夹 id
+func GetCollectionIdsByVideoId(ctx context.Context, videoId int64) ([]int64, error) {
+	db := DB.WithContext(ctx)
+	var collectionIdList []int64
+	err := db.Model(&CollectionItem{}).Where("video_id = ?", videoId).Pluck("collection_id", &collectionIdList).Error
+	return collectionIdList, err
+}
+
+// CountVisibleCollectionItems 统计收藏夹中 userId 有权限查看的视频数，返回收藏夹 id 到视频数的映射
+// 不计入已删除、未发布以及 userId 无权限查看的视频，可见范围的判断与 cache.CanViewVideo 一致
+func CountVisibleCollectionItems(ctx context.Context, userId int64, collectionIdList []int64) (map[int64]int64, error) {
+	db := DB.WithContext(ctx)
+	countMap := make(map[int64]int64, len(collectionIdList))
+	if len(collectionIdList) == 0 {
+		return countMap, nil
+	}
+	follows := "EXISTS (SELECT 1 FROM relations WHERE user_a_id = ? AND user_b_id = videos.user_id)"
+	followed := "EXISTS (SELECT 1 FROM relations WHERE user_a_id = videos.user_id AND user_b_id = ?)"
+	var rows []struct {
+		CollectionId int64
+		Count        int64
+	}
+	err := db.Model(&CollectionItem{}).Select("collection_items.collection_id, COUNT(*) AS count").
+		Joins("JOIN videos ON videos.id = collection_items.video_id AND videos.deleted_at IS NULL").
+		Where("collection_items.collection_id IN ?", collectionIdList).
+		Where(db.Where("videos.user_id = ?", userId).
+			Or(db.Where("videos.status = ?", StatusPublished).
+				Where(db.Where("videos.visibility = ?", VisibilityPublic).
+					Or("videos.visibility = ? AND "+follows, VisibilityFollower, userId).
+					Or("videos.visibility = ? AND "+follows+" AND "+followed, VisibilityFriend, userId, userId)))).
+		Group("collection_items.collection_id").Scan(&rows).Error
+	for _, row := range rows {
+		countMap[row.CollectionId] = row.Count
+	}
+	return countMap, err
+}
@@ -1,73 +1,220 @@
 package dal
 
 import (
+	"context"
 	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
 	"gorm.io/gorm"
+	"time"
 )
 
+// Comment 评论分为两级：一级评论的 RootId 为 0，回复的 RootId 为所属一级评论的 id
+// 对回复的回复同样归属于该一级评论，通过 ParentId 和 ReplyToUserId 记录回复对象
 type Comment struct {
-	Id         int64  `json:"id" gorm:"primaryKey"`
-	User       User   `json:"user" gorm:"-:all" redistructhash:"no"` // 不使用外键
-	UserId     int64  `gorm:"not null"`
-	VideoId    int64  `gorm:"not null"`
-	Content    string `json:"content" gorm:"not null"`
-	CreateDate string `json:"create_date" gorm:"not null"`
+	Id            int64  `json:"id" gorm:"primaryKey"`
+	User          User   `json:"user" gorm:"-:all" redistructhash:"no"` // 不使用外键
+	UserId        int64  `gorm:"not null"`
+	VideoId       int64  `gorm:"not null;index"`
+	RootId        int64  `json:"root_id" gorm:"not null;default:0;index"`
+	ParentId      int64  `json:"parent_id" gorm:"not null;default:0"`
+	ReplyToUserId int64  `json:"reply_to_user_id,omitempty" gorm:"not null;default:0"`
+	ReplyCount    int64  `json:"reply_count"`
+	LikeCount     int64  `json:"like_count"`
+	IsLiked       bool   `json:"is_liked" gorm:"-:all" redistructhash:"no"`  // IsLiked 是根据 comment_likes 表查询得到的，不需要存储
+	IsPinned      bool   `json:"is_pinned" gorm:"-:all" redistructhash:"no"` // IsPinned 是根据视频的 PinnedCommentId 得到的，不需要存储
+	Content       string `json:"content" gorm:"not null"`
+	CreatedAt     int64  `json:"created_at" gorm:"autoCreateTime:milli;index"`  // 毫秒时间戳，用于排序和分页
+	CreateDate    string `json:"create_date" gorm:"-:all" redistructhash:"no"`  // 根据 CreatedAt 生成，仅用于前端展示
+	EditedAt      int64  `json:"edited_at,omitempty" gorm:"not null;default:0"` // 最后一次编辑的毫秒时间戳，未编辑过为 0
+	IsEdited      bool   `json:"is_edited" gorm:"-:all" redistructhash:"no"`    // 根据 EditedAt 生成
+	// @ 提及的位置，以 JSON 字符串存储，读取时解析到 Mentions 中
+	MentionData string        `json:"-" gorm:"type:text"`
+	Mentions    []MentionSpan `json:"mentions,omitempty" gorm:"-:all" redistructhash:"no"`
 }
 
-// AddComment 发表评论，并返回（comment 的 id 部分会更新为自增 id）
-func AddComment(comment Comment) (int64, error) {
-	// 开启数据库事务，在 comments 中添加记录，在 videos 中更改评论数目
-	if err := DB.Transaction(func(tx *gorm.DB) error {
+// CommentRevision 记录评论被编辑前的历史版本
+type CommentRevision struct {
+	Id        int64  `gorm:"primaryKey"`
+	CommentId int64  `gorm:"not null;index"`
+	Content   string `gorm:"not null"`
+	CreatedAt int64  `gorm:"autoCreateTime:milli"` // 该版本被替换的时间
+}
+
+// 评论排序方式
+const (
+	CommentSortNewest = "newest" // 最新发表在前
+	CommentSortOldest = "oldest" // 最早发表在前
+	CommentSortHot    = "hot"    // 点赞数多的在前
+)
+
+// FormatDisplay 根据 CreatedAt、EditedAt、MentionData 生成前端展示用的字段
+func (comment *Comment) FormatDisplay() {
+	comment.CreateDate = time.UnixMilli(comment.CreatedAt).Format("01-02 15:04:05")
+	comment.IsEdited = comment.EditedAt != 0
+	comment.Mentions = DecodeMentions(comment.MentionData)
+}
+
+// AfterFind 从 MySQL 读取后自动生成展示用的字段
+func (comment *Comment) AfterFind(*gorm.DB) error {
+	comment.FormatDisplay()
+	return nil
+}
+
+// commentRepository 基于 GORM 的 CommentRepository 实现
+type commentRepository struct {
+	db *gorm.DB
+}
+
+// Add 发表评论或回复，并返回（comment 的 id、RootId、ReplyToUserId 会被更新）
+func (r *commentRepository) Add(ctx context.Context, comment Comment) (Comment, error) {
+	db := r.db.WithContext(ctx)
+	// 回复评论时，检查被回复的评论是否存在，并确定所属的一级评论
+	if comment.ParentId != 0 {
+		var parent Comment
+		if db.Where("id = ? AND video_id = ?", comment.ParentId, comment.VideoId).First(&parent).RowsAffected <= 0 {
+			return Comment{}, errors.New("被回复的评论不存在")
+		}
+		comment.RootId = parent.RootId
+		if comment.RootId == 0 {
+			comment.RootId = parent.Id
+		}
+		comment.ReplyToUserId = parent.UserId
+	}
+	// 开启数据库事务，在 comments 中添加记录，在 videos 中更改评论数目，回复还需更改一级评论的回复数目
+	if err := db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&comment).Error; err != nil {
 			return err
 		}
 		if err := tx.Model(&Video{}).Where("id = ?", comment.VideoId).UpdateColumn("comment_count", gorm.Expr("comment_count + ?", 1)).Error; err != nil {
 			return err
 		}
-		// 返回 nil 提交事务
-		return nil
+		if comment.RootId != 0 {
+			if err := tx.Model(&Comment{}).Where("id = ?", comment.RootId).UpdateColumn("reply_count", gorm.Expr("reply_count + ?", 1)).Error; err != nil {
+				return err
+			}
+		}
+		return addOutbox(tx, OutboxComment, CommentChange{CommentId: comment.Id, VideoId: comment.VideoId, RootId: comment.RootId})
 	}); err != nil {
-		return 0, err
+		return Comment{}, err
 	}
-	return comment.Id, nil
+	comment.FormatDisplay()
+	return comment, nil
 }
 
-// DeleteComment 删除评论
-func DeleteComment(userId, videoId, commentId int64) error {
+// Delete 删除评论，删除一级评论时会同时删除其下所有回复
+// 评论作者和视频作者均可删除
+func (r *commentRepository) Delete(ctx context.Context, userId, videoId, commentId int64) error {
+	db := r.db.WithContext(ctx)
 	// 检查是否存在该评论
 	var comment Comment
-	if DB.First(&comment, commentId).RowsAffected <= 0 {
+	if db.Where("id = ? AND video_id = ?", commentId, videoId).First(&comment).RowsAffected <= 0 {
 		return errors.New("不存在该评论")
 	}
-	// 检查是否有该用户对应的评论
-	if DB.Where("id = ? AND user_id = ?", commentId, userId).Find(&Comment{}).RowsAffected <= 0 {
+	// 检查是否是该用户的评论，或该用户是视频作者
+	if comment.UserId != userId && db.Where("id = ? AND user_id = ?", videoId, userId).Find(&Video{}).RowsAffected <= 0 {
 		return errors.New("无法删除评论")
 	}
 	// 可选删除方案：1. 直接在数据库中删除; 2. 软删除：comments 中设置一个 deleted 列，用 bool 表示是否删除
 	// 目前实现的是第一种
 	// 开启数据库事务
-	if err := DB.Transaction(func(tx *gorm.DB) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		// 先删除评论及其回复的点赞记录和历史版本
+		if err := tx.Where("comment_id = ? OR comment_id IN (?)", comment.Id, tx.Model(&Comment{}).Select("id").Where("root_id = ?", comment.Id)).Delete(&CommentLike{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id = ? OR comment_id IN (?)", comment.Id, tx.Model(&Comment{}).Select("id").Where("root_id = ?", comment.Id)).Delete(&CommentRevision{}).Error; err != nil {
+			return err
+		}
 		if err := tx.Delete(&comment).Error; err != nil {
 			return err
 		}
-		if err := tx.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("comment_count", gorm.Expr("comment_count - ?", 1)).Error; err != nil {
+		deleteCount := int64(1)
+		change := CommentChange{CommentId: comment.Id, VideoId: videoId, RootId: comment.RootId}
+		if comment.RootId == 0 { // 一级评论，级联删除所有回复
+			if err := tx.Model(&Comment{}).Where("root_id = ?", comment.Id).Pluck("id", &change.ReplyIds).Error; err != nil {
+				return err
+			}
+			result := tx.Where("root_id = ?", comment.Id).Delete(&Comment{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleteCount += result.RowsAffected
+		} else { // 回复，更改一级评论的回复数目
+			if err := tx.Model(&Comment{}).Where("id = ?", comment.RootId).UpdateColumn("reply_count", gorm.Expr("reply_count - ?", 1)).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("comment_count", gorm.Expr("comment_count - ?", deleteCount)).Error; err != nil {
 			return err
 		}
-		return nil
+		// 删除的是置顶评论时取消置顶
+		if err := tx.Model(&Video{}).Where("id = ? AND pinned_comment_id = ?", videoId, comment.Id).UpdateColumn("pinned_comment_id", 0).Error; err != nil {
+			return err
+		}
+		return addOutbox(tx, OutboxComment, change)
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetCommentById(commentId int64) (Comment, error) {
+// Edit 编辑评论，只有评论作者可以在发表后的一段时间内编辑，编辑前的内容存入历史版本
+// mentionData 为新内容中的 @ 提及位置
+func (r *commentRepository) Edit(ctx context.Context, userId, commentId int64, content, mentionData string) (Comment, error) {
+	db := r.db.WithContext(ctx)
 	var comment Comment
-	err := DB.First(&comment, commentId).Error
+	if db.Where("id = ? AND user_id = ?", commentId, userId).First(&comment).RowsAffected <= 0 {
+		return Comment{}, errors.New("无法编辑评论")
+	}
+	if time.Since(time.UnixMilli(comment.CreatedAt)) > config.CommentEditExp {
+		return Comment{}, errors.New("已超出可编辑期限")
+	}
+	revision := CommentRevision{
+		CommentId: comment.Id,
+		Content:   comment.Content,
+	}
+	comment.Content = content
+	comment.MentionData = mentionData
+	comment.EditedAt = time.Now().UnixMilli()
+	// 开启数据库事务，在 comment_revisions 中添加记录，在 comments 中更新内容
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Comment{}).Where("id = ?", comment.Id).Updates(map[string]interface{}{
+			"content":      comment.Content,
+			"mention_data": comment.MentionData,
+			"edited_at":    comment.EditedAt,
+		}).Error; err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return Comment{}, err
+	}
+	comment.FormatDisplay()
+	return comment, nil
+}
+
+func (r *commentRepository) GetById(ctx context.Context, commentId int64) (Comment, error) {
+	db := r.db.WithContext(ctx)
+	var comment Comment
+	err := db.First(&comment, commentId).Error
 	return comment, err
 }
 
-func GetCommentByVideoId(videoId int64) ([]Comment, error) {
+// GetByVideoId 获取视频的一级评论，不包括回复，按发表时间倒序排列
+func (r *commentRepository) GetByVideoId(ctx context.Context, videoId int64) ([]Comment, error) {
+	db := r.db.WithContext(ctx)
 	var commentList []Comment
-	err := DB.Where("video_id = ?", videoId).Find(&commentList).Error
+	err := db.Where("video_id = ? AND root_id = 0", videoId).Order("created_at desc, id desc").Find(&commentList).Error
 	return commentList, err
 }
+
+// GetReplyByRootId 获取一级评论下的所有回复
+func (r *commentRepository) GetReplyByRootId(ctx context.Context, rootId int64) ([]Comment, error) {
+	db := r.db.WithContext(ctx)
+	var replyList []Comment
+	err := db.Where("root_id = ?", rootId).Order("created_at, id").Find(&replyList).Error
+	return replyList, err
+}
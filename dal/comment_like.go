@@ -0,0 +1,84 @@
+package dal
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CommentLike 记录用户点赞的评论，使用复合主键
+type CommentLike struct {
+	UserId    int64 `gorm:"primaryKey;autoIncrement:false"`
+	CommentId int64 `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// AddCommentLike 点赞评论，重复点赞不会重复计数，返回点赞数是否发生变化
+func AddCommentLike(ctx context.Context, userId, commentId int64) (bool, error) {
+	db := DB.WithContext(ctx)
+	// 检查是否已存在点赞记录
+	if db.Where("user_id = ? AND comment_id = ?", userId, commentId).Find(&CommentLike{}).RowsAffected > 0 {
+		return false, nil
+	}
+	// 检查评论是否存在
+	if err := db.Select("id").First(&Comment{}, commentId).Error; err != nil {
+		return false, err
+	}
+	changed := false
+	// 开启数据库事务，在 comment_likes 中添加记录，在 comments 中更改点赞数目
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&CommentLike{UserId: userId, CommentId: commentId})
+		if result.Error != nil {
+			return result.Error
+		}
+		// 并发点赞时只有一个请求能插入成功
+		if result.RowsAffected != 1 {
+			return nil
+		}
+		if err := tx.Model(&Comment{}).Where("id = ?", commentId).UpdateColumn("like_count", gorm.Expr("like_count + ?", 1)).Error; err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+// DeleteCommentLike 取消点赞评论，重复取消不会重复计数，返回点赞数是否发生变化
+func DeleteCommentLike(ctx context.Context, userId, commentId int64) (bool, error) {
+	db := DB.WithContext(ctx)
+	var like CommentLike
+	// 检查是否存在点赞记录
+	if db.Where("user_id = ? AND comment_id = ?", userId, commentId).First(&like).RowsAffected <= 0 {
+		return false, nil
+	}
+	changed := false
+	// 开启数据库事务，在 comment_likes 中删除记录，在 comments 中更改点赞数目
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&like)
+		if result.Error != nil {
+			return result.Error
+		}
+		// 并发取消时只有一个请求能删除成功
+		if result.RowsAffected <= 0 {
+			return nil
+		}
+		if err := tx.Model(&Comment{}).Where("id = ?", commentId).UpdateColumn("like_count", gorm.Expr("like_count - ?", 1)).Error; err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+// GetCommentLikeByUserId 获取用户点赞过的所有评论
+func GetCommentLikeByUserId(ctx context.Context, userId int64) ([]CommentLike, error) {
+	db := DB.WithContext(ctx)
+	var likeList []CommentLike
+	err := db.Where("user_id = ?", userId).Find(&likeList).Error
+	return likeList, err
+}
@@ -1,22 +1,36 @@
 package dal
 
 import (
+	"errors"
 	"github.com/zenpk/mini-douyin-ex/config"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"time"
 )
 
 var DB *gorm.DB
 
-// ConnectDB 连接 MySQL
+// ConnectDB 连接数据库，开发模式下使用 SQLite，否则使用 MySQL，并设置基于 GORM 的数据访问实现
 func ConnectDB() error {
-	dsn := config.DBUserPass + "@tcp(" + config.DBAddr + ")/" + config.DBName + "?charset=utf8mb4&parseTime=True&loc=Local"
+	var dialector gorm.Dialector
+	switch config.Mode {
+	case config.ModeProd:
+		dsn := config.DBUserPass + "@tcp(" + config.DBAddr + ")/" + config.DBName + "?charset=utf8mb4&parseTime=True&loc=Local"
+		dialector = mysql.Open(dsn)
+	case config.ModeDev:
+		// 写锁被占用时等待而不是直接返回 database is locked
+		// 事务开始时即获取写锁，避免两个先读后写的事务互相等待
+		dialector = sqlite.Open("file:" + config.SQLiteFile + "?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	default:
+		return errors.New("未知的运行模式：" + config.Mode)
+	}
 	var err error
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return err
 	}
-	// 创建 User, Video, Comment, Favorite, Relation 表
+	// 创建 User, Video, Comment, Favorite, Relation, CommentLike, CommentRevision, FilterLog, Mention, Topic, VideoTopic, Collection, CollectionItem, Message, Conversation, Notification, NotificationActor, NotificationSetting, Outbox 表
 	if err := DB.AutoMigrate(&User{}); err != nil {
 		return err
 	}
@@ -26,11 +40,160 @@ func ConnectDB() error {
 	if err := DB.AutoMigrate(&Comment{}); err != nil {
 		return err
 	}
+	if err := migrateCommentCreateDate(); err != nil {
+		return err
+	}
 	if err := DB.AutoMigrate(&Favorite{}); err != nil {
 		return err
 	}
+	if err := migrateFavoriteCreatedAt(); err != nil {
+		return err
+	}
 	if err := DB.AutoMigrate(&Relation{}); err != nil {
 		return err
 	}
+	if err := DB.AutoMigrate(&Block{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&CommentLike{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&CommentRevision{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&FilterLog{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Mention{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Topic{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&VideoTopic{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Collection{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&CollectionItem{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Message{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Conversation{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Notification{}); err != nil {
+		return err
+	}
+	if err := migrateNotificationMergeKey(); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&NotificationActor{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&NotificationSetting{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Outbox{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&Webhook{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&WebhookDelivery{}); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&PlayCountBatch{}); err != nil {
+		return err
+	}
+	Use(NewRepositories(DB))
+	return nil
+}
+
+// migrateCommentCreateDate 旧版本的评论只保存了不含年份的 create_date 字符串
+// 这里将其转换为 created_at 时间戳，年份取当前年份（若晚于当前时间则取前一年），然后删除旧列
+func migrateCommentCreateDate() error {
+	if !DB.Migrator().HasColumn(&Comment{}, "create_date") {
+		return nil
+	}
+	type oldComment struct {
+		Id         int64
+		CreateDate string
+	}
+	var oldList []oldComment
+	if err := DB.Table("comments").Select("id, create_date").Where("created_at IS NULL OR created_at = 0").Scan(&oldList).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, old := range oldList {
+		createdAt, err := time.ParseInLocation("2006-01-02 15:04:05", now.Format("2006-")+old.CreateDate, time.Local)
+		if err != nil { // 无法解析的日期使用当前时间
+			createdAt = now
+		} else if createdAt.After(now) {
+			createdAt = createdAt.AddDate(-1, 0, 0)
+		}
+		if err := DB.Table("comments").Where("id = ?", old.Id).UpdateColumn("created_at", createdAt.UnixMilli()).Error; err != nil {
+			return err
+		}
+	}
+	return DB.Migrator().DropColumn(&Comment{}, "create_date")
+}
+
+// migrateFavoriteCreatedAt 旧版本的点赞没有记录时间，新增的 created_at 列默认为 0
+// 点赞不会早于视频发布，因此以视频的投稿时间作为点赞时间，视频没有投稿时间的则使用当前时间之前的时间
+// 两种情况都按视频 id 错开若干毫秒，使同一用户迁移后的点赞时间互不相同，分页时顺序稳定
+func migrateFavoriteCreatedAt() error {
+	// 使用子查询而不是 UPDATE JOIN，以兼容 SQLite
+	createTime := DB.Unscoped().Model(&Video{}).Select("create_time * 1000 + favorites.video_id % 1000").Where("videos.id = favorites.video_id AND create_time > 0")
+	if err := DB.Model(&Favorite{}).Where("created_at = 0 AND EXISTS (?)", createTime).
+		UpdateColumn("created_at", createTime).Error; err != nil {
+		return err
+	}
+	var maxVideoId int64
+	if err := DB.Model(&Favorite{}).Where("created_at = 0").Select("COALESCE(MAX(video_id), 0)").Scan(&maxVideoId).Error; err != nil {
+		return err
+	}
+	base := time.Now().UnixMilli() - maxVideoId - 1
+	return DB.Model(&Favorite{}).Where("created_at = 0").UpdateColumn("created_at", gorm.Expr("? + video_id", base)).Error
+}
+
+// migrateNotificationMergeKey 旧版本的未读通知没有合并键，新增的 merge_key 列为空
+// 为每组可以合并的未读通知中最新的一条设置合并键，之后的新动作合并到该通知，其余的保持为空
+func migrateNotificationMergeKey() error {
+	var keyedList []Notification
+	if err := DB.Select("user_id, merge_key").Where("merge_key IS NOT NULL").Find(&keyedList).Error; err != nil {
+		return err
+	}
+	seen := make(map[int64]map[string]bool)
+	mark := func(userId int64, mergeKey string) bool {
+		if seen[userId] == nil {
+			seen[userId] = make(map[string]bool)
+		}
+		if seen[userId][mergeKey] {
+			return false
+		}
+		seen[userId][mergeKey] = true
+		return true
+	}
+	for _, keyed := range keyedList {
+		mark(keyed.UserId, *keyed.MergeKey)
+	}
+	var unreadList []Notification
+	if err := DB.Select("id, user_id, type, video_id").Where("is_read = ? AND type <> ? AND merge_key IS NULL", false, NotificationMention).
+		Order("id desc").Find(&unreadList).Error; err != nil {
+		return err
+	}
+	for _, unread := range unreadList {
+		mergeKey := NotificationMergeKey(unread.Type, unread.VideoId)
+		if !mark(unread.UserId, mergeKey) {
+			continue
+		}
+		if err := DB.Model(&Notification{}).Where("id = ?", unread.Id).UpdateColumn("merge_key", mergeKey).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -1,65 +1,92 @@
 package dal
 
 import (
+	"context"
 	"errors"
 	"gorm.io/gorm"
 )
 
 // Favorite 记录用户点赞的视频，使用复合主键
 type Favorite struct {
-	UserId  int64 `gorm:"primaryKey;autoIncrement:false"`
-	VideoId int64 `gorm:"primaryKey;autoIncrement:false"`
+	UserId    int64 `gorm:"primaryKey;autoIncrement:false"`
+	VideoId   int64 `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt int64 `gorm:"not null;default:0;autoCreateTime:milli"` // 点赞时间，毫秒时间戳
 }
 
-// AddFavorite 点赞操作，通过数据库事务保证数据一致性
-func AddFavorite(userId, videoId int64) error {
+// favoriteRepository 基于 GORM 的 FavoriteRepository 实现
+type favoriteRepository struct {
+	db *gorm.DB
+}
+
+// Add 点赞操作，通过数据库事务保证数据一致性，并在同一事务中记录发件箱任务
+func (r *favoriteRepository) Add(ctx context.Context, userId, videoId int64) (Favorite, error) {
+	db := r.db.WithContext(ctx)
 	// 检查是否已存在点赞记录
-	if DB.Where("user_id = ? AND video_id = ?", userId, videoId).Find(&Favorite{}).RowsAffected > 0 {
-		return errors.New("已经点赞过")
+	if db.Where("user_id = ? AND video_id = ?", userId, videoId).Find(&Favorite{}).RowsAffected > 0 {
+		return Favorite{}, errors.New("已经点赞过")
 	}
 	favorite := Favorite{
 		UserId:  userId,
 		VideoId: videoId,
 	}
 	// 开启数据库事务，在 favorites 中添加记录，在 videos 中更改点赞数目
-	if err := DB.Transaction(func(tx *gorm.DB) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&favorite).Error; err != nil {
 			return err
 		}
 		if err := tx.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("favorite_count", gorm.Expr("favorite_count + ?", 1)).Error; err != nil {
 			return err
 		}
-		return nil
+		return addOutbox(tx, OutboxFavorite, FavoriteChange{UserId: userId, VideoId: videoId})
 	}); err != nil {
-		return err
+		return Favorite{}, err
 	}
-	return nil
+	return favorite, nil
 }
 
-// DeleteFavorite 取消点赞操作
-func DeleteFavorite(userId, videoId int64) error {
+// Delete 取消点赞操作
+func (r *favoriteRepository) Delete(ctx context.Context, userId, videoId int64) error {
+	db := r.db.WithContext(ctx)
 	var favorite Favorite
 	// 检查是否存在点赞记录
-	if DB.Where("user_id = ? AND video_id = ?", userId, videoId).First(&favorite).RowsAffected <= 0 {
+	if db.Where("user_id = ? AND video_id = ?", userId, videoId).First(&favorite).RowsAffected <= 0 {
 		return errors.New("不存在点赞记录")
 	}
 	// 开启数据库事务，在 favorites 中添加记录，在 videos 中更改点赞数目
-	if err := DB.Transaction(func(tx *gorm.DB) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Delete(&favorite).Error; err != nil {
 			return err
 		}
 		if err := tx.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("favorite_count", gorm.Expr("favorite_count - ?", 1)).Error; err != nil {
 			return err
 		}
-		return nil
+		return addOutbox(tx, OutboxFavorite, FavoriteChange{UserId: userId, VideoId: videoId})
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetFavoriteByUserId(userId int64) ([]Favorite, error) {
+// Get 获取用户对视频的点赞记录，未点赞时返回 gorm.ErrRecordNotFound
+func (r *favoriteRepository) Get(ctx context.Context, userId, videoId int64) (Favorite, error) {
+	db := r.db.WithContext(ctx)
+	var favorite Favorite
+	err := db.Where("user_id = ? AND video_id = ?", userId, videoId).First(&favorite).Error
+	return favorite, err
+}
+
+// GetByUserId 按点赞时间倒序获取用户点赞的视频，不包括已删除的视频
+func (r *favoriteRepository) GetByUserId(ctx context.Context, userId int64) ([]Favorite, error) {
+	db := r.db.WithContext(ctx)
+	var favoriteList []Favorite
+	err := db.Where("user_id = ? AND video_id IN (?)", userId, db.Model(&Video{}).Select("id")).Order("created_at desc").Find(&favoriteList).Error
+	return favoriteList, err
+}
+
+// GetByVideoId 获取点赞了该视频的所有记录
+func (r *favoriteRepository) GetByVideoId(ctx context.Context, videoId int64) ([]Favorite, error) {
+	db := r.db.WithContext(ctx)
 	var favoriteList []Favorite
-	err := DB.Where("user_id = ?", userId).Find(&favoriteList).Error
+	err := db.Where("video_id = ?", videoId).Find(&favoriteList).Error
 	return favoriteList, err
 }
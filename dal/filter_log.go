@@ -0,0 +1,20 @@
+package dal
+
+import "context"
+
+// FilterLog 记录内容过滤和反垃圾的处理结果，供人工审核
+type FilterLog struct {
+	Id        int64  `gorm:"primaryKey"`
+	UserId    int64  `gorm:"not null;index"`
+	Scene     string `gorm:"not null"` // 内容来源，如 comment、title
+	Content   string `gorm:"not null"` // 过滤前的原始内容
+	Result    string `gorm:"not null"` // 处理结果，如 reject、mask、review、spam_rate、spam_duplicate
+	Words     string // 命中的敏感词，以逗号分隔
+	CreatedAt int64  `gorm:"autoCreateTime:milli;index"`
+}
+
+// AddFilterLog 记录一次过滤结果
+func AddFilterLog(ctx context.Context, filterLog FilterLog) error {
+	db := DB.WithContext(ctx)
+	return db.Create(&filterLog).Error
+}
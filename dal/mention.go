@@ -0,0 +1,109 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// MentionSpan 文本中的一处 @ 提及，Start、End 为 @username 在文本中的字符（rune）下标，左闭右开
+type MentionSpan struct {
+	UserId int64 `json:"user_id"`
+	Start  int   `json:"start"`
+	End    int   `json:"end"`
+}
+
+// Mention 记录用户被 @ 提及，CommentId 为 0 表示在视频标题中被提及
+type Mention struct {
+	Id         int64 `json:"id" gorm:"primaryKey"`
+	UserId     int64 `json:"-" gorm:"not null;index"` // 被提及的用户
+	FromUser   User  `json:"from_user" gorm:"-:all"`
+	FromUserId int64 `json:"-" gorm:"not null"`
+	VideoId    int64 `json:"video_id" gorm:"not null"`
+	CommentId  int64 `json:"comment_id" gorm:"not null;default:0"`
+	Pending    bool  `json:"-" gorm:"not null;default:false"` // 尚未通知被提及的用户
+	CreatedAt  int64 `json:"created_at" gorm:"autoCreateTime:milli"`
+}
+
+// EncodeMentions 将提及位置编码为 JSON 字符串，方便存入 MySQL 和 Redis
+func EncodeMentions(spans []MentionSpan) string {
+	if len(spans) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(spans)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeMentions 解析 EncodeMentions 生成的字符串，解析失败时返回空
+func DecodeMentions(data string) []MentionSpan {
+	if data == "" {
+		return nil
+	}
+	var spans []MentionSpan
+	if err := json.Unmarshal([]byte(data), &spans); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return spans
+}
+
+// AddMentions 为每个被提及的用户记录一条提及，同一用户只记录一次，不记录提及自己
+// 重复调用时不会重复记录，返回尚未通知的提及，调用方通知后调用 MarkMentionNotified
+func AddMentions(ctx context.Context, fromUserId, videoId, commentId int64, spans []MentionSpan) ([]Mention, error) {
+	db := DB.WithContext(ctx)
+	var mentionList []Mention
+	if err := db.Where("video_id = ? AND comment_id = ?", videoId, commentId).Find(&mentionList).Error; err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]bool)
+	var pendingList []Mention
+	for _, mention := range mentionList {
+		seen[mention.UserId] = true
+		if mention.Pending {
+			pendingList = append(pendingList, mention)
+		}
+	}
+	var newList []Mention
+	for _, span := range spans {
+		if span.UserId == fromUserId || seen[span.UserId] {
+			continue
+		}
+		seen[span.UserId] = true
+		newList = append(newList, Mention{
+			UserId:     span.UserId,
+			FromUserId: fromUserId,
+			VideoId:    videoId,
+			CommentId:  commentId,
+			Pending:    true,
+		})
+	}
+	if len(newList) == 0 {
+		return pendingList, nil
+	}
+	if err := db.Create(&newList).Error; err != nil {
+		return nil, err
+	}
+	return append(pendingList, newList...), nil
+}
+
+// MarkMentionNotified 记录已通知被提及的用户
+func MarkMentionNotified(ctx context.Context, mentionId int64) error {
+	db := DB.WithContext(ctx)
+	return db.Model(&Mention{}).Where("id = ?", mentionId).Update("pending", false).Error
+}
+
+// GetMentionList 按时间倒序分页获取用户被提及的记录，cursor 为上一页最后一条记录的 id，首页为 0
+func GetMentionList(ctx context.Context, userId, cursor int64, count int) ([]Mention, error) {
+	db := DB.WithContext(ctx)
+	var mentionList []Mention
+	query := db.Where("user_id = ?", userId)
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+	err := query.Order("id desc").Limit(count).Find(&mentionList).Error
+	return mentionList, err
+}
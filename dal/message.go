@@ -0,0 +1,150 @@
+package dal
+
+import (
+	"context"
+	"fmt"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"unicode/utf8"
+)
+
+// Message 用户之间的私信，ChatId 由双方 id 生成，便于查询两人之间的所有私信
+type Message struct {
+	Id         int64  `json:"id" gorm:"primaryKey"`
+	ChatId     string `json:"-" gorm:"type:varchar(64);not null;index:idx_chat_time,priority:1"`
+	FromUserId int64  `json:"from_user_id" gorm:"not null"`
+	ToUserId   int64  `json:"to_user_id" gorm:"not null"`
+	Content    string `json:"content" gorm:"type:text;not null"`
+	CreateTime int64  `json:"create_time" gorm:"autoCreateTime:milli;index:idx_chat_time,priority:2"` // 毫秒时间戳
+}
+
+// Conversation 用户的会话，每个用户和每个聊天对象各有一条，记录最后一条私信的预览和未读数
+type Conversation struct {
+	UserId         int64  `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	PeerId         int64  `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Peer           User   `json:"peer" gorm:"-:all" redistructhash:"no"`
+	LastMessageId  int64  `json:"last_message_id" gorm:"not null"`
+	LastFromUserId int64  `json:"last_from_user_id" gorm:"not null"`
+	LastContent    string `json:"last_content" gorm:"not null"` // 最后一条私信的预览，最多 MessagePreviewLen 个字符
+	LastTime       int64  `json:"last_time" gorm:"not null;index"`
+	UnreadCount    int64  `json:"unread_count" gorm:"not null;default:0"`
+}
+
+// ChatId 两个用户之间的会话 id，与参数顺序无关
+func ChatId(userAId, userBId int64) string {
+	if userAId > userBId {
+		userAId, userBId = userBId, userAId
+	}
+	return fmt.Sprintf("%d_%d", userAId, userBId)
+}
+
+// messagePreview 截取私信内容作为会话列表中的预览
+func messagePreview(content string) string {
+	if utf8.RuneCountInString(content) <= config.MessagePreviewLen {
+		return content
+	}
+	return string([]rune(content)[:config.MessagePreviewLen]) + "…"
+}
+
+// AddMessage 发送私信，同时更新双方的会话，接收方未读数加一
+func AddMessage(ctx context.Context, message Message) (Message, error) {
+	db := DB.WithContext(ctx)
+	message.ChatId = ChatId(message.FromUserId, message.ToUserId)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		preview := messagePreview(message.Content)
+		lastUpdates := map[string]interface{}{
+			"last_message_id":   message.Id,
+			"last_from_user_id": message.FromUserId,
+			"last_content":      preview,
+			"last_time":         message.CreateTime,
+		}
+		// 发送方的会话
+		if err := tx.Clauses(clause.OnConflict{DoUpdates: clause.Assignments(lastUpdates)}).Create(&Conversation{
+			UserId:         message.FromUserId,
+			PeerId:         message.ToUserId,
+			LastMessageId:  message.Id,
+			LastFromUserId: message.FromUserId,
+			LastContent:    preview,
+			LastTime:       message.CreateTime,
+		}).Error; err != nil {
+			return err
+		}
+		// 接收方的会话，未读数加一
+		receiverUpdates := map[string]interface{}{"unread_count": gorm.Expr("unread_count + ?", 1)}
+		for k, v := range lastUpdates {
+			receiverUpdates[k] = v
+		}
+		return tx.Clauses(clause.OnConflict{DoUpdates: clause.Assignments(receiverUpdates)}).Create(&Conversation{
+			UserId:         message.ToUserId,
+			PeerId:         message.FromUserId,
+			LastMessageId:  message.Id,
+			LastFromUserId: message.FromUserId,
+			LastContent:    preview,
+			LastTime:       message.CreateTime,
+			UnreadCount:    1,
+		}).Error
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+func GetMessageById(ctx context.Context, messageId int64) (Message, error) {
+	db := DB.WithContext(ctx)
+	var message Message
+	err := db.First(&message, messageId).Error
+	return message, err
+}
+
+// GetMessageList 获取两人之间的私信，按发送时间正序排列
+// preMsgTime 大于 0 时返回其后发送的最多 count 条，否则返回最新的 count 条
+func GetMessageList(ctx context.Context, userAId, userBId, preMsgTime int64, count int) ([]Message, error) {
+	db := DB.WithContext(ctx)
+	var messageList []Message
+	chatId := ChatId(userAId, userBId)
+	if preMsgTime > 0 {
+		err := db.Where("chat_id = ? AND create_time > ?", chatId, preMsgTime).Order("create_time asc, id asc").Limit(count).Find(&messageList).Error
+		return messageList, err
+	}
+	if err := db.Where("chat_id = ?", chatId).Order("create_time desc, id desc").Limit(count).Find(&messageList).Error; err != nil {
+		return []Message{}, err
+	}
+	for i, j := 0, len(messageList)-1; i < j; i, j = i+1, j-1 {
+		messageList[i], messageList[j] = messageList[j], messageList[i]
+	}
+	return messageList, nil
+}
+
+// ClearUnread 将用户与 peerId 的会话标记为已读
+func ClearUnread(ctx context.Context, userId, peerId int64) error {
+	db := DB.WithContext(ctx)
+	return db.Model(&Conversation{}).Where("user_id = ? AND peer_id = ?", userId, peerId).UpdateColumn("unread_count", 0).Error
+}
+
+// GetTotalUnread 获取用户所有会话的未读数之和
+func GetTotalUnread(ctx context.Context, userId int64) (int64, error) {
+	db := DB.WithContext(ctx)
+	var totalUnread int64
+	err := db.Model(&Conversation{}).Select("COALESCE(SUM(unread_count), 0)").Where("user_id = ?", userId).Scan(&totalUnread).Error
+	return totalUnread, err
+}
+
+func GetConversation(ctx context.Context, userId, peerId int64) (Conversation, error) {
+	db := DB.WithContext(ctx)
+	var conversation Conversation
+	err := db.Where("user_id = ? AND peer_id = ?", userId, peerId).First(&conversation).Error
+	return conversation, err
+}
+
+// GetConversationList 获取用户最近的 count 个会话，按最后一条私信的时间倒序排列
+func GetConversationList(ctx context.Context, userId int64, count int) ([]Conversation, error) {
+	db := DB.WithContext(ctx)
+	var conversationList []Conversation
+	err := db.Where("user_id = ?", userId).Order("last_time desc").Limit(count).Find(&conversationList).Error
+	return conversationList, err
+}
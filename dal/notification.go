@@ -0,0 +1,203 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"time"
+)
+
+// 通知类型
+const (
+	NotificationLike    = "like"    // 视频被点赞
+	NotificationComment = "comment" // 视频被评论
+	NotificationFollow  = "follow"  // 被关注
+	NotificationMention = "mention" // 被 @ 提及
+)
+
+// Notification 用户收到的通知
+// 点赞、评论和关注在未读期间会合并为一条，如 "A 和另外 12 人赞了你的视频"，已读后的新动作产生新的通知
+type Notification struct {
+	Id            int64   `json:"id" gorm:"primaryKey"`
+	UserId        int64   `json:"-" gorm:"not null;index:idx_user_updated,priority:1;uniqueIndex:idx_user_merge,priority:1"` // 接收通知的用户
+	Type          string  `json:"type" gorm:"type:varchar(16);not null"`
+	VideoId       int64   `json:"video_id" gorm:"not null;default:0"`   // 关注通知为 0
+	Video         *Video  `json:"video,omitempty" gorm:"-:all"`         // 视频已删除或不可见时为空
+	CommentId     int64   `json:"comment_id" gorm:"not null;default:0"` // 最新一条评论或提及所在的评论，标题中的提及为 0
+	LatestActorId int64   `json:"-" gorm:"not null"`
+	Actors        []User  `json:"actors" gorm:"-:all"` // 最近的几个操作者，最新的在前
+	ActorCount    int64   `json:"actor_count" gorm:"not null;default:1"`
+	IsRead        bool    `json:"is_read" gorm:"not null;default:false"`
+	MergeKey      *string `json:"-" gorm:"type:varchar(64);uniqueIndex:idx_user_merge,priority:2"` // 未读的可合并通知为 NotificationMergeKey，已读和提及通知为空
+	CreatedAt     int64   `json:"created_at" gorm:"autoCreateTime:milli"`
+	UpdatedAt     int64   `json:"updated_at" gorm:"autoUpdateTime:milli;index:idx_user_updated,priority:2"` // 最后一次合并的时间，列表按此排序
+}
+
+// NotificationActor 合并到通知中的操作者，用于去重和展示最近的操作者
+type NotificationActor struct {
+	Id             int64 `gorm:"primaryKey"`
+	NotificationId int64 `gorm:"not null;uniqueIndex:idx_notification_actor"`
+	ActorId        int64 `gorm:"not null;uniqueIndex:idx_notification_actor"`
+	CreatedAt      int64 `gorm:"autoCreateTime:milli"`
+}
+
+// NotificationSetting 用户对每种通知的开关，没有记录时全部开启
+type NotificationSetting struct {
+	UserId  int64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Like    bool  `json:"like" gorm:"not null"`
+	Comment bool  `json:"comment" gorm:"not null"`
+	Follow  bool  `json:"follow" gorm:"not null"`
+	Mention bool  `json:"mention" gorm:"not null"`
+}
+
+// Enabled 判断用户是否接收该类型的通知
+func (setting NotificationSetting) Enabled(notificationType string) bool {
+	switch notificationType {
+	case NotificationLike:
+		return setting.Like
+	case NotificationComment:
+		return setting.Comment
+	case NotificationFollow:
+		return setting.Follow
+	case NotificationMention:
+		return setting.Mention
+	default:
+		return false
+	}
+}
+
+// ValidNotificationType 判断是否为支持的通知类型
+func ValidNotificationType(notificationType string) bool {
+	return NotificationSetting{Like: true, Comment: true, Follow: true, Mention: true}.Enabled(notificationType)
+}
+
+// NotificationMergeKey 未读通知的合并键，同一用户同一视频（关注为 0）的同类通知合并为一条
+func NotificationMergeKey(notificationType string, videoId int64) string {
+	return notificationType + ":" + strconv.FormatInt(videoId, 10)
+}
+
+// AddNotification 记录一次点赞、评论、关注或提及
+// 除提及外，同一视频（关注为同一用户）的未读通知会合并，同一操作者重复点赞或关注不会改变通知
+// 返回通知是否有变化，没有变化时不需要推送
+func AddNotification(ctx context.Context, notification Notification) (Notification, bool, error) {
+	db := DB.WithContext(ctx)
+	now := time.Now().UnixMilli()
+	changed := false
+	if notification.Type != NotificationMention {
+		mergeKey := NotificationMergeKey(notification.Type, notification.VideoId)
+		notification.MergeKey = &mergeKey
+	}
+	notification.ActorCount = 1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 已有同一合并键的未读通知时不插入，改为合并到该通知
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			changed = true
+			return tx.Create(&NotificationActor{NotificationId: notification.Id, ActorId: notification.LatestActorId}).Error
+		}
+		var unread Notification
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND merge_key = ?", notification.UserId, *notification.MergeKey).
+			First(&unread).Error; err != nil {
+			return err
+		}
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&NotificationActor{NotificationId: unread.Id, ActorId: notification.LatestActorId})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected <= 0 && notification.Type != NotificationComment {
+			// 重复点赞或关注
+			notification = unread
+			return nil
+		}
+		updates := map[string]interface{}{
+			"latest_actor_id": notification.LatestActorId,
+			"comment_id":      notification.CommentId,
+			"updated_at":      now,
+		}
+		if result.RowsAffected > 0 {
+			updates["actor_count"] = gorm.Expr("actor_count + ?", 1)
+			unread.ActorCount++
+		}
+		if err := tx.Model(&Notification{}).Where("id = ?", unread.Id).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+		unread.LatestActorId = notification.LatestActorId
+		unread.CommentId = notification.CommentId
+		unread.UpdatedAt = now
+		notification = unread
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return Notification{}, false, err
+	}
+	return notification, changed, nil
+}
+
+// GetNotificationList 按最后更新时间倒序分页获取通知，notificationType 为空时获取所有类型
+// cursor、cursorId 为上一页最后一条通知的更新时间和 id，首页为 0，更新时间相同的通知按 id 倒序
+func GetNotificationList(ctx context.Context, userId int64, notificationType string, cursor, cursorId int64, count int) ([]Notification, error) {
+	db := DB.WithContext(ctx)
+	var notificationList []Notification
+	query := db.Where("user_id = ?", userId)
+	if notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+	if cursor > 0 && cursorId > 0 {
+		query = query.Where("updated_at < ? OR (updated_at = ? AND id < ?)", cursor, cursor, cursorId)
+	} else if cursor > 0 {
+		query = query.Where("updated_at < ?", cursor)
+	}
+	err := query.Order("updated_at desc, id desc").Limit(count).Find(&notificationList).Error
+	return notificationList, err
+}
+
+// GetNotificationActorIds 获取通知最近的 count 个操作者，最新的在前
+func GetNotificationActorIds(ctx context.Context, notificationId int64, count int) ([]int64, error) {
+	db := DB.WithContext(ctx)
+	var actorIdList []int64
+	err := db.Model(&NotificationActor{}).Where("notification_id = ?", notificationId).Order("created_at desc, id desc").Limit(count).Pluck("actor_id", &actorIdList).Error
+	return actorIdList, err
+}
+
+// GetUnreadNotificationCount 获取用户的未读通知数
+func GetUnreadNotificationCount(ctx context.Context, userId int64) (int64, error) {
+	db := DB.WithContext(ctx)
+	var count int64
+	err := db.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userId, false).Count(&count).Error
+	return count, err
+}
+
+// ReadNotifications 将通知标记为已读，notificationId 为 0 时标记所有通知
+func ReadNotifications(ctx context.Context, userId, notificationId int64) error {
+	db := DB.WithContext(ctx)
+	query := db.Model(&Notification{}).Where("user_id = ? AND is_read = ?", userId, false)
+	if notificationId > 0 {
+		query = query.Where("id = ?", notificationId)
+	}
+	// 已读的通知不再参与合并，清空合并键，之后的新动作产生新的未读通知
+	return query.UpdateColumns(map[string]interface{}{"is_read": true, "merge_key": nil}).Error
+}
+
+// GetNotificationSetting 获取用户的通知开关，没有记录时全部开启
+func GetNotificationSetting(ctx context.Context, userId int64) (NotificationSetting, error) {
+	db := DB.WithContext(ctx)
+	var setting NotificationSetting
+	err := db.Where("user_id = ?", userId).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return NotificationSetting{UserId: userId, Like: true, Comment: true, Follow: true, Mention: true}, nil
+	}
+	return setting, err
+}
+
+// EditNotificationSetting 保存用户的通知开关
+func EditNotificationSetting(ctx context.Context, setting NotificationSetting) error {
+	db := DB.WithContext(ctx)
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&setting).Error
+}
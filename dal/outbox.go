@@ -0,0 +1,108 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 发件箱任务类型
+const (
+	OutboxFavorite = "favorite" // 点赞或取消点赞
+	OutboxFollow   = "follow"   // 关注或取消关注
+	OutboxComment  = "comment"  // 发表或删除评论
+)
+
+// Outbox 发件箱，与业务数据在同一事务中写入的缓存同步任务
+// 事务提交后由 relay 在 OutboxDelay 之后同步 Redis，失败时按指数退避重试，成功后删除
+// 任务只记录涉及的 id，同步时以 MySQL 中的最新数据为准，因此可以重复执行
+type Outbox struct {
+	Id        int64  `gorm:"primaryKey"`
+	Kind      string `gorm:"type:varchar(32);not null"`
+	Payload   string `gorm:"type:text;not null"`
+	Attempts  int    `gorm:"not null;default:0"`
+	NextRunAt int64  `gorm:"not null;index"` // 毫秒时间戳，到期后由 relay 执行
+	LastError string `gorm:"type:text"`
+	CreatedAt int64  `gorm:"autoCreateTime:milli"`
+}
+
+// FavoriteChange 用户对视频的点赞状态发生变化
+type FavoriteChange struct {
+	UserId  int64
+	VideoId int64
+}
+
+// FollowChange 用户 A 对用户 B 的关注状态发生变化
+type FollowChange struct {
+	UserAId int64
+	UserBId int64
+}
+
+// CommentChange 评论被发表或删除，删除一级评论时 ReplyIds 为一同删除的回复
+type CommentChange struct {
+	CommentId int64
+	VideoId   int64
+	RootId    int64
+	ReplyIds  []int64
+}
+
+// addOutbox 在事务中记录发件箱任务
+func addOutbox(tx *gorm.DB, kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&Outbox{
+		Kind:      kind,
+		Payload:   string(data),
+		NextRunAt: time.Now().Add(config.OutboxDelay).UnixMilli(),
+	}).Error
+}
+
+// GetDueOutbox 获取已到期的发件箱任务，最早的在前
+func GetDueOutbox(ctx context.Context, count int) ([]Outbox, error) {
+	db := DB.WithContext(ctx)
+	var outboxList []Outbox
+	err := db.Where("next_run_at <= ?", time.Now().UnixMilli()).Order("id asc").Limit(count).Find(&outboxList).Error
+	return outboxList, err
+}
+
+// ClaimOutbox 认领发件箱任务，在租约 OutboxLease 内其他实例不会重复执行
+// 返回是否认领成功，认领时增加执行次数
+func ClaimOutbox(ctx context.Context, outbox *Outbox) (bool, error) {
+	db := DB.WithContext(ctx)
+	leaseEnd := time.Now().Add(config.OutboxLease).UnixMilli()
+	result := db.Model(&Outbox{}).Where("id = ? AND next_run_at = ?", outbox.Id, outbox.NextRunAt).Updates(map[string]interface{}{
+		"next_run_at": leaseEnd,
+		"attempts":    gorm.Expr("attempts + ?", 1),
+	})
+	if result.Error != nil || result.RowsAffected <= 0 {
+		return false, result.Error
+	}
+	outbox.NextRunAt = leaseEnd
+	outbox.Attempts++
+	return true, nil
+}
+
+// RetryOutbox 记录执行失败的原因，按执行次数指数退避，最长间隔 OutboxRetryMax
+func RetryOutbox(ctx context.Context, outbox Outbox, cause error) error {
+	db := DB.WithContext(ctx)
+	backoff := config.OutboxRetryMax
+	if outbox.Attempts < 20 { // 避免移位溢出
+		if d := config.OutboxRetryBase << (outbox.Attempts - 1); d < backoff {
+			backoff = d
+		}
+	}
+	return db.Model(&Outbox{}).Where("id = ?", outbox.Id).Updates(map[string]interface{}{
+		"next_run_at": time.Now().Add(backoff).UnixMilli(),
+		"last_error":  cause.Error(),
+	}).Error
+}
+
+// DeleteOutbox 删除已完成的发件箱任务
+func DeleteOutbox(ctx context.Context, outboxId int64) error {
+	db := DB.WithContext(ctx)
+	return db.Delete(&Outbox{}, outboxId).Error
+}
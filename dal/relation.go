@@ -1,6 +1,7 @@
 package dal
 
 import (
+	"context"
 	"errors"
 	"gorm.io/gorm"
 )
@@ -12,9 +13,16 @@ type Relation struct {
 	UserBId int64 `gorm:"primaryKey;autoIncrement:false"`
 }
 
-func AddFollow(userAId, userBId int64) error {
+// relationRepository 基于 GORM 的 RelationRepository 实现
+type relationRepository struct {
+	db *gorm.DB
+}
+
+// AddFollow 关注操作，在同一事务中记录发件箱任务
+func (r *relationRepository) AddFollow(ctx context.Context, userAId, userBId int64) error {
+	db := r.db.WithContext(ctx)
 	// 添加记录前先查找是否存在
-	if DB.Where("user_a_id = ? AND user_b_id = ?", userAId, userBId).Find(&Relation{}).RowsAffected > 0 {
+	if db.Where("user_a_id = ? AND user_b_id = ?", userAId, userBId).Find(&Relation{}).RowsAffected > 0 {
 		return errors.New("已经关注过")
 	}
 	relation := Relation{
@@ -22,7 +30,7 @@ func AddFollow(userAId, userBId int64) error {
 		UserBId: userBId,
 	}
 	// 开启数据库事务，在 relations 中添加记录，在 users 中更改关注数
-	if err := DB.Transaction(func(tx *gorm.DB) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&relation).Error; err != nil {
 			return err
 		}
@@ -33,22 +41,24 @@ func AddFollow(userAId, userBId int64) error {
 		if err := tx.Model(&User{}).Where("id = ?", userBId).UpdateColumn("follower_count", gorm.Expr("follower_count + ?", 1)).Error; err != nil {
 			return err
 		}
-		return nil
+		return addOutbox(tx, OutboxFollow, FollowChange{UserAId: userAId, UserBId: userBId})
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
-func DeleteFollow(userAId, userBId int64) error {
+// DeleteFollow 取消关注操作，在同一事务中记录发件箱任务
+func (r *relationRepository) DeleteFollow(ctx context.Context, userAId, userBId int64) error {
+	db := r.db.WithContext(ctx)
 	// 删除记录前先查找是否存在
 	var relation Relation
-	if DB.Where("user_a_id = ? AND user_b_id", userAId, userBId).First(&relation).RowsAffected <= 0 {
+	if db.Where("user_a_id = ? AND user_b_id = ?", userAId, userBId).First(&relation).RowsAffected <= 0 {
 		return errors.New("没有关注记录")
 	}
 
 	// 开启数据库事务
-	if err := DB.Transaction(func(tx *gorm.DB) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
 		// 删除关注关系
 		if err := tx.Delete(&relation).Error; err != nil {
 			return err
@@ -59,17 +69,25 @@ func DeleteFollow(userAId, userBId int64) error {
 		if err := tx.Model(&User{}).Where("id = ?", userBId).UpdateColumn("follower_count", gorm.Expr("follower_count - ?", 1)).Error; err != nil {
 			return err
 		}
-		return nil
+		return addOutbox(tx, OutboxFollow, FollowChange{UserAId: userAId, UserBId: userBId})
 	}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// IsFollow 查询用户 A 是否关注了用户 B
+func (r *relationRepository) IsFollow(ctx context.Context, userAId, userBId int64) (bool, error) {
+	db := r.db.WithContext(ctx)
+	result := db.Where("user_a_id = ? AND user_b_id = ?", userAId, userBId).Limit(1).Find(&Relation{})
+	return result.RowsAffected > 0, result.Error
+}
+
 // GetFollowList 获取查询用户的所有关注的 id
-func GetFollowList(userId int64) ([]int64, error) {
+func (r *relationRepository) GetFollowList(ctx context.Context, userId int64) ([]int64, error) {
+	db := r.db.WithContext(ctx)
 	var followList []Relation
-	if err := DB.Where("user_a_id = ?", userId).Find(&followList).Error; err != nil {
+	if err := db.Where("user_a_id = ?", userId).Find(&followList).Error; err != nil {
 		return []int64{}, err
 	}
 	followIdList := make([]int64, len(followList))
@@ -80,9 +98,10 @@ func GetFollowList(userId int64) ([]int64, error) {
 }
 
 // GetFollowerList 获取查询用户的所有粉丝的 id
-func GetFollowerList(userId int64) ([]int64, error) {
+func (r *relationRepository) GetFollowerList(ctx context.Context, userId int64) ([]int64, error) {
+	db := r.db.WithContext(ctx)
 	var followList []Relation
-	if err := DB.Where("user_b_id = ?", userId).Find(&followList).Error; err != nil {
+	if err := db.Where("user_b_id = ?", userId).Find(&followList).Error; err != nil {
 		return []int64{}, err
 	}
 	followerIdList := make([]int64, len(followList))
@@ -0,0 +1,111 @@
+package dal
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// UserRepository 用户的数据访问接口
+type UserRepository interface {
+	Register(ctx context.Context, name, password string) (User, error)
+	Login(ctx context.Context, name, password string) (User, error)
+	GetById(ctx context.Context, id int64) (User, error)
+	GetAll(ctx context.Context) ([]User, error)
+	GetByNames(ctx context.Context, names []string) ([]User, error)
+}
+
+// VideoRepository 视频的数据访问接口，只负责数据库，视频文件由 media 包处理
+type VideoRepository interface {
+	Create(ctx context.Context, video Video) (Video, error)
+	SetUrls(ctx context.Context, videoId int64, playUrl, coverUrl string) error
+	GetById(ctx context.Context, videoId int64) (Video, error)
+	GetPublishList(ctx context.Context, userId int64) ([]Video, error)
+	GetFeed(ctx context.Context, latestTime int64, feedSize int) ([]Video, error)
+	GetPublished(ctx context.Context) ([]Video, error)
+	Edit(ctx context.Context, userId, videoId int64, title, mentionData, description string, visibility int32) error
+	Delete(ctx context.Context, userId, videoId int64) error
+	Restore(ctx context.Context, userId, videoId int64) (Video, error)
+	Purge(ctx context.Context) ([]Video, error)
+	PinComment(ctx context.Context, userId, videoId, commentId int64) error
+	SetCommentPermission(ctx context.Context, userId, videoId int64, permission int32) error
+	AddPlayCounts(ctx context.Context, batchId string, countMap map[int64]int64) error
+	Release(ctx context.Context, userId, videoId, releaseTime int64) (Video, error)
+	ReleaseScheduled(ctx context.Context) ([]Video, error)
+}
+
+// CommentRepository 评论的数据访问接口
+type CommentRepository interface {
+	Add(ctx context.Context, comment Comment) (Comment, error)
+	Delete(ctx context.Context, userId, videoId, commentId int64) error
+	Edit(ctx context.Context, userId, commentId int64, content, mentionData string) (Comment, error)
+	GetById(ctx context.Context, commentId int64) (Comment, error)
+	GetByVideoId(ctx context.Context, videoId int64) ([]Comment, error)
+	GetReplyByRootId(ctx context.Context, rootId int64) ([]Comment, error)
+}
+
+// FavoriteRepository 点赞的数据访问接口
+type FavoriteRepository interface {
+	Add(ctx context.Context, userId, videoId int64) (Favorite, error)
+	Delete(ctx context.Context, userId, videoId int64) error
+	Get(ctx context.Context, userId, videoId int64) (Favorite, error)
+	GetByUserId(ctx context.Context, userId int64) ([]Favorite, error)
+	GetByVideoId(ctx context.Context, videoId int64) ([]Favorite, error)
+}
+
+// RelationRepository 关注关系的数据访问接口
+type RelationRepository interface {
+	AddFollow(ctx context.Context, userAId, userBId int64) error
+	DeleteFollow(ctx context.Context, userAId, userBId int64) error
+	IsFollow(ctx context.Context, userAId, userBId int64) (bool, error)
+	GetFollowList(ctx context.Context, userId int64) ([]int64, error)
+	GetFollowerList(ctx context.Context, userId int64) ([]int64, error)
+}
+
+// Repositories 各数据访问接口的实现
+// 拉黑、提及、通知、话题、评论点赞、outbox、收藏夹、私信和 webhook 等其余表不在此范围内，仍是直接使用 DB 的包级函数
+type Repositories struct {
+	Users     UserRepository
+	Videos    VideoRepository
+	Comments  CommentRepository
+	Favorites FavoriteRepository
+	Relations RelationRepository
+}
+
+// 当前使用的数据访问实现，由 ConnectDB 设置，测试时可以通过 Use 替换
+var (
+	Users     UserRepository
+	Videos    VideoRepository
+	Comments  CommentRepository
+	Favorites FavoriteRepository
+	Relations RelationRepository
+)
+
+// NewRepositories 创建基于 GORM 的数据访问实现
+func NewRepositories(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:     &userRepository{db: db},
+		Videos:    &videoRepository{db: db},
+		Comments:  &commentRepository{db: db},
+		Favorites: &favoriteRepository{db: db},
+		Relations: &relationRepository{db: db},
+	}
+}
+
+// Use 设置数据访问实现，为 nil 的字段保持不变
+func Use(repos Repositories) {
+	if repos.Users != nil {
+		Users = repos.Users
+	}
+	if repos.Videos != nil {
+		Videos = repos.Videos
+	}
+	if repos.Comments != nil {
+		Comments = repos.Comments
+	}
+	if repos.Favorites != nil {
+		Favorites = repos.Favorites
+	}
+	if repos.Relations != nil {
+		Relations = repos.Relations
+	}
+}
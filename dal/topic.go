@@ -0,0 +1,190 @@
+package dal
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Topic 话题，从视频标题中的 #话题 提取
+type Topic struct {
+	Id         int64  `json:"id" gorm:"primaryKey"`
+	Name       string `json:"name" gorm:"type:varchar(128);not null;uniqueIndex"`
+	VideoCount int64  `json:"video_count" gorm:"not null;default:0"` // 已发布且未删除的视频数
+	Heat       int64  `json:"heat,omitempty" gorm:"->;-:migration"`  // 热门话题统计窗口内的新增视频数，只在查询热门话题时读取
+	CreatedAt  int64  `json:"-" gorm:"autoCreateTime:milli"`
+}
+
+// VideoTopic 视频和话题的关联
+type VideoTopic struct {
+	Id      int64 `gorm:"primaryKey"`
+	VideoId int64 `gorm:"not null;uniqueIndex:idx_video_topic"`
+	TopicId int64 `gorm:"not null;uniqueIndex:idx_video_topic;index"`
+}
+
+// 话题视频排序方式
+const (
+	TopicSortNewest = "newest" // 最新发布在前
+	TopicSortHot    = "hot"    // 点赞数多的在前
+)
+
+// SetVideoTopics 将视频关联的话题设置为 names，不存在的话题自动创建
+// 返回新增关联和移除关联的话题 id，方便更新缓存
+func SetVideoTopics(ctx context.Context, videoId int64, names []string) ([]int64, []int64, error) {
+	db := DB.WithContext(ctx)
+	var addedIdList, removedIdList []int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var topicList []Topic
+		if len(names) > 0 {
+			// 话题名唯一，已存在的话题忽略
+			newList := make([]Topic, len(names))
+			for i, name := range names {
+				newList[i] = Topic{Name: name}
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newList).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("name IN ?", names).Find(&topicList).Error; err != nil {
+				return err
+			}
+		}
+		var oldIdList []int64
+		if err := tx.Model(&VideoTopic{}).Where("video_id = ?", videoId).Pluck("topic_id", &oldIdList).Error; err != nil {
+			return err
+		}
+		oldIdMap := make(map[int64]bool, len(oldIdList))
+		for _, topicId := range oldIdList {
+			oldIdMap[topicId] = true
+		}
+		newIdMap := make(map[int64]bool, len(topicList))
+		for _, topic := range topicList {
+			newIdMap[topic.Id] = true
+			if !oldIdMap[topic.Id] {
+				addedIdList = append(addedIdList, topic.Id)
+			}
+		}
+		for _, topicId := range oldIdList {
+			if !newIdMap[topicId] {
+				removedIdList = append(removedIdList, topicId)
+			}
+		}
+		if len(removedIdList) > 0 {
+			if err := tx.Where("video_id = ? AND topic_id IN ?", videoId, removedIdList).Delete(&VideoTopic{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, topicId := range addedIdList {
+			if err := tx.Create(&VideoTopic{VideoId: videoId, TopicId: topicId}).Error; err != nil {
+				return err
+			}
+		}
+		return refreshTopicCounts(tx, append(append([]int64{}, addedIdList...), removedIdList...))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return addedIdList, removedIdList, nil
+}
+
+// GetTopicIdsByVideoId 获取视频关联的所有话题 id
+func GetTopicIdsByVideoId(ctx context.Context, videoId int64) ([]int64, error) {
+	db := DB.WithContext(ctx)
+	var topicIdList []int64
+	err := db.Model(&VideoTopic{}).Where("video_id = ?", videoId).Pluck("topic_id", &topicIdList).Error
+	return topicIdList, err
+}
+
+// refreshTopicCounts 根据已发布且未删除的视频重新统计话题的视频数
+func refreshTopicCounts(tx *gorm.DB, topicIdList []int64) error {
+	if len(topicIdList) == 0 {
+		return nil
+	}
+	countQuery := tx.Table("video_topics").Select("COUNT(*)").
+		Joins("JOIN videos ON videos.id = video_topics.video_id").
+		Where("video_topics.topic_id = topics.id AND videos.status = ? AND videos.deleted_at IS NULL", StatusPublished)
+	return tx.Model(&Topic{}).Where("id IN ?", topicIdList).UpdateColumn("video_count", countQuery).Error
+}
+
+// refreshVideoTopicCounts 视频发布、删除或恢复后，重新统计其关联话题的视频数
+func refreshVideoTopicCounts(db *gorm.DB, videoId int64) error {
+	var topicIdList []int64
+	if err := db.Model(&VideoTopic{}).Where("video_id = ?", videoId).Pluck("topic_id", &topicIdList).Error; err != nil {
+		return err
+	}
+	return refreshTopicCounts(db, topicIdList)
+}
+
+func GetTopicByName(ctx context.Context, name string) (Topic, error) {
+	db := DB.WithContext(ctx)
+	var topic Topic
+	err := db.Where("name = ?", name).First(&topic).Error
+	return topic, err
+}
+
+// GetTopicsByNames 根据话题名批量获取话题，不存在的话题名会被忽略
+func GetTopicsByNames(ctx context.Context, names []string) ([]Topic, error) {
+	db := DB.WithContext(ctx)
+	var topicList []Topic
+	if len(names) == 0 {
+		return topicList, nil
+	}
+	err := db.Where("name IN ?", names).Find(&topicList).Error
+	return topicList, err
+}
+
+// GetAllTopics 获取所有话题，用于重建搜索索引
+func GetAllTopics(ctx context.Context) ([]Topic, error) {
+	db := DB.WithContext(ctx)
+	var topicList []Topic
+	err := db.Find(&topicList).Error
+	return topicList, err
+}
+
+// GetTopicsByIds 根据 id 批量获取话题，返回顺序与 topicIdList 一致，不存在的话题跳过
+func GetTopicsByIds(ctx context.Context, topicIdList []int64) ([]Topic, error) {
+	db := DB.WithContext(ctx)
+	var topicList []Topic
+	if len(topicIdList) == 0 {
+		return topicList, nil
+	}
+	if err := db.Where("id IN ?", topicIdList).Find(&topicList).Error; err != nil {
+		return []Topic{}, err
+	}
+	topicMap := make(map[int64]Topic, len(topicList))
+	for _, topic := range topicList {
+		topicMap[topic.Id] = topic
+	}
+	orderedList := make([]Topic, 0, len(topicList))
+	for _, topicId := range topicIdList {
+		if topic, ok := topicMap[topicId]; ok {
+			orderedList = append(orderedList, topic)
+		}
+	}
+	return orderedList, nil
+}
+
+// GetTopicVideos 获取话题下已发布的视频，按 sortType 排序，最多 limit 个
+func GetTopicVideos(ctx context.Context, topicId int64, sortType string, limit int) ([]Video, error) {
+	db := DB.WithContext(ctx)
+	var videoList []Video
+	order := "videos.create_time desc, videos.id desc"
+	if sortType == TopicSortHot {
+		order = "videos.favorite_count desc, videos.id desc"
+	}
+	err := db.Joins("JOIN video_topics ON video_topics.video_id = videos.id").
+		Where("video_topics.topic_id = ? AND videos.status = ?", topicId, StatusPublished).
+		Order(order).Limit(limit).Find(&videoList).Error
+	return videoList, err
+}
+
+// GetTrendingTopics 获取 since 之后新增视频最多的前 limit 个话题，新增视频数记录在 Heat 中
+func GetTrendingTopics(ctx context.Context, since int64, limit int) ([]Topic, error) {
+	db := DB.WithContext(ctx)
+	var topicList []Topic
+	err := db.Model(&Topic{}).Select("topics.*, COUNT(*) AS heat").
+		Joins("JOIN video_topics ON video_topics.topic_id = topics.id").
+		Joins("JOIN videos ON videos.id = video_topics.video_id").
+		Where("videos.status = ? AND videos.deleted_at IS NULL AND videos.create_time >= ?", StatusPublished, since).
+		Group("topics.id").Order("heat desc, topics.id desc").Limit(limit).Find(&topicList).Error
+	return topicList, err
+}
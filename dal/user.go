@@ -1,14 +1,16 @@
 package dal
 
 import (
+	"context"
 	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type User struct {
 	Id            int64  `json:"id" gorm:"primaryKey"`
 	Name          string `json:"name" gorm:"unique; not null"`
-	Password      string `gorm:"not null" redistructhash:"no"`
+	Password      string `json:"-" gorm:"not null" redistructhash:"no"`
 	FollowCount   int64  `json:"follow_count"`
 	FollowerCount int64  `json:"follower_count"`
 	IsFollow      bool   `json:"is_follow" gorm:"-:all"` // IsFollow 是根据 relations 表查询得到的，不需要存储
@@ -20,9 +22,15 @@ func bCryptPassword(password string) (string, error) {
 	return string(bytes), err
 }
 
-func Register(name, password string) (user User, err error) {
+// userRepository 基于 GORM 的 UserRepository 实现
+type userRepository struct {
+	db *gorm.DB
+}
+
+func (r *userRepository) Register(ctx context.Context, name, password string) (user User, err error) {
+	db := r.db.WithContext(ctx)
 	// 根据用户名的唯一性，查找是否存在该用户，如果不存在则将用户信息存入数据库中
-	if DB.Where("name = ?", name).Find(&User{}).RowsAffected > 0 {
+	if db.Where("name = ?", name).Find(&User{}).RowsAffected > 0 {
 		return User{}, errors.New("用户名已存在")
 	} else {
 		passwordHash, _ := bCryptPassword(password) // 将密码加密
@@ -30,15 +38,16 @@ func Register(name, password string) (user User, err error) {
 			Name:     name,
 			Password: passwordHash,
 		}
-		DB.Create(&newUser) // 存入数据库
+		db.Create(&newUser) // 存入数据库
 		return newUser, nil
 	}
 }
 
-func Login(name, password string) (User, error) {
+func (r *userRepository) Login(ctx context.Context, name, password string) (User, error) {
+	db := r.db.WithContext(ctx)
 	// 查找数据库中对应的用户名，并检查密码
 	var user User
-	if DB.Where("name = ?", name).First(&user).RowsAffected > 0 {
+	if db.Where("name = ?", name).First(&user).RowsAffected > 0 {
 		passwordHashByte := []byte(user.Password)
 		passwordByte := []byte(password)
 		// 检查密码是否正确，使用 BCrypt 内置的比较函数
@@ -52,8 +61,28 @@ func Login(name, password string) (User, error) {
 	}
 }
 
-func GetUserById(id int64) (User, error) {
+func (r *userRepository) GetById(ctx context.Context, id int64) (User, error) {
+	db := r.db.WithContext(ctx)
 	var user User
-	err := DB.Find(&user, id).Error
+	err := db.Find(&user, id).Error
 	return user, err
 }
+
+// GetAll 获取所有用户，用于重建搜索索引
+func (r *userRepository) GetAll(ctx context.Context) ([]User, error) {
+	db := r.db.WithContext(ctx)
+	var userList []User
+	err := db.Find(&userList).Error
+	return userList, err
+}
+
+// GetByNames 根据用户名批量查找用户，不存在的用户名会被忽略
+func (r *userRepository) GetByNames(ctx context.Context, names []string) ([]User, error) {
+	db := r.db.WithContext(ctx)
+	var userList []User
+	if len(names) == 0 {
+		return userList, nil
+	}
+	err := db.Where("name IN ?", names).Find(&userList).Error
+	return userList, err
+}
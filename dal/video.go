@@ -1,12 +1,11 @@
 package dal
 
 import (
-	"fmt"
-	"github.com/gin-gonic/gin"
+	"context"
+	"errors"
 	"github.com/zenpk/mini-douyin-ex/config"
-	"mime/multipart"
-	"os/exec"
-	"path/filepath"
+	"gorm.io/gorm"
+	"time"
 )
 
 type Video struct {
@@ -17,65 +16,340 @@ type Video struct {
 	CoverUrl      string `json:"cover_url,omitempty" gorm:"not null"`
 	FavoriteCount int64  `json:"favorite_count,omitempty"`
 	CommentCount  int64  `json:"comment_count,omitempty"`
+	PlayCount     int64  `json:"play_count,omitempty"`
 	IsFavorite    bool   `json:"is_favorite,omitempty" gorm:"-:all"` // IsFavorite 是根据 favorites 表查询得到的，不需要存储
 	Title         string `json:"title,omitempty"`
-	CreateTime    int64  `gorm:"not null"`
-}
-
-// Publish - 自动生成视频名称、路径，上传视频，生成封面，最后返回视频方便加入缓存
-func Publish(c *gin.Context, userId int64, title string, filename string, data *multipart.FileHeader) (Video, error) {
-	playUrl := config.ServerAddr + "/static/videos/"
-	coverUrl := config.ServerAddr + "/static/covers/"
-	// 因为存储的文件名需要包含 videoId，所以先保存到数据库，利用 Id 自增特性获取 videoId
-	// 相关信息存入数据库
-	video := Video{
-		UserId:   userId,
-		PlayUrl:  playUrl,
-		CoverUrl: coverUrl,
-		Title:    title,
-	}
-	DB.Create(&video)
-	finalName := fmt.Sprintf("%d_%d_%s", userId, video.Id, filename) // 保存的文件名，为防止文件名冲突，增加一项 videoId
-	saveFile := filepath.Join("./public/videos/", finalName)
-	// 将视频存入本地
-	if err := c.SaveUploadedFile(data, saveFile); err != nil {
-		return Video{}, err
+	Description   string `json:"description,omitempty"`
+	Visibility    int32  `json:"visibility" gorm:"not null;default:0"`   // 可见范围，取值见 VisibilityPublic 等常量
+	Status        int32  `json:"status" gorm:"not null;default:0;index"` // 发布状态，取值见 StatusPublished 等常量
+	ReleaseTime   int64  `json:"release_time,omitempty"`                 // 定时发布的时间
+	CreateTime    int64  `gorm:"not null;index"`                         // 发布时间，视频流按此排序
+	// 以下为作者对评论区的管理设置
+	PinnedCommentId   int64 `json:"pinned_comment_id,omitempty" gorm:"not null;default:0"` // 置顶评论，只能置顶一级评论
+	CommentPermission int32 `json:"comment_permission" gorm:"not null;default:0"`          // 评论权限，取值见 CommentEveryone 等常量
+	// 标题中 @ 提及的位置，以 JSON 字符串存储，读取时解析到 Mentions 中
+	MentionData string        `json:"-" gorm:"type:text"`
+	Mentions    []MentionSpan `json:"mentions,omitempty" gorm:"-:all" redistructhash:"no"`
+	// 软删除，删除后在恢复期限内仍可恢复，不存入 Redis
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index" redistructhash:"no"`
+}
+
+// FormatDisplay 根据 MentionData 生成前端展示用的字段
+func (video *Video) FormatDisplay() {
+	video.Mentions = DecodeMentions(video.MentionData)
+}
+
+// AfterFind 从 MySQL 读取后自动生成展示用的字段
+func (video *Video) AfterFind(*gorm.DB) error {
+	video.FormatDisplay()
+	return nil
+}
+
+// PlayCountBatch 已写入 MySQL 的播放量批次，与播放量在同一事务中写入
+// 同一批次重复写入时直接跳过，保证批量写入播放量是幂等的
+type PlayCountBatch struct {
+	Id        string `gorm:"type:varchar(32);primaryKey"`
+	CreatedAt int64  `gorm:"autoCreateTime:milli;index"`
+}
+
+// 视频可见范围
+const (
+	VisibilityPublic   = 0 // 所有人可见
+	VisibilityFollower = 1 // 仅粉丝可见
+	VisibilityFriend   = 2 // 仅互相关注的好友可见
+	VisibilityPrivate  = 3 // 仅自己可见
+)
+
+// ValidVisibility 检查可见范围取值是否合法
+func ValidVisibility(visibility int32) bool {
+	return visibility >= VisibilityPublic && visibility <= VisibilityPrivate
+}
+
+// 视频评论权限
+const (
+	CommentEveryone = 0 // 所有人可评论
+	CommentFollower = 1 // 仅粉丝可评论
+	CommentNobody   = 2 // 关闭评论
+)
+
+// 视频发布状态
+const (
+	StatusPublished = 0 // 已发布
+	StatusDraft     = 1 // 草稿
+	StatusScheduled = 2 // 等待定时发布
+)
+
+// videoRepository 基于 GORM 的 VideoRepository 实现
+type videoRepository struct {
+	db *gorm.DB
+}
+
+// Create 保存新投稿的视频，并返回视频（video 的 id 会被更新）
+// video 中需要预先填好作者、标题等信息，视频和封面链接在文件保存后通过 SetUrls 更新
+// 草稿和定时发布的视频不会进入视频流
+func (r *videoRepository) Create(ctx context.Context, video Video) (Video, error) {
+	db := r.db.WithContext(ctx)
+	// 立即发布的视频以当前时间作为投稿时间，定时发布的视频在发布时更新
+	if video.Status == StatusPublished {
+		video.CreateTime = time.Now().Unix()
 	}
-	// 调用 ffmpeg 获取封面（第一帧老是黑屏，所以这里获取第 300 帧）
-	// 当然更好的实践是先读取总共有多少帧，再获取中间的某一帧，这里为了简便实现就先这样了
-	cmd := exec.Command(
-		"ffmpeg", "-i", "./public/videos/"+finalName,
-		"-vf", "select=eq(n\\, 300)", "-frames", "1",
-		"./public/covers/"+finalName+".jpg",
-	)
-	//cmd.Stderr = os.Stderr // 输出错误信息
-	if err := cmd.Run(); err != nil {
+	if err := db.Create(&video).Error; err != nil {
 		return Video{}, err
 	}
-	// 更新数据库中的视频和封面链接
-	video.PlayUrl += finalName
-	video.CoverUrl += finalName + ".jpg"
-	DB.Save(&video)
 	return video, nil
 }
 
-func GetPublishList(userId int64) ([]Video, error) {
+// SetUrls 更新视频和封面链接
+func (r *videoRepository) SetUrls(ctx context.Context, videoId int64, playUrl, coverUrl string) error {
+	db := r.db.WithContext(ctx)
+	return db.Model(&Video{}).Where("id = ?", videoId).Updates(map[string]interface{}{
+		"play_url":  playUrl,
+		"cover_url": coverUrl,
+	}).Error
+}
+
+func (r *videoRepository) GetPublishList(ctx context.Context, userId int64) ([]Video, error) {
+	db := r.db.WithContext(ctx)
 	var videoList []Video
-	err := DB.Where("user_id = ?", userId).Find(&videoList).Error
+	err := db.Where("user_id = ?", userId).Find(&videoList).Error
 	return videoList, err
 }
 
-// GetFeed 获取时间倒序前 feedSize 个视频
-func GetFeed(latestTime int64, feedSize int) ([]Video, error) {
+// GetFeed 按发布时间倒序获取前 feedSize 个已发布的视频，发布时间相同时按 id 倒序
+// 草稿和定时发布的视频在发布时才设置 create_time，因此不能按 id 排序
+func (r *videoRepository) GetFeed(ctx context.Context, latestTime int64, feedSize int) ([]Video, error) {
+	db := r.db.WithContext(ctx)
 	var videoList []Video
-	if err := DB.Order("id desc").Limit(feedSize).Where("create_time <= ?", latestTime).Find(&videoList).Error; err != nil {
+	if err := db.Order("create_time desc, id desc").Limit(feedSize).Where("create_time <= ? AND status = ?", latestTime, StatusPublished).Find(&videoList).Error; err != nil {
 		return []Video{}, err
 	}
 	return videoList, nil
 }
 
-func GetVideoById(videoId int64) (Video, error) {
+// GetPublished 获取所有已发布的视频，用于重建搜索索引
+func (r *videoRepository) GetPublished(ctx context.Context) ([]Video, error) {
+	db := r.db.WithContext(ctx)
+	var videoList []Video
+	err := db.Where("status = ?", StatusPublished).Find(&videoList).Error
+	return videoList, err
+}
+
+func (r *videoRepository) GetById(ctx context.Context, videoId int64) (Video, error) {
+	db := r.db.WithContext(ctx)
 	var video Video
-	err := DB.First(&video, videoId).Error
+	err := db.First(&video, videoId).Error
 	return video, err
 }
+
+// Edit 修改视频标题、简介和可见范围，只有作者本人可以修改，mentionData 为新标题中的 @ 提及位置
+func (r *videoRepository) Edit(ctx context.Context, userId, videoId int64, title, mentionData, description string, visibility int32) error {
+	db := r.db.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", videoId, userId).Find(&Video{}).RowsAffected <= 0 {
+		return errors.New("无法修改视频")
+	}
+	return db.Model(&Video{}).Where("id = ?", videoId).Updates(map[string]interface{}{
+		"title":        title,
+		"mention_data": mentionData,
+		"description":  description,
+		"visibility":   visibility,
+	}).Error
+}
+
+// Delete 软删除视频，只有作者本人可以删除
+// 点赞、评论记录暂时保留，以便在恢复期限内恢复，超出期限后由 PurgeVideos 彻底删除
+func (r *videoRepository) Delete(ctx context.Context, userId, videoId int64) error {
+	db := r.db.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", videoId, userId).Find(&Video{}).RowsAffected <= 0 {
+		return errors.New("无法删除视频")
+	}
+	if err := db.Delete(&Video{}, videoId).Error; err != nil {
+		return err
+	}
+	return refreshVideoTopicCounts(db, videoId)
+}
+
+// Restore 在恢复期限内恢复被删除的视频，并返回视频方便重新加入缓存
+func (r *videoRepository) Restore(ctx context.Context, userId, videoId int64) (Video, error) {
+	db := r.db.WithContext(ctx)
+	var video Video
+	if db.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", videoId, userId).First(&video).RowsAffected <= 0 {
+		return Video{}, errors.New("不存在已删除的视频")
+	}
+	if time.Since(video.DeletedAt.Time) > config.VideoRestoreExp {
+		return Video{}, errors.New("已超出恢复期限")
+	}
+	if err := db.Unscoped().Model(&video).Update("deleted_at", nil).Error; err != nil {
+		return Video{}, err
+	}
+	video.DeletedAt = gorm.DeletedAt{}
+	if err := refreshVideoTopicCounts(db, videoId); err != nil {
+		return Video{}, err
+	}
+	return video, nil
+}
+
+// Purge 彻底删除超出恢复期限的视频，包括点赞、评论、话题关联、收藏记录、提及和通知
+// 返回已删除的视频，出错时也会返回出错前已删除的视频，以便调用方删除本地文件
+func (r *videoRepository) Purge(ctx context.Context) ([]Video, error) {
+	db := r.db.WithContext(ctx)
+	var videoList []Video
+	deadline := time.Now().Add(-config.VideoRestoreExp)
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", deadline).Find(&videoList).Error; err != nil {
+		return []Video{}, err
+	}
+	purgedList := make([]Video, 0, len(videoList))
+	for _, video := range videoList {
+		// 开启数据库事务，删除视频及其点赞、评论、评论点赞、评论历史版本、话题关联、收藏记录、提及和通知
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("video_id = ?", video.Id).Delete(&Favorite{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("comment_id IN (?)", tx.Model(&Comment{}).Select("id").Where("video_id = ?", video.Id)).Delete(&CommentLike{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("comment_id IN (?)", tx.Model(&Comment{}).Select("id").Where("video_id = ?", video.Id)).Delete(&CommentRevision{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("video_id = ?", video.Id).Delete(&Comment{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("video_id = ?", video.Id).Delete(&VideoTopic{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Collection{}).Where("id IN (?)", tx.Model(&CollectionItem{}).Select("collection_id").Where("video_id = ?", video.Id)).
+				UpdateColumn("video_count", gorm.Expr("video_count - ?", 1)).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("video_id = ?", video.Id).Delete(&CollectionItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("video_id = ?", video.Id).Delete(&Mention{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("notification_id IN (?)", tx.Model(&Notification{}).Select("id").Where("video_id = ?", video.Id)).Delete(&NotificationActor{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("video_id = ?", video.Id).Delete(&Notification{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&video).Error; err != nil {
+				return err
+			}
+			return nil
+		}); err != nil {
+			return purgedList, err
+		}
+		purgedList = append(purgedList, video)
+	}
+	return purgedList, nil
+}
+
+// PinComment 作者置顶视频的一级评论，commentId 为 0 时取消置顶
+func (r *videoRepository) PinComment(ctx context.Context, userId, videoId, commentId int64) error {
+	db := r.db.WithContext(ctx)
+	if db.Where("id = ? AND user_id = ?", videoId, userId).Find(&Video{}).RowsAffected <= 0 {
+		return errors.New("无法管理该视频的评论")
+	}
+	if commentId != 0 && db.Where("id = ? AND video_id = ? AND root_id = 0", commentId, videoId).Find(&Comment{}).RowsAffected <= 0 {
+		return errors.New("不存在该评论")
+	}
+	return db.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("pinned_comment_id", commentId).Error
+}
+
+// SetCommentPermission 作者设置视频的评论权限
+func (r *videoRepository) SetCommentPermission(ctx context.Context, userId, videoId int64, permission int32) error {
+	db := r.db.WithContext(ctx)
+	if permission < CommentEveryone || permission > CommentNobody {
+		return errors.New("不支持的评论权限")
+	}
+	if db.Where("id = ? AND user_id = ?", videoId, userId).Find(&Video{}).RowsAffected <= 0 {
+		return errors.New("无法管理该视频的评论")
+	}
+	return db.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("comment_permission", permission).Error
+}
+
+// AddPlayCounts 将 Redis 中累计的播放量批量写入 MySQL，countMap 为视频 id 到新增播放量的映射
+// batchId 已写入过时不做任何操作，同时清理超过 PlayBatchKeep 的批次记录
+func (r *videoRepository) AddPlayCounts(ctx context.Context, batchId string, countMap map[int64]int64) error {
+	db := r.db.WithContext(ctx)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if tx.Where("id = ?", batchId).Find(&PlayCountBatch{}).RowsAffected > 0 {
+			return nil
+		}
+		// 并发写入同一批次时，后提交的事务因主键冲突回滚
+		if err := tx.Create(&PlayCountBatch{Id: batchId}).Error; err != nil {
+			return err
+		}
+		for videoId, count := range countMap {
+			if err := tx.Model(&Video{}).Where("id = ?", videoId).UpdateColumn("play_count", gorm.Expr("play_count + ?", count)).Error; err != nil {
+				return err
+			}
+		}
+		expired := time.Now().Add(-config.PlayBatchKeep).UnixMilli()
+		return tx.Where("created_at < ?", expired).Delete(&PlayCountBatch{}).Error
+	})
+}
+
+// Release 发布草稿或修改定时发布时间，releaseTime 不晚于当前时间则立即发布
+func (r *videoRepository) Release(ctx context.Context, userId, videoId, releaseTime int64) (Video, error) {
+	db := r.db.WithContext(ctx)
+	var video Video
+	if db.Where("id = ? AND user_id = ? AND status <> ?", videoId, userId, StatusPublished).First(&video).RowsAffected <= 0 {
+		return Video{}, errors.New("不存在未发布的视频")
+	}
+	now := time.Now().Unix()
+	if releaseTime <= now {
+		video.Status = StatusPublished
+		video.ReleaseTime = 0
+		video.CreateTime = now
+	} else {
+		video.Status = StatusScheduled
+		video.ReleaseTime = releaseTime
+	}
+	if err := db.Model(&video).Updates(map[string]interface{}{
+		"status":       video.Status,
+		"release_time": video.ReleaseTime,
+		"create_time":  video.CreateTime,
+	}).Error; err != nil {
+		return Video{}, err
+	}
+	if video.Status == StatusPublished {
+		if err := refreshVideoTopicCounts(db, videoId); err != nil {
+			return Video{}, err
+		}
+	}
+	return video, nil
+}
+
+// ReleaseScheduled 发布所有已到发布时间的定时视频，并返回这些视频方便加入缓存
+func (r *videoRepository) ReleaseScheduled(ctx context.Context) ([]Video, error) {
+	db := r.db.WithContext(ctx)
+	var videoList []Video
+	now := time.Now().Unix()
+	if err := db.Where("status = ? AND release_time <= ?", StatusScheduled, now).Find(&videoList).Error; err != nil {
+		return []Video{}, err
+	}
+	releasedList := make([]Video, 0, len(videoList))
+	for _, video := range videoList {
+		// 以计划的发布时间作为投稿时间，保证视频流顺序
+		video.Status = StatusPublished
+		video.CreateTime = video.ReleaseTime
+		video.ReleaseTime = 0
+		// 带上 status 条件，避免与作者同时修改发布时间冲突
+		result := db.Model(&Video{}).Where("id = ? AND status = ?", video.Id, StatusScheduled).Updates(map[string]interface{}{
+			"status":       video.Status,
+			"release_time": video.ReleaseTime,
+			"create_time":  video.CreateTime,
+		})
+		if result.Error != nil {
+			return []Video{}, result.Error
+		}
+		if result.RowsAffected > 0 {
+			if err := refreshVideoTopicCounts(db, video.Id); err != nil {
+				return []Video{}, err
+			}
+			releasedList = append(releasedList, video)
+		}
+	}
+	return releasedList, nil
+}
@@ -0,0 +1,239 @@
+package dal
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// 回调投递状态
+const (
+	DeliveryPending = "pending" // 等待发送或等待重试
+	DeliverySuccess = "success" // 接收方返回 2xx
+	DeliveryFailed  = "failed"  // 达到最多尝试次数仍失败
+)
+
+// Webhook 第三方订阅的回调地址，EventTypes 为逗号分隔的事件类型
+// 连续失败 WebhookMaxFailures 次后自动停用，重新启用时清零
+type Webhook struct {
+	Id           int64  `json:"id" gorm:"primaryKey"`
+	Url          string `json:"url" gorm:"type:varchar(512);not null"`
+	EventTypes   string `json:"event_types" gorm:"type:varchar(255);not null"`
+	Secret       string `json:"-" gorm:"type:varchar(64);not null"` // 只在创建时返回
+	Enabled      bool   `json:"enabled" gorm:"not null;default:true"`
+	FailureCount int    `json:"failure_count" gorm:"not null;default:0"` // 连续失败的尝试次数，成功一次即清零
+	CreatedAt    int64  `json:"created_at" gorm:"autoCreateTime:milli"`
+	UpdatedAt    int64  `json:"updated_at" gorm:"autoUpdateTime:milli"`
+}
+
+// Subscribed 判断是否订阅了该事件
+func (webhook Webhook) Subscribed(eventType string) bool {
+	for _, t := range strings.Split(webhook.EventTypes, ",") {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery 一次事件到一个回调地址的投递，同时作为投递日志
+// 重试时复用同一条记录，记录最后一次尝试的结果
+type WebhookDelivery struct {
+	Id           int64  `json:"id" gorm:"primaryKey"`
+	WebhookId    int64  `json:"webhook_id" gorm:"not null;index"`
+	EventType    string `json:"event_type" gorm:"type:varchar(32);not null"`
+	Payload      string `json:"payload" gorm:"type:text;not null"`
+	Status       string `json:"status" gorm:"type:varchar(16);not null;index:idx_status_next,priority:1"`
+	Attempts     int    `json:"attempts" gorm:"not null;default:0"`
+	NextRunAt    int64  `json:"next_run_at" gorm:"not null;index:idx_status_next,priority:2"` // 毫秒时间戳，只对 pending 有意义
+	ResponseCode int    `json:"response_code" gorm:"not null;default:0"`                      // 请求失败时为 0
+	ResponseBody string `json:"response_body" gorm:"type:text"`
+	LastError    string `json:"last_error" gorm:"type:text"`
+	Duration     int64  `json:"duration" gorm:"not null;default:0"` // 最后一次请求的耗时，毫秒
+	CreatedAt    int64  `json:"created_at" gorm:"autoCreateTime:milli"`
+	UpdatedAt    int64  `json:"updated_at" gorm:"autoUpdateTime:milli"`
+}
+
+// AddWebhook 创建回调订阅
+func AddWebhook(ctx context.Context, webhook Webhook) (Webhook, error) {
+	db := DB.WithContext(ctx)
+	webhook.Enabled = true
+	err := db.Create(&webhook).Error
+	return webhook, err
+}
+
+// EditWebhook 修改回调地址、事件类型和启用状态，重新启用时清零连续失败次数
+func EditWebhook(ctx context.Context, webhook Webhook) error {
+	db := DB.WithContext(ctx)
+	updates := map[string]interface{}{
+		"url":         webhook.Url,
+		"event_types": webhook.EventTypes,
+		"enabled":     webhook.Enabled,
+	}
+	if webhook.Enabled {
+		updates["failure_count"] = 0
+	}
+	return db.Model(&Webhook{}).Where("id = ?", webhook.Id).Updates(updates).Error
+}
+
+// DeleteWebhook 删除回调订阅及其投递日志
+func DeleteWebhook(ctx context.Context, webhookId int64) error {
+	db := DB.WithContext(ctx)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", webhookId).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Webhook{}, webhookId).Error
+	})
+}
+
+// GetWebhookById 通过 id 获取回调订阅
+func GetWebhookById(ctx context.Context, webhookId int64) (Webhook, error) {
+	db := DB.WithContext(ctx)
+	var webhook Webhook
+	err := db.Where("id = ?", webhookId).Take(&webhook).Error
+	return webhook, err
+}
+
+// GetWebhookList 获取所有回调订阅
+func GetWebhookList(ctx context.Context) ([]Webhook, error) {
+	db := DB.WithContext(ctx)
+	var webhookList []Webhook
+	err := db.Order("id asc").Find(&webhookList).Error
+	return webhookList, err
+}
+
+// AddWebhookDeliveries 为订阅了该事件且已启用的回调各创建一条待发送的投递
+// 订阅数量很少，直接在内存中筛选事件类型
+func AddWebhookDeliveries(ctx context.Context, eventType string, payload []byte) error {
+	db := DB.WithContext(ctx)
+	var webhookList []Webhook
+	if err := db.Where("enabled = ?", true).Find(&webhookList).Error; err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	var deliveryList []WebhookDelivery
+	for _, webhook := range webhookList {
+		if !webhook.Subscribed(eventType) {
+			continue
+		}
+		deliveryList = append(deliveryList, WebhookDelivery{
+			WebhookId: webhook.Id,
+			EventType: eventType,
+			Payload:   string(payload),
+			Status:    DeliveryPending,
+			NextRunAt: now,
+		})
+	}
+	if len(deliveryList) == 0 {
+		return nil
+	}
+	return db.Create(&deliveryList).Error
+}
+
+// GetDueWebhookDeliveries 获取已到期的待发送投递，回调已停用的投递保留到重新启用后再发送
+func GetDueWebhookDeliveries(ctx context.Context, count int) ([]WebhookDelivery, error) {
+	db := DB.WithContext(ctx)
+	var deliveryList []WebhookDelivery
+	err := db.Where("status = ? AND next_run_at <= ?", DeliveryPending, time.Now().UnixMilli()).
+		Where("webhook_id IN (?)", db.Model(&Webhook{}).Select("id").Where("enabled = ?", true)).
+		Order("id asc").Limit(count).Find(&deliveryList).Error
+	return deliveryList, err
+}
+
+// ClaimWebhookDelivery 认领投递，在租约 WebhookLease 内其他实例不会重复发送
+// 返回是否认领成功，认领时增加尝试次数
+func ClaimWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) (bool, error) {
+	db := DB.WithContext(ctx)
+	leaseEnd := time.Now().Add(config.WebhookLease).UnixMilli()
+	result := db.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_run_at = ?", delivery.Id, DeliveryPending, delivery.NextRunAt).
+		Updates(map[string]interface{}{
+			"next_run_at": leaseEnd,
+			"attempts":    gorm.Expr("attempts + ?", 1),
+		})
+	if result.Error != nil || result.RowsAffected <= 0 {
+		return false, result.Error
+	}
+	delivery.NextRunAt = leaseEnd
+	delivery.Attempts++
+	return true, nil
+}
+
+// FinishWebhookDelivery 记录一次尝试的结果并更新回调的连续失败次数
+// 失败时按尝试次数指数退避，最长间隔 WebhookRetryMax，达到 WebhookMaxAttempts 后标记为失败
+// 连续失败达到 WebhookMaxFailures 时停用回调，返回回调是否因此被停用
+func FinishWebhookDelivery(ctx context.Context, delivery WebhookDelivery, cause error) (bool, error) {
+	db := DB.WithContext(ctx)
+	updates := map[string]interface{}{
+		"response_code": delivery.ResponseCode,
+		"response_body": delivery.ResponseBody,
+		"duration":      delivery.Duration,
+		"last_error":    "",
+	}
+	if cause == nil {
+		updates["status"] = DeliverySuccess
+	} else if delivery.Attempts >= config.WebhookMaxAttempts {
+		updates["status"] = DeliveryFailed
+		updates["last_error"] = cause.Error()
+	} else {
+		backoff := config.WebhookRetryMax
+		if delivery.Attempts < 20 { // 避免移位溢出
+			if d := config.WebhookRetryBase << (delivery.Attempts - 1); d < backoff {
+				backoff = d
+			}
+		}
+		updates["next_run_at"] = time.Now().Add(backoff).UnixMilli()
+		updates["last_error"] = cause.Error()
+	}
+	disabled := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&WebhookDelivery{}).Where("id = ?", delivery.Id).Updates(updates).Error; err != nil {
+			return err
+		}
+		if cause == nil {
+			return tx.Model(&Webhook{}).Where("id = ?", delivery.WebhookId).Update("failure_count", 0).Error
+		}
+		if err := tx.Model(&Webhook{}).Where("id = ?", delivery.WebhookId).
+			Update("failure_count", gorm.Expr("failure_count + ?", 1)).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Webhook{}).Where("id = ? AND enabled = ? AND failure_count >= ?", delivery.WebhookId, true, config.WebhookMaxFailures).
+			Update("enabled", false)
+		disabled = result.RowsAffected > 0
+		return result.Error
+	})
+	return disabled, err
+}
+
+// GetWebhookDeliveryList 按 id 倒序分页获取回调的投递日志，cursor 为上一页最后一条的 id，首页为 0
+func GetWebhookDeliveryList(ctx context.Context, webhookId, cursor int64, count int) ([]WebhookDelivery, error) {
+	db := DB.WithContext(ctx)
+	var deliveryList []WebhookDelivery
+	query := db.Where("webhook_id = ?", webhookId)
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+	err := query.Order("id desc").Limit(count).Find(&deliveryList).Error
+	return deliveryList, err
+}
+
+// RedeliverWebhook 将已结束的投递重新加入发送队列，尝试次数清零
+func RedeliverWebhook(ctx context.Context, deliveryId int64) error {
+	db := DB.WithContext(ctx)
+	result := db.Model(&WebhookDelivery{}).Where("id = ? AND status <> ?", deliveryId, DeliveryPending).
+		Updates(map[string]interface{}{
+			"status":      DeliveryPending,
+			"attempts":    0,
+			"next_run_at": time.Now().UnixMilli(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected <= 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package filter
+
+import "unicode"
+
+// automaton Aho-Corasick 自动机，按 rune 匹配以支持中文
+type automaton struct {
+	nodes []node
+}
+
+type node struct {
+	children map[rune]int
+	fail     int
+	// 以该节点结尾的敏感词在 words 中的下标，包括通过失败指针继承的
+	outputs []int
+}
+
+// match 一次匹配结果，start、end 为 rune 下标，左闭右开
+type match struct {
+	word       int
+	start, end int
+}
+
+// newAutomaton 根据敏感词列表构建自动机，敏感词不区分大小写
+func newAutomaton(words []string) *automaton {
+	a := &automaton{nodes: []node{{children: map[rune]int{}}}}
+	// 构建字典树
+	for i, word := range words {
+		cur := 0
+		for _, r := range word {
+			r = unicode.ToLower(r)
+			next, ok := a.nodes[cur].children[r]
+			if !ok {
+				next = len(a.nodes)
+				a.nodes = append(a.nodes, node{children: map[rune]int{}})
+				a.nodes[cur].children[r] = next
+			}
+			cur = next
+		}
+		if cur != 0 {
+			a.nodes[cur].outputs = append(a.nodes[cur].outputs, i)
+		}
+	}
+	// 广度优先构建失败指针
+	queue := make([]int, 0, len(a.nodes))
+	for _, child := range a.nodes[0].children {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range a.nodes[cur].children {
+			fail := a.nodes[cur].fail
+			for fail != 0 {
+				if _, ok := a.nodes[fail].children[r]; ok {
+					break
+				}
+				fail = a.nodes[fail].fail
+			}
+			if next, ok := a.nodes[fail].children[r]; ok && next != child {
+				a.nodes[child].fail = next
+			}
+			failNode := a.nodes[child].fail
+			a.nodes[child].outputs = append(a.nodes[child].outputs, a.nodes[failNode].outputs...)
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// find 返回文本中出现的所有敏感词
+func (a *automaton) find(text []rune, words [][]rune) []match {
+	var matchList []match
+	cur := 0
+	for i, r := range text {
+		r = unicode.ToLower(r)
+		for cur != 0 {
+			if _, ok := a.nodes[cur].children[r]; ok {
+				break
+			}
+			cur = a.nodes[cur].fail
+		}
+		if next, ok := a.nodes[cur].children[r]; ok {
+			cur = next
+		}
+		for _, word := range a.nodes[cur].outputs {
+			matchList = append(matchList, match{word: word, start: i + 1 - len(words[word]), end: i + 1})
+		}
+	}
+	return matchList
+}
@@ -0,0 +1,165 @@
+package filter
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action 命中敏感词后的处理方式，数值越大越严格
+type Action int
+
+const (
+	ActionPass   Action = iota // 通过
+	ActionReview               // 通过，但记录下来等待人工审核
+	ActionMask                 // 将敏感词替换为星号
+	ActionReject               // 拒绝
+)
+
+var actionNames = map[string]Action{
+	"pass":   ActionPass,
+	"review": ActionReview,
+	"mask":   ActionMask,
+	"reject": ActionReject,
+}
+
+func (action Action) String() string {
+	for name, a := range actionNames {
+		if a == action {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Result 过滤结果
+type Result struct {
+	Action Action   // 所有命中的敏感词中最严格的处理方式
+	Text   string   // 处理后的文本，ActionMask 时敏感词被替换为星号
+	Words  []string // 命中的敏感词
+}
+
+// wordList 当前生效的敏感词表，重新加载时整体替换
+type wordList struct {
+	words   [][]rune
+	actions []Action
+	ac      *automaton
+}
+
+var (
+	mu      sync.RWMutex
+	current = &wordList{ac: newAutomaton(nil)}
+)
+
+// Load 从文件中加载敏感词表，每行一个敏感词，可以用 "|" 指定处理方式，如 "广告|mask"
+// 未指定处理方式时使用 defaultAction，空行和以 "#" 开头的行会被忽略
+func Load(path string, defaultAction Action) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var wordStrList []string
+	list := &wordList{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		word, actionName, found := strings.Cut(line, "|")
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		action := defaultAction
+		if found {
+			a, ok := actionNames[strings.TrimSpace(actionName)]
+			if !ok {
+				log.Printf("敏感词 %q 的处理方式 %q 无效，使用默认处理方式", word, actionName)
+			} else {
+				action = a
+			}
+		}
+		wordStrList = append(wordStrList, word)
+		list.words = append(list.words, []rune(word))
+		list.actions = append(list.actions, action)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	list.ac = newAutomaton(wordStrList)
+	mu.Lock()
+	current = list
+	mu.Unlock()
+	return nil
+}
+
+// Watch 每隔 cycle 检查一次敏感词文件，文件修改后重新加载，用于热更新
+func Watch(path string, defaultAction Action, cycle time.Duration) {
+	var lastModTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModTime = info.ModTime()
+	}
+	for range time.Tick(cycle) {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !info.ModTime().After(lastModTime) {
+			continue
+		}
+		if err := Load(path, defaultAction); err != nil {
+			log.Println(err)
+			continue
+		}
+		lastModTime = info.ModTime()
+		log.Println("敏感词表已重新加载")
+	}
+}
+
+// Check 检查文本中的敏感词
+func Check(text string) Result {
+	mu.RLock()
+	list := current
+	mu.RUnlock()
+	runes := []rune(text)
+	matchList := list.ac.find(runes, list.words)
+	result := Result{Action: ActionPass, Text: text}
+	if len(matchList) == 0 {
+		return result
+	}
+	seen := make(map[int]bool)
+	for _, m := range matchList {
+		if list.actions[m.word] > result.Action {
+			result.Action = list.actions[m.word]
+		}
+		if !seen[m.word] {
+			seen[m.word] = true
+			result.Words = append(result.Words, string(list.words[m.word]))
+		}
+	}
+	// 只替换处理方式为 ActionMask 的敏感词
+	if result.Action == ActionMask {
+		for _, m := range matchList {
+			if list.actions[m.word] != ActionMask {
+				continue
+			}
+			for i := m.start; i < m.end; i++ {
+				runes[i] = '*'
+			}
+		}
+		result.Text = string(runes)
+	}
+	return result
+}
+
+// ParseAction 将处理方式名称转换为 Action，无效名称返回 false
+func ParseAction(name string) (Action, bool) {
+	action, ok := actionNames[name]
+	return action, ok
+}
@@ -0,0 +1,79 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// 视频和封面在本地的存储目录，通过 /static 对外提供访问
+const (
+	videoDir = "./public/videos/"
+	coverDir = "./public/covers/"
+)
+
+// FileName 生成保存的文件名，为防止文件名冲突，增加作者 id 和视频 id
+func FileName(userId, videoId int64, filename string) string {
+	return fmt.Sprintf("%d_%d_%s", userId, videoId, filepath.Base(filename))
+}
+
+// PlayUrl 视频的访问链接
+func PlayUrl(name string) string {
+	return config.ServerAddr + "/static/videos/" + name
+}
+
+// CoverUrl 封面的访问链接
+func CoverUrl(name string) string {
+	return config.ServerAddr + "/static/covers/" + name + ".jpg"
+}
+
+// SaveVideo 将上传的视频存入本地
+func SaveVideo(data *multipart.FileHeader, name string) error {
+	src, err := data.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(filepath.Join(videoDir, name))
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// GenerateCover 调用 ffmpeg 获取封面（第一帧老是黑屏，所以这里获取第 300 帧）
+// 当然更好的实践是先读取总共有多少帧，再获取中间的某一帧，这里为了简便实现就先这样了
+// ctx 取消时会结束 ffmpeg 进程，开发模式下未安装 ffmpeg 时跳过，视频没有封面
+func GenerateCover(ctx context.Context, name string) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil && config.Mode == config.ModeDev {
+		log.Println("未安装 ffmpeg，跳过封面生成：" + name)
+		return nil
+	}
+	cmd := exec.CommandContext(ctx,
+		"ffmpeg", "-i", filepath.Join(videoDir, name),
+		"-vf", "select=eq(n\\, 300)", "-frames", "1",
+		filepath.Join(coverDir, name+".jpg"),
+	)
+	//cmd.Stderr = os.Stderr // 输出错误信息
+	return cmd.Run()
+}
+
+// Remove 删除视频和封面的本地文件，文件不存在时忽略
+func Remove(playUrl string) error {
+	name := filepath.Base(playUrl)
+	if err := os.Remove(filepath.Join(videoDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(coverDir, name+".jpg")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
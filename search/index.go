@@ -0,0 +1,377 @@
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可搜索的文档类型
+const (
+	TypeVideo = "video" // 视频标题
+	TypeUser  = "user"  // 用户名
+	TypeTopic = "topic" // 话题名
+)
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Hit 一条搜索结果，Score 为文本相关度
+type Hit struct {
+	Type  string  `json:"type"`
+	Id    int64   `json:"id"`
+	Text  string  `json:"text"`
+	Score float64 `json:"-"`
+}
+
+// doc 被索引的文档，字段需要导出以便 gob 编码
+type doc struct {
+	Type string
+	Id   int64
+	Text string
+	Len  int // 分词后的词数
+}
+
+// index 倒排索引，Postings 为词到文档及词频的映射，文档以 "类型:id" 作为 key
+type index struct {
+	Docs     map[string]doc
+	Postings map[string]map[string]int
+	TotalLen int
+	BuiltAt  int64    // reindex 开始重建的时间（毫秒），重新加载后重放此后的增量修改
+	terms    []string // 排好序的词表，用于前缀补全，索引修改后置空，下次补全时重新生成
+}
+
+// change 一次增量修改，text 为空表示删除
+type change struct {
+	At   int64 // 修改的时间（毫秒）
+	Type string
+	Id   int64
+	Text string
+}
+
+var (
+	mu              sync.RWMutex
+	current         = newIndex()
+	dirty           bool      // 内存中的索引是否有尚未保存的修改
+	lastRebuildTime time.Time // 最近一次加载的 reindex 重建的索引文件的修改时间
+	// 最近 SearchJournalKeep 内的增量修改，重新加载重建的索引时重放 BuiltAt 之后的部分
+	// 避免 reindex 读取 MySQL 之后发生的修改在重新加载后丢失
+	journal []change
+)
+
+func newIndex() *index {
+	return &index{Docs: map[string]doc{}, Postings: map[string]map[string]int{}}
+}
+
+func docKey(docType string, id int64) string {
+	return docType + ":" + strconv.FormatInt(id, 10)
+}
+
+func (idx *index) add(docType string, id int64, text string) {
+	key := docKey(docType, id)
+	idx.remove(key)
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return
+	}
+	for _, token := range tokens {
+		postings, ok := idx.Postings[token]
+		if !ok {
+			postings = map[string]int{}
+			idx.Postings[token] = postings
+			idx.terms = nil
+		}
+		postings[key]++
+	}
+	idx.Docs[key] = doc{Type: docType, Id: id, Text: text, Len: len(tokens)}
+	idx.TotalLen += len(tokens)
+}
+
+func (idx *index) remove(key string) {
+	d, ok := idx.Docs[key]
+	if !ok {
+		return
+	}
+	for _, token := range tokenize(d.Text) {
+		postings := idx.Postings[token]
+		delete(postings, key)
+		if len(postings) == 0 {
+			delete(idx.Postings, token)
+			idx.terms = nil
+		}
+	}
+	delete(idx.Docs, key)
+	idx.TotalLen -= d.Len
+}
+
+// Index 添加或更新一个文档，text 为空时相当于删除
+func Index(docType string, id int64, text string) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.add(docType, id, text)
+	journal = append(journal, change{At: time.Now().UnixMilli(), Type: docType, Id: id, Text: text})
+	dirty = true
+}
+
+// Remove 删除一个文档
+func Remove(docType string, id int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.remove(docKey(docType, id))
+	journal = append(journal, change{At: time.Now().UnixMilli(), Type: docType, Id: id})
+	dirty = true
+}
+
+// replay 将 BuiltAt 之后的增量修改应用到重建的索引上，需要持有写锁
+func (idx *index) replay() {
+	for _, c := range journal {
+		if c.At < idx.BuiltAt {
+			continue
+		}
+		if c.Text == "" {
+			idx.remove(docKey(c.Type, c.Id))
+		} else {
+			idx.add(c.Type, c.Id, c.Text)
+		}
+	}
+}
+
+// trimJournal 丢弃早于 keep 之前的增量修改
+func trimJournal(keep time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	deadline := time.Now().Add(-keep).UnixMilli()
+	i := sort.Search(len(journal), func(i int) bool { return journal[i].At >= deadline })
+	journal = append([]change(nil), journal[i:]...)
+}
+
+// Search 在指定类型的文档中按 BM25 文本相关度搜索，返回得分最高的 limit 条结果
+// 查询词之间是“或”的关系，命中的词越多、越稀有，得分越高
+func Search(docType, query string, limit int) []Hit {
+	mu.RLock()
+	defer mu.RUnlock()
+	idx := current
+	if len(idx.Docs) == 0 {
+		return nil
+	}
+	avgLen := float64(idx.TotalLen) / float64(len(idx.Docs))
+	n := float64(len(idx.Docs))
+	scoreMap := map[string]float64{}
+	seen := map[string]bool{}
+	for _, token := range tokenize(query) {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		postings := idx.Postings[token]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for key, tf := range postings {
+			d := idx.Docs[key]
+			if d.Type != docType {
+				continue
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(d.Len)/avgLen)
+			scoreMap[key] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+		}
+	}
+	hitList := make([]Hit, 0, len(scoreMap))
+	for key, score := range scoreMap {
+		d := idx.Docs[key]
+		hitList = append(hitList, Hit{Type: d.Type, Id: d.Id, Text: d.Text, Score: score})
+	}
+	sort.Slice(hitList, func(i, j int) bool {
+		if hitList[i].Score != hitList[j].Score {
+			return hitList[i].Score > hitList[j].Score
+		}
+		return hitList[i].Id > hitList[j].Id
+	})
+	if len(hitList) > limit {
+		hitList = hitList[:limit]
+	}
+	return hitList
+}
+
+// Suggest 前缀补全，返回包含以 prefix 开头的词的文档，不区分类型
+// 文本本身以 prefix 开头的排在前面，其次是文本较短的
+func Suggest(prefix string, limit int) []Hit {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if prefix == "" {
+		return nil
+	}
+	// 补全需要排好序的词表，词表失效时在写锁内重新生成并完成本次补全
+	// 避免释放写锁后词表被并发的修改置空，或索引被重新加载替换
+	mu.RLock()
+	if current.terms == nil {
+		mu.RUnlock()
+		mu.Lock()
+		defer mu.Unlock()
+		if current.terms == nil {
+			current.terms = make([]string, 0, len(current.Postings))
+			for term := range current.Postings {
+				current.terms = append(current.terms, term)
+			}
+			sort.Strings(current.terms)
+		}
+	} else {
+		defer mu.RUnlock()
+	}
+	idx := current
+	// 中文前缀取最后两个字即可匹配两字词，字母数字前缀取最后一个片段
+	tokens := tokenize(prefix)
+	if len(tokens) == 0 {
+		return nil
+	}
+	last := tokens[len(tokens)-1]
+	keySet := map[string]bool{}
+	for i := sort.SearchStrings(idx.terms, last); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], last); i++ {
+		for key := range idx.Postings[idx.terms[i]] {
+			keySet[key] = true
+		}
+	}
+	hitList := make([]Hit, 0, len(keySet))
+	for key := range keySet {
+		d := idx.Docs[key]
+		hitList = append(hitList, Hit{Type: d.Type, Id: d.Id, Text: d.Text})
+	}
+	sort.Slice(hitList, func(i, j int) bool {
+		iPrefix := strings.HasPrefix(strings.ToLower(hitList[i].Text), prefix)
+		jPrefix := strings.HasPrefix(strings.ToLower(hitList[j].Text), prefix)
+		if iPrefix != jPrefix {
+			return iPrefix
+		}
+		if len(hitList[i].Text) != len(hitList[j].Text) {
+			return len(hitList[i].Text) < len(hitList[j].Text)
+		}
+		return hitList[i].Id > hitList[j].Id
+	})
+	if len(hitList) > limit {
+		hitList = hitList[:limit]
+	}
+	return hitList
+}
+
+// Reset 清空索引，用于重建，需要在读取 MySQL 之前调用，以记录重建开始的时间
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	current = newIndex()
+	current.BuiltAt = time.Now().UnixMilli()
+	journal = nil
+	dirty = true
+}
+
+// Load 加载当前实例的索引文件 path，文件不存在或比 reindex 重建的索引文件 rebuildPath 旧时加载 rebuildPath
+// 两个文件都不存在时使用空索引
+func Load(path, rebuildPath string) error {
+	instanceInfo, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	rebuildInfo, err := os.Stat(rebuildPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	switch {
+	case rebuildInfo != nil && (instanceInfo == nil || rebuildInfo.ModTime().After(instanceInfo.ModTime())):
+		// 重建的索引保存到当前实例的索引文件之前视为有修改
+		return load(rebuildPath, true, rebuildInfo.ModTime())
+	case instanceInfo != nil:
+		var rebuildTime time.Time
+		if rebuildInfo != nil {
+			rebuildTime = rebuildInfo.ModTime()
+		}
+		return load(path, false, rebuildTime)
+	default:
+		log.Println("搜索索引文件不存在，使用空索引，可以运行 reindex 命令从 MySQL 重建")
+		return nil
+	}
+}
+
+// load 从文件中加载索引并重放其 BuiltAt 之后的增量修改，rebuildTime 为已加载的重建索引文件的修改时间
+func load(path string, modified bool, rebuildTime time.Time) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	idx := newIndex()
+	if err := gob.NewDecoder(file).Decode(idx); err != nil {
+		return err
+	}
+	mu.Lock()
+	idx.replay()
+	current = idx
+	dirty = modified
+	lastRebuildTime = rebuildTime
+	mu.Unlock()
+	return nil
+}
+
+// Save 将索引保存到文件，先写入临时文件再重命名，避免保存中途出错损坏原文件
+func Save(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if err := gob.NewEncoder(tmpFile).Encode(current); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return err
+	}
+	dirty = false
+	return nil
+}
+
+// Watch 每隔 cycle 将修改过的索引保存到当前实例的索引文件 path
+// 如果 rebuildPath 被 reindex 命令重建过，则重新加载，并重放重建开始之后的增量修改
+func Watch(path, rebuildPath string, cycle time.Duration) {
+	for range time.Tick(cycle) {
+		trimJournal(config.SearchJournalKeep)
+		if info, err := os.Stat(rebuildPath); err == nil && info.ModTime().After(lastRebuildModTime()) {
+			if err := load(rebuildPath, true, info.ModTime()); err != nil {
+				log.Println(err)
+			} else {
+				log.Println("搜索索引已重新加载")
+			}
+		}
+		mu.RLock()
+		needSave := dirty
+		mu.RUnlock()
+		if !needSave {
+			continue
+		}
+		if err := Save(path); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func lastRebuildModTime() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	return lastRebuildTime
+}
@@ -0,0 +1,226 @@
+package search
+
+import (
+	"encoding/gob"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// resetIndex 清空包级别的索引状态，每个测试之前调用
+func resetIndex(t *testing.T) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = newIndex()
+	journal = nil
+	dirty = false
+	lastRebuildTime = time.Time{}
+}
+
+// writeIndex 将索引写入文件，并把文件的修改时间设为 modTime
+func writeIndex(t *testing.T, path string, idx *index, modTime time.Time) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(file).Encode(idx); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hitIds(hitList []Hit) []int64 {
+	idList := make([]int64, 0, len(hitList))
+	for _, hit := range hitList {
+		idList = append(idList, hit.Id)
+	}
+	return idList
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello World", []string{"hello", "world"}},
+		{"a,b-c", []string{"a", "b", "c"}},
+		{"中文分词", []string{"中", "文", "中文", "分", "文分", "词", "分词"}},
+		{"Go语言123", []string{"go", "语", "言", "语言", "123"}},
+		{"你好 世界", []string{"你", "好", "你好", "世", "界", "世界"}},
+		{"すし", []string{"す", "し", "すし"}},
+		{"  ", nil},
+	}
+	for _, test := range tests {
+		if got := tokenize(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q) = %q，应为 %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSearchBM25(t *testing.T) {
+	resetIndex(t)
+	Index(TypeVideo, 1, "go")
+	// 只有一个文档时 idf = ln(1 + 0.5/1.5)，文档长度等于平均长度，得分等于 idf
+	hitList := Search(TypeVideo, "go", 10)
+	if len(hitList) != 1 || math.Abs(hitList[0].Score-math.Log(4.0/3.0)) > 1e-9 {
+		t.Fatalf("单个文档的搜索结果为 %+v", hitList)
+	}
+
+	resetIndex(t)
+	Index(TypeVideo, 1, "golang tutorial for beginners")
+	Index(TypeVideo, 2, "golang")
+	Index(TypeVideo, 3, "python tutorial")
+	Index(TypeVideo, 4, "rare golang")
+	Index(TypeUser, 5, "golang")
+	// 词频相同时较短的文档得分更高，其他类型的文档不返回
+	if got := hitIds(Search(TypeVideo, "golang", 10)); !reflect.DeepEqual(got, []int64{2, 4, 1}) {
+		t.Fatalf("搜索 golang 的结果为 %v", got)
+	}
+	// 命中较稀有的词得分更高
+	if got := hitIds(Search(TypeVideo, "rare tutorial", 10)); len(got) != 3 || got[0] != 4 {
+		t.Fatalf("搜索 rare tutorial 的结果为 %v", got)
+	}
+	// 查询中重复的词只计算一次
+	once, twice := Search(TypeVideo, "python", 10), Search(TypeVideo, "python python", 10)
+	if len(once) != 1 || len(twice) != 1 || once[0].Score != twice[0].Score {
+		t.Fatalf("重复查询词的结果为 %+v 和 %+v", once, twice)
+	}
+	if got := Search(TypeVideo, "golang", 2); len(got) != 2 {
+		t.Fatalf("limit 为 2 时返回了 %d 条结果", len(got))
+	}
+	// 更新和删除后不再命中旧内容
+	Index(TypeVideo, 2, "rust")
+	Remove(TypeVideo, 4)
+	if got := hitIds(Search(TypeVideo, "golang", 10)); !reflect.DeepEqual(got, []int64{1}) {
+		t.Fatalf("更新和删除后搜索 golang 的结果为 %v", got)
+	}
+	// 中文按两字词匹配，命中的两字词越多得分越高
+	Index(TypeVideo, 6, "美食探店")
+	Index(TypeVideo, 7, "美丽的食物")
+	if got := hitIds(Search(TypeVideo, "美食", 10)); len(got) != 2 || got[0] != 6 {
+		t.Fatalf("搜索 美食 的结果为 %v", got)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	resetIndex(t)
+	Index(TypeUser, 1, "golang")
+	Index(TypeVideo, 2, "learn golang")
+	Index(TypeTopic, 3, "gophers")
+	Index(TypeVideo, 4, "python")
+	// 文本以前缀开头的排在前面，其次是较短的文本
+	if got := hitIds(Suggest("go", 10)); !reflect.DeepEqual(got, []int64{1, 3, 2}) {
+		t.Fatalf("补全 go 的结果为 %v", got)
+	}
+	// 修改后词表重新生成
+	Index(TypeVideo, 4, "gossip")
+	if got := hitIds(Suggest("gos", 10)); !reflect.DeepEqual(got, []int64{4}) {
+		t.Fatalf("补全 gos 的结果为 %v", got)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path, rebuildPath := filepath.Join(dir, "search.test.index"), filepath.Join(dir, "search.index")
+	instance, rebuilt := newIndex(), newIndex()
+	instance.add(TypeVideo, 1, "instance")
+	rebuilt.add(TypeVideo, 1, "rebuilt")
+	now := time.Now()
+
+	// 两个文件都不存在时使用空索引
+	resetIndex(t)
+	if err := Load(path, rebuildPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(current.Docs) != 0 {
+		t.Fatalf("索引为 %+v", current.Docs)
+	}
+
+	// 只有重建的索引时加载重建的索引，并标记为需要保存到当前实例的索引文件
+	writeIndex(t, rebuildPath, rebuilt, now.Add(-time.Hour))
+	resetIndex(t)
+	if err := Load(path, rebuildPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := hitIds(Search(TypeVideo, "rebuilt", 10)); len(got) != 1 || !dirty {
+		t.Fatalf("加载重建的索引后搜索结果为 %v，dirty 为 %v", got, dirty)
+	}
+
+	// 当前实例的索引较新时加载当前实例的索引
+	writeIndex(t, path, instance, now)
+	resetIndex(t)
+	if err := Load(path, rebuildPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := hitIds(Search(TypeVideo, "instance", 10)); len(got) != 1 || dirty {
+		t.Fatalf("加载当前实例的索引后搜索结果为 %v，dirty 为 %v", got, dirty)
+	}
+	if !lastRebuildTime.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("重建索引的修改时间为 %v", lastRebuildTime)
+	}
+
+	// 重建的索引较新时加载重建的索引
+	writeIndex(t, rebuildPath, rebuilt, now.Add(time.Hour))
+	resetIndex(t)
+	if err := Load(path, rebuildPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := hitIds(Search(TypeVideo, "rebuilt", 10)); len(got) != 1 {
+		t.Fatalf("重建的索引较新时搜索结果为 %v", got)
+	}
+
+	// 保存不影响已加载的重建索引的修改时间，Watch 不会重复加载
+	if err := Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if dirty || !lastRebuildTime.Equal(now.Add(time.Hour)) {
+		t.Fatalf("保存后 dirty 为 %v，重建索引的修改时间为 %v", dirty, lastRebuildTime)
+	}
+}
+
+func TestReloadReplaysJournal(t *testing.T) {
+	resetIndex(t)
+	// 重建开始之前的修改已包含在重建结果中，不需要重放
+	Index(TypeVideo, 1, "stale")
+	time.Sleep(2 * time.Millisecond)
+	rebuilt := newIndex()
+	rebuilt.BuiltAt = time.Now().UnixMilli()
+	rebuilt.add(TypeVideo, 2, "rebuilt")
+	rebuilt.add(TypeVideo, 3, "removed")
+	// 重建开始之后的修改需要在重新加载后重放
+	Index(TypeVideo, 4, "fresh")
+	Remove(TypeVideo, 3)
+	Index(TypeVideo, 2, "renamed")
+
+	path := filepath.Join(t.TempDir(), "search.index")
+	writeIndex(t, path, rebuilt, time.Now())
+	if err := load(path, true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for query, want := range map[string][]int64{
+		"stale":   {},
+		"rebuilt": {},
+		"renamed": {2},
+		"removed": {},
+		"fresh":   {4},
+	} {
+		if got := hitIds(Search(TypeVideo, query, 10)); !reflect.DeepEqual(got, want) {
+			t.Errorf("重新加载后搜索 %s 的结果为 %v，应为 %v", query, got, want)
+		}
+	}
+
+	// 超出保留时长的修改被丢弃
+	time.Sleep(2 * time.Millisecond)
+	trimJournal(time.Millisecond)
+	if len(journal) != 0 {
+		t.Fatalf("丢弃后还有 %d 条修改", len(journal))
+	}
+}
@@ -0,0 +1,46 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize 将文本切分为索引词，不区分大小写
+// 字母和数字组成的连续片段作为一个词；中文等没有空格分隔的文字同时切分为单字和相邻两字，
+// 这样不需要词典也能匹配任意长度的中文词，两字词命中越多得分越高
+func tokenize(text string) []string {
+	var tokens []string
+	var word []rune // 当前的字母数字片段
+	var prev rune   // 上一个中文字符，用于生成两字词
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			tokens = append(tokens, string(r))
+			if prev != 0 {
+				tokens = append(tokens, string([]rune{prev, r}))
+			}
+			prev = r
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			prev = 0
+			word = append(word, r)
+		default:
+			prev = 0
+			flushWord()
+		}
+	}
+	flushWord()
+	return tokens
+}
+
+// isCJK 判断是否为中日韩文字，这些文字按字切分
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ActionAddCollection    = 1
+	ActionDeleteCollection = 2
+	ActionEditCollection   = 3
+)
+
+type CollectionResponse struct {
+	Response
+	Collection dal.Collection `json:"collection"`
+}
+
+type CollectionListResponse struct {
+	Response
+	CollectionList []dal.Collection `json:"collection_list"`
+}
+
+type CollectionItemListResponse struct {
+	Response
+	Collection dal.Collection `json:"collection"`
+	VideoList  []dal.Video    `json:"video_list"`
+	NextOffset int64          `json:"next_offset"`
+	HasMore    bool           `json:"has_more"`
+}
+
+// collectionName 检查并过滤收藏夹名称，名称无效时 ok 为 false，msg 为提示信息
+func collectionName(ctx context.Context, userId int64, name string) (string, bool, string) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > config.MaxCollectionNameLen {
+		return "", false, "收藏夹名称长度无效"
+	}
+	return filterContent(ctx, userId, SceneCollection, name, false)
+}
+
+// fillCollectionVideoCount 将收藏夹的视频数替换为 userId 有权限查看的视频数
+func fillCollectionVideoCount(ctx context.Context, userId int64, collectionList []dal.Collection) error {
+	collectionIdList := make([]int64, len(collectionList))
+	for i, collection := range collectionList {
+		collectionIdList[i] = collection.Id
+	}
+	countMap, err := dal.CountVisibleCollectionItems(ctx, userId, collectionIdList)
+	if err != nil {
+		return err
+	}
+	for i, collection := range collectionList {
+		collectionList[i].VideoCount = countMap[collection.Id]
+	}
+	return nil
+}
+
+// CollectionAction 创建、删除、修改收藏夹，is_public 为 1 时收藏夹公开，可以分享给其他用户
+func CollectionAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	collectionId := util.QueryId(c, "collection_id")
+	isPublic := util.QueryId(c, "is_public") == 1
+	switch actionType {
+	case ActionAddCollection:
+		name, ok, msg := collectionName(ctx, userId, c.Query("name"))
+		if !ok {
+			ResponseFailed(c, msg)
+			return
+		}
+		collection, err := cache.AddCollection(ctx, dal.Collection{UserId: userId, Name: name, IsPublic: isPublic})
+		if err != nil {
+			log.Println(err)
+			ResponseFailed(c, "创建失败")
+			return
+		}
+		c.JSON(http.StatusOK, CollectionResponse{
+			Response:   Response{StatusCode: StatusSuccess, StatusMsg: "创建成功"},
+			Collection: collection,
+		})
+	case ActionDeleteCollection:
+		if err := cache.DeleteCollection(ctx, userId, collectionId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "删除失败")
+		} else {
+			ResponseSuccess(c, "删除成功")
+		}
+	case ActionEditCollection:
+		name, ok, msg := collectionName(ctx, userId, c.Query("name"))
+		if !ok {
+			ResponseFailed(c, msg)
+			return
+		}
+		if err := cache.EditCollection(ctx, userId, collectionId, name, isPublic); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "修改失败")
+		} else {
+			ResponseSuccess(c, "修改成功")
+		}
+	default:
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
+// CollectionList 获取用户的收藏夹列表，查看他人或未登录时只返回公开的收藏夹
+func CollectionList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userAId := util.GetTokenUserId(c)
+	userBId := util.QueryId(c, "user_id")
+	collectionList, err := cache.ReadCollectionList(ctx, userAId, userBId)
+	if err == nil {
+		err = fillCollectionVideoCount(ctx, userAId, collectionList)
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, CollectionListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取收藏夹列表失败"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, CollectionListResponse{
+		Response:       Response{StatusCode: StatusSuccess},
+		CollectionList: collectionList,
+	})
+}
+
+const (
+	ActionAddCollectionItem    = 1
+	ActionDeleteCollectionItem = 2
+)
+
+// CollectionItemAction 将视频加入或移出收藏夹，不影响视频的点赞数
+func CollectionItemAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	collectionId := util.QueryId(c, "collection_id")
+	videoId := util.QueryId(c, "video_id")
+	if actionType == ActionAddCollectionItem {
+		// 无权限查看的视频视为不存在
+		if _, err := cache.ReadVisibleVideo(ctx, userId, videoId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "视频不存在")
+			return
+		}
+		if err := cache.AddCollectionItem(ctx, userId, collectionId, videoId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "收藏失败")
+		} else {
+			ResponseSuccess(c, "收藏成功")
+		}
+	} else if actionType == ActionDeleteCollectionItem {
+		if err := cache.DeleteCollectionItem(ctx, userId, collectionId, videoId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "取消收藏失败")
+		} else {
+			ResponseSuccess(c, "取消收藏成功")
+		}
+	} else {
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
+// MoveCollectionItem 调整收藏夹中视频的顺序，to_index 为移动后的位置，0 表示最前面
+func MoveCollectionItem(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	collectionId := util.QueryId(c, "collection_id")
+	videoId := util.QueryId(c, "video_id")
+	toIndex := int(util.QueryId(c, "to_index"))
+	if err := cache.MoveCollectionItem(ctx, userId, collectionId, videoId, toIndex); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "移动失败")
+	} else {
+		ResponseSuccess(c, "移动成功")
+	}
+}
+
+// CollectionItemList 分页获取收藏夹中的视频，offset 为上一页返回的 next_offset
+// 创建者可以查看自己的所有收藏夹，其他用户和未登录用户只能查看公开的收藏夹
+func CollectionItemList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	collectionId := util.QueryId(c, "collection_id")
+	collection, err := cache.ReadVisibleCollection(ctx, userId, collectionId)
+	if err == nil {
+		collectionList := []dal.Collection{collection}
+		err = fillCollectionVideoCount(ctx, userId, collectionList)
+		collection = collectionList[0]
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, CollectionItemListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "收藏夹不存在"},
+		})
+		return
+	}
+	offset := util.QueryId(c, "offset")
+	count := int(util.QueryId(c, "count"))
+	if count <= 0 || count > config.CollectionPageSize {
+		count = config.CollectionPageSize
+	}
+	videoList, nextOffset, hasMore, err := cache.ReadCollectionItems(ctx, userId, collectionId, offset, count)
+	if err == nil && userId != 0 { // 用户已登录，则需要进一步查询点赞信息和关注信息
+		for i, video := range videoList {
+			videoList[i].IsFavorite, err = cache.ReadFavorite(ctx, userId, video.Id)
+			if err == nil {
+				videoList[i].Author.IsFollow, err = cache.ReadRelation(ctx, userId, video.UserId)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, CollectionItemListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取收藏夹视频失败"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, CollectionItemListResponse{
+		Response:   Response{StatusCode: StatusSuccess},
+		Collection: collection,
+		VideoList:  videoList,
+		NextOffset: nextOffset,
+		HasMore:    hasMore,
+	})
+}
@@ -2,27 +2,33 @@ package service
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
 	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/dal"
 	"github.com/zenpk/mini-douyin-ex/util"
 	"log"
 	"net/http"
 	"strconv"
-	"time"
 )
 
 type CommentListResponse struct {
 	Response
-	CommentList []dal.Comment `json:"comment_list"`
+	CommentList  []dal.Comment `json:"comment_list"`
+	NextCursor   int64         `json:"next_cursor,omitempty"`
+	NextCursorId int64         `json:"next_cursor_id,omitempty"`
+	HasMore      bool          `json:"has_more,omitempty"`
 }
 
 const (
 	ActionAddComment    = 1
 	ActionDeleteComment = 2
+	ActionEditComment   = 3
 )
 
-// CommentAction 发表评论、删除评论
+// CommentAction 发表评论、删除评论、编辑评论
 func CommentAction(c *gin.Context) {
+	ctx := c.Request.Context()
 	// 获取操作
 	action := c.Query("action_type")
 	actionType, err := strconv.Atoi(action)
@@ -35,28 +41,74 @@ func CommentAction(c *gin.Context) {
 	}
 	userId := util.GetTokenUserId(c)
 	videoId := util.QueryId(c, "video_id")
-	commentId := util.QueryId(c, "comment_id") // 仅在删除评论时有效
+	commentId := util.QueryId(c, "comment_id")       // 仅在删除、编辑评论时有效
+	parentId := util.QueryId(c, "parent_comment_id") // 仅在回复评论时有效
 	commentText := c.Query("comment_text")
+	// 无权限查看的视频视为不存在
+	video, err := cache.ReadVisibleVideo(ctx, userId, videoId)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, CommentListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "视频不存在"},
+		})
+		return
+	}
 	comment := dal.Comment{
-		UserId:     userId,
-		VideoId:    videoId,
-		Content:    commentText,
-		CreateDate: time.Now().Format("01-02 15:04:05"),
+		UserId:   userId,
+		VideoId:  videoId,
+		ParentId: parentId,
+		Content:  commentText,
 	}
 	if actionType == ActionAddComment {
-		if err := cache.AddComment(comment); err != nil {
+		// 检查视频作者设置的评论权限
+		canComment, err := cache.CanComment(ctx, userId, video)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusOK, CommentListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "评论失败"},
+			})
+			return
+		}
+		if !canComment {
+			c.JSON(http.StatusOK, CommentListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "作者已限制评论"},
+			})
+			return
+		}
+		// 敏感词过滤和反垃圾检查
+		content, ok, msg := filterContent(ctx, userId, SceneComment, commentText, true)
+		if !ok {
+			c.JSON(http.StatusOK, CommentListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: msg},
+			})
+			return
+		}
+		comment.Content = content
+		// 解析 @ 提及，失败时按纯文本处理
+		spans, err := parseMentions(ctx, userId, content)
+		if err != nil {
+			log.Println(err)
+		}
+		comment.MentionData = dal.EncodeMentions(spans)
+		if comment, err = cache.AddComment(ctx, comment); err != nil {
 			log.Println(err)
 			c.JSON(http.StatusOK, CommentListResponse{
 				Response: Response{StatusCode: StatusFailed, StatusMsg: "评论失败"},
 			})
 		} else {
+			recordSpam(ctx, userId, SceneComment, commentText)
+			bus.Publish(ctx, bus.CommentAdded{CommentId: comment.Id, VideoId: videoId, UserId: userId})
+			// 返回评论时带上用户信息，读取失败不影响评论结果
+			if comment.User, err = cache.ReadUser(ctx, userId); err != nil {
+				log.Println(err)
+			}
 			c.JSON(http.StatusOK, CommentListResponse{
 				Response:    Response{StatusCode: StatusSuccess, StatusMsg: "评论成功"},
 				CommentList: []dal.Comment{comment},
 			})
 		}
 	} else if actionType == ActionDeleteComment {
-		if err := cache.DeleteComment(userId, videoId, commentId); err != nil {
+		if err := cache.DeleteComment(ctx, userId, videoId, commentId); err != nil {
 			log.Println(err)
 			c.JSON(http.StatusOK, CommentListResponse{
 				Response: Response{StatusCode: StatusFailed, StatusMsg: "删除评论失败"},
@@ -66,6 +118,33 @@ func CommentAction(c *gin.Context) {
 				Response: Response{StatusCode: StatusSuccess, StatusMsg: "删除评论成功"},
 			})
 		}
+	} else if actionType == ActionEditComment {
+		content, ok, msg := filterContent(ctx, userId, SceneComment, commentText, false)
+		if !ok {
+			c.JSON(http.StatusOK, CommentListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: msg},
+			})
+			return
+		}
+		// 编辑时只更新提及位置，不再重复通知
+		spans, err := parseMentions(ctx, userId, content)
+		if err != nil {
+			log.Println(err)
+		}
+		if comment, err = cache.EditComment(ctx, userId, commentId, content, dal.EncodeMentions(spans)); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusOK, CommentListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "编辑评论失败"},
+			})
+		} else {
+			if comment.User, err = cache.ReadUser(ctx, userId); err != nil {
+				log.Println(err)
+			}
+			c.JSON(http.StatusOK, CommentListResponse{
+				Response:    Response{StatusCode: StatusSuccess, StatusMsg: "编辑评论成功"},
+				CommentList: []dal.Comment{comment},
+			})
+		}
 	} else {
 		c.JSON(http.StatusOK, CommentListResponse{
 			Response: Response{StatusCode: StatusFailed, StatusMsg: "不支持的操作"},
@@ -75,17 +154,187 @@ func CommentAction(c *gin.Context) {
 
 // CommentList 获取评论列表
 func CommentList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
 	videoId := util.QueryId(c, "video_id")
-	if commentList, err := cache.ReadCommentList(videoId); err != nil {
-		// 不需要再进一步读取关注信息
+	// 无权限查看的视频视为不存在
+	if _, err := cache.ReadVisibleVideo(ctx, userId, videoId); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, CommentListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "视频不存在"},
+		})
+		return
+	}
+	// 排序方式为 newest（默认）、oldest 或 hot，cursor、cursor_id 为上一页返回的 next_cursor、next_cursor_id
+	sortType := c.Query("sort")
+	cursor := cache.Cursor{Score: util.QueryId(c, "cursor"), Id: util.QueryId(c, "cursor_id")}
+	count := int(util.QueryId(c, "count"))
+	if count <= 0 || count > config.CommentPageSize {
+		count = config.CommentPageSize
+	}
+	commentList, nextCursor, hasMore, err := cache.ReadCommentList(ctx, videoId, sortType, cursor, count)
+	if err == nil { // 不需要再进一步读取关注信息，但需要读取评论点赞信息
+		err = cache.FillCommentLike(ctx, userId, commentList)
+	}
+	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, CommentListResponse{
 			Response: Response{StatusCode: StatusSuccess, StatusMsg: "获取评论列表失败"},
 		})
+		return
+	}
+	c.JSON(http.StatusOK, CommentListResponse{
+		Response:     Response{StatusCode: StatusSuccess},
+		CommentList:  commentList,
+		NextCursor:   nextCursor.Score,
+		NextCursorId: nextCursor.Id,
+		HasMore:      hasMore,
+	})
+}
+
+const (
+	ActionPinComment   = 1
+	ActionUnpinComment = 2
+)
+
+// PinComment 视频作者置顶、取消置顶评论
+func PinComment(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	commentId := util.QueryId(c, "comment_id")
+	if actionType == ActionPinComment {
+		if err := cache.PinComment(ctx, userId, videoId, commentId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "置顶失败")
+		} else {
+			ResponseSuccess(c, "置顶成功")
+		}
+	} else if actionType == ActionUnpinComment {
+		if err := cache.PinComment(ctx, userId, videoId, 0); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "取消置顶失败")
+		} else {
+			ResponseSuccess(c, "取消置顶成功")
+		}
 	} else {
-		c.JSON(http.StatusOK, CommentListResponse{
-			Response:    Response{StatusCode: StatusSuccess},
-			CommentList: commentList,
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
+// CommentPermission 视频作者设置评论权限：所有人、仅粉丝或关闭评论
+func CommentPermission(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	permission := int32(util.QueryId(c, "permission"))
+	if err := cache.SetCommentPermission(ctx, userId, videoId, permission); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "设置失败")
+	} else {
+		ResponseSuccess(c, "设置成功")
+	}
+}
+
+const (
+	ActionLikeComment   = 1
+	ActionUnlikeComment = 2
+)
+
+// CommentLikeAction 点赞评论、取消点赞评论，重复操作不会重复计数
+func CommentLikeAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	commentId := util.QueryId(c, "comment_id")
+	// 检查评论所属视频是否可见
+	comment, err := cache.ReadComment(ctx, commentId)
+	if err == nil {
+		_, err = cache.ReadVisibleVideo(ctx, userId, comment.VideoId)
+	}
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "评论不存在")
+		return
+	}
+	if actionType == ActionLikeComment {
+		if err := cache.AddCommentLike(ctx, userId, commentId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "点赞失败")
+		} else {
+			ResponseSuccess(c, "点赞成功")
+		}
+	} else if actionType == ActionUnlikeComment {
+		if err := cache.DeleteCommentLike(ctx, userId, commentId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "取消点赞失败")
+		} else {
+			ResponseSuccess(c, "取消点赞成功")
+		}
+	} else {
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
+type ReplyListResponse struct {
+	Response
+	ReplyList    []dal.Comment `json:"reply_list"`
+	NextCursor   int64         `json:"next_cursor"`
+	NextCursorId int64         `json:"next_cursor_id"`
+	HasMore      bool          `json:"has_more"`
+}
+
+// ReplyList 分页获取一级评论的回复列表，cursor、cursor_id 为上一页返回的 next_cursor、next_cursor_id
+func ReplyList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	commentId := util.QueryId(c, "comment_id")
+	cursor := cache.Cursor{Score: util.QueryId(c, "cursor"), Id: util.QueryId(c, "cursor_id")}
+	count := int(util.QueryId(c, "count"))
+	if count <= 0 || count > config.CommentPageSize {
+		count = config.CommentPageSize
+	}
+	// 检查一级评论所属视频是否可见
+	root, err := cache.ReadComment(ctx, commentId)
+	if err == nil {
+		_, err = cache.ReadVisibleVideo(ctx, userId, root.VideoId)
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, ReplyListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "评论不存在"},
 		})
+		return
+	}
+	replyList, nextCursor, hasMore, err := cache.ReadReplyList(ctx, commentId, cursor, count)
+	if err == nil {
+		err = cache.FillCommentLike(ctx, userId, replyList)
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, ReplyListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取回复列表失败"},
+		})
+		return
 	}
+	c.JSON(http.StatusOK, ReplyListResponse{
+		Response:     Response{StatusCode: StatusSuccess},
+		ReplyList:    replyList,
+		NextCursor:   nextCursor.Score,
+		NextCursorId: nextCursor.Id,
+		HasMore:      hasMore,
+	})
 }
@@ -2,7 +2,9 @@ package service
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
 	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
 	"github.com/zenpk/mini-douyin-ex/dal"
 	"github.com/zenpk/mini-douyin-ex/util"
 	"log"
@@ -12,7 +14,10 @@ import (
 
 type FavoriteListResponse struct {
 	Response
-	VideoList []dal.Video `json:"video_list"`
+	VideoList    []dal.Video `json:"video_list"`
+	NextCursor   int64       `json:"next_cursor"`
+	NextCursorId int64       `json:"next_cursor_id"`
+	HasMore      bool        `json:"has_more"`
 }
 
 const (
@@ -22,6 +27,7 @@ const (
 
 // FavoriteAction 点赞操作
 func FavoriteAction(c *gin.Context) {
+	ctx := c.Request.Context()
 	// 获取操作
 	action := c.Query("action_type")
 	actionType, err := strconv.Atoi(action)
@@ -32,15 +38,22 @@ func FavoriteAction(c *gin.Context) {
 	}
 	userId := util.GetTokenUserId(c)
 	videoId := util.QueryId(c, "video_id")
+	// 无权限查看的视频视为不存在
+	if _, err := cache.ReadVisibleVideo(ctx, userId, videoId); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "视频不存在")
+		return
+	}
 	if actionType == ActionFav {
-		if err := cache.AddFavorite(userId, videoId); err != nil {
+		if err := cache.AddFavorite(ctx, userId, videoId); err != nil {
 			log.Println(err)
 			ResponseFailed(c, "点赞失败")
 		} else {
+			bus.Publish(ctx, bus.VideoFavorited{UserId: userId, VideoId: videoId})
 			ResponseSuccess(c, "点赞成功")
 		}
 	} else if actionType == ActionUnFav {
-		if err := cache.DeleteFavorite(userId, videoId); err != nil {
+		if err := cache.DeleteFavorite(ctx, userId, videoId); err != nil {
 			log.Println(err)
 			ResponseFailed(c, "取消点赞失败")
 		} else {
@@ -52,12 +65,18 @@ func FavoriteAction(c *gin.Context) {
 
 }
 
-// FavoriteList 获取点赞视频列表
+// FavoriteList 按点赞时间倒序分页获取点赞视频列表，cursor、cursor_id 为上一页返回的 next_cursor、next_cursor_id
 // 由于前端无法从点赞列表中查看视频详情，因此无需考虑作者等信息
 func FavoriteList(c *gin.Context) {
+	ctx := c.Request.Context()
 	userAId := util.GetTokenUserId(c)
 	userBId := util.QueryId(c, "user_id")
-	videoList, err := cache.ReadFavoriteList(userAId, userBId)
+	cursor := cache.Cursor{Score: util.QueryId(c, "cursor"), Id: util.QueryId(c, "cursor_id")}
+	count := int(util.QueryId(c, "count"))
+	if count <= 0 || count > config.FavoritePageSize {
+		count = config.FavoritePageSize
+	}
+	videoList, nextCursor, hasMore, err := cache.ReadFavoriteList(ctx, userAId, userBId, cursor, count)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, FavoriteListResponse{
@@ -66,7 +85,10 @@ func FavoriteList(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, FavoriteListResponse{
-		Response:  Response{StatusCode: StatusSuccess},
-		VideoList: videoList,
+		Response:     Response{StatusCode: StatusSuccess},
+		VideoList:    videoList,
+		NextCursor:   nextCursor.Score,
+		NextCursorId: nextCursor.Id,
+		HasMore:      hasMore,
 	})
 }
@@ -19,10 +19,14 @@ type FeedResponse struct {
 // Feed 获取视频流，总体分为三步：获取视频信息（包含作者信息）、获取点赞信息、获取作者关注信息
 // 其中每步还需要先从 Redis 查询，未命中再查询 MySQL
 func Feed(c *gin.Context) {
+	ctx := c.Request.Context()
 	userId := util.GetTokenUserId(c)
 	latestTime := util.QueryId(c, "latest_time")
+	if latestTime == 0 { // 未指定时间则从当前时间开始
+		latestTime = time.Now().Unix()
+	}
 	// 先从 Redis 获取，未命中的部分查找 MySQL
-	videoList, err := cache.ReadFeed(latestTime)
+	videoList, err := cache.ReadFeed(ctx, userId, latestTime)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, FeedResponse{
@@ -33,7 +37,7 @@ func Feed(c *gin.Context) {
 	if userId != 0 { // 用户已登录，则需要进一步查询点赞信息和关注信息
 		for i, video := range videoList {
 			// 是否点过赞
-			videoList[i].IsFavorite, err = cache.ReadFavorite(userId, video.Id)
+			videoList[i].IsFavorite, err = cache.ReadFavorite(ctx, userId, video.Id)
 			if err != nil {
 				log.Println(err)
 				c.JSON(http.StatusOK, FeedResponse{
@@ -42,7 +46,7 @@ func Feed(c *gin.Context) {
 				return
 			}
 			// 是否关注作者
-			videoList[i].Author.IsFollow, err = cache.ReadRelation(userId, video.Author.Id)
+			videoList[i].Author.IsFollow, err = cache.ReadRelation(ctx, userId, video.Author.Id)
 			if err != nil {
 				log.Println(err)
 				c.JSON(http.StatusOK, FeedResponse{
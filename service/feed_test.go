@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 以下为测试用的数据访问实现，只实现视频流用到的方法，其余方法调用时会 panic
+
+type fakeUsers struct {
+	dal.UserRepository
+	users map[int64]dal.User
+}
+
+func (r *fakeUsers) GetById(_ context.Context, id int64) (dal.User, error) {
+	if user, ok := r.users[id]; ok {
+		return user, nil
+	}
+	return dal.User{}, gorm.ErrRecordNotFound
+}
+
+type fakeVideos struct {
+	dal.VideoRepository
+	videos map[int64]dal.Video
+	reads  int // GetById 被调用的次数
+}
+
+func (r *fakeVideos) GetById(_ context.Context, videoId int64) (dal.Video, error) {
+	r.reads++
+	if video, ok := r.videos[videoId]; ok {
+		return video, nil
+	}
+	return dal.Video{}, gorm.ErrRecordNotFound
+}
+
+type fakeFavorites struct {
+	dal.FavoriteRepository
+	favoriteList []dal.Favorite
+}
+
+func (r *fakeFavorites) GetByUserId(_ context.Context, userId int64) ([]dal.Favorite, error) {
+	var favoriteList []dal.Favorite
+	for _, favorite := range r.favoriteList {
+		if favorite.UserId == userId {
+			favoriteList = append(favoriteList, favorite)
+		}
+	}
+	return favoriteList, nil
+}
+
+type fakeRelations struct {
+	dal.RelationRepository
+	follows map[int64][]int64 // 用户关注的用户
+}
+
+func (r *fakeRelations) GetFollowList(_ context.Context, userId int64) ([]int64, error) {
+	return r.follows[userId], nil
+}
+
+func (r *fakeRelations) GetFollowerList(_ context.Context, userId int64) ([]int64, error) {
+	var followerList []int64
+	for followerId, followList := range r.follows {
+		for _, id := range followList {
+			if id == userId {
+				followerList = append(followerList, followerId)
+			}
+		}
+	}
+	return followerList, nil
+}
+
+// setupFeedTest 使用进程内缓存和测试用的数据访问实现，不需要数据库
+// 作者 1 发布了公开、仅粉丝可见和仅自己可见的视频各一个，用户 2 关注了作者并点赞了公开视频
+func setupFeedTest(t *testing.T) *fakeVideos {
+	users, videos, comments, favorites, relations := dal.Users, dal.Videos, dal.Comments, dal.Favorites, dal.Relations
+	t.Cleanup(func() {
+		dal.Users, dal.Videos, dal.Comments, dal.Favorites, dal.Relations = users, videos, comments, favorites, relations
+	})
+	videoRepo := &fakeVideos{videos: map[int64]dal.Video{
+		1: {Id: 1, UserId: 1, Title: "public", Visibility: dal.VisibilityPublic, CreateTime: 100},
+		2: {Id: 2, UserId: 1, Title: "follower", Visibility: dal.VisibilityFollower, CreateTime: 200},
+		3: {Id: 3, UserId: 1, Title: "private", Visibility: dal.VisibilityPrivate, CreateTime: 300},
+	}}
+	dal.Use(dal.Repositories{
+		Users: &fakeUsers{users: map[int64]dal.User{
+			1: {Id: 1, Name: "author", Password: "hash"},
+			2: {Id: 2, Name: "follower", Password: "hash"},
+		}},
+		Videos:    videoRepo,
+		Favorites: &fakeFavorites{favoriteList: []dal.Favorite{{UserId: 2, VideoId: 1, CreatedAt: 1}}},
+		Relations: &fakeRelations{follows: map[int64][]int64{2: {1}}},
+	})
+	store := cache.NewMemoryCache()
+	cache.Use(store)
+	ctx := context.Background()
+	for _, video := range videoRepo.videos {
+		if err := store.ZAdd(ctx, "feed", cache.Z{Score: float64(video.CreateTime), Member: video.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return videoRepo
+}
+
+// requestFeed 以 userId 的身份请求视频流，userId 为 0 时未登录
+func requestFeed(t *testing.T, userId int64) FeedResponse {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/douyin/feed/?latest_time=1000", nil)
+	if userId != 0 {
+		c.Set("token_user_id", userId)
+	}
+	Feed(c)
+	var resp FeedResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != StatusSuccess {
+		t.Fatalf("视频流请求失败：%s", resp.StatusMsg)
+	}
+	// 作者的密码不能出现在响应中
+	if strings.Contains(w.Body.String(), "hash") {
+		t.Fatalf("视频流中包含作者的密码：%s", w.Body.String())
+	}
+	return resp
+}
+
+func feedVideoIds(resp FeedResponse) []int64 {
+	idList := make([]int64, 0, len(resp.VideoList))
+	for _, video := range resp.VideoList {
+		idList = append(idList, video.Id)
+	}
+	return idList
+}
+
+func TestFeedVisibility(t *testing.T) {
+	videoRepo := setupFeedTest(t)
+	for _, test := range []struct {
+		userId int64
+		want   []int64
+	}{
+		{0, []int64{1}},       // 未登录只能看到公开视频
+		{2, []int64{2, 1}},    // 粉丝可以看到仅粉丝可见的视频
+		{3, []int64{1}},       // 未关注的用户只能看到公开视频
+		{1, []int64{3, 2, 1}}, // 作者可以看到自己的所有视频
+	} {
+		if got := feedVideoIds(requestFeed(t, test.userId)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("用户 %d 的视频流为 %v，应为 %v", test.userId, got, test.want)
+		}
+	}
+	// 视频信息只在第一次读取时从数据访问层读取，之后从缓存读取
+	if videoRepo.reads != len(videoRepo.videos) {
+		t.Errorf("读取了 %d 次视频，应为 %d 次", videoRepo.reads, len(videoRepo.videos))
+	}
+}
+
+func TestFeedFavoriteAndFollow(t *testing.T) {
+	setupFeedTest(t)
+	resp := requestFeed(t, 2)
+	if len(resp.VideoList) != 2 {
+		t.Fatalf("视频流为 %v", feedVideoIds(resp))
+	}
+	for _, video := range resp.VideoList {
+		if video.Author.Name != "author" || !video.Author.IsFollow {
+			t.Errorf("视频 %d 的作者为 %+v", video.Id, video.Author)
+		}
+		if video.IsFavorite != (video.Id == 1) {
+			t.Errorf("视频 %d 的点赞状态为 %v", video.Id, video.IsFavorite)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/filter"
+	"log"
+	"strings"
+)
+
+// 需要过滤的内容来源
+const (
+	SceneComment     = "comment"
+	SceneTitle       = "title"
+	SceneDescription = "description"
+	SceneCollection  = "collection"
+	SceneMessage     = "message"
+)
+
+// filterContent 对用户发布的内容进行敏感词过滤，checkSpam 为 true 时同时进行反垃圾检查
+// 返回处理后的内容，内容被拒绝时 ok 为 false，msg 为提示信息
+// 命中规则的内容都会记录下来供人工审核，记录失败不影响发布
+// 进行了反垃圾检查的内容发布成功后，需要以相同的原始内容调用 recordSpam
+func filterContent(ctx context.Context, userId int64, scene, text string, checkSpam bool) (result string, ok bool, msg string) {
+	if checkSpam {
+		spam, err := cache.CheckSpam(ctx, userId, scene, text)
+		if err != nil { // 反垃圾检查失败时放行
+			log.Println(err)
+		} else if spam != cache.SpamNone {
+			addFilterLog(ctx, userId, scene, text, spam, nil)
+			if spam == cache.SpamRate {
+				return "", false, "发布过于频繁，请稍后再试"
+			}
+			return "", false, "请勿重复发布相同内容"
+		}
+	}
+	checkResult := filter.Check(text)
+	if checkResult.Action == filter.ActionPass {
+		return text, true, ""
+	}
+	addFilterLog(ctx, userId, scene, text, checkResult.Action.String(), checkResult.Words)
+	if checkResult.Action == filter.ActionReject {
+		return "", false, "内容包含敏感词"
+	}
+	// ActionMask 返回替换后的内容，ActionReview 返回原内容
+	return checkResult.Text, true, ""
+}
+
+// recordSpam 内容发布成功后记录反垃圾统计，记录失败不影响发布
+func recordSpam(ctx context.Context, userId int64, scene, text string) {
+	if err := cache.RecordSpam(ctx, userId, scene, text); err != nil {
+		log.Println(err)
+	}
+}
+
+func addFilterLog(ctx context.Context, userId int64, scene, text, result string, words []string) {
+	if err := dal.AddFilterLog(ctx, dal.FilterLog{
+		UserId:  userId,
+		Scene:   scene,
+		Content: text,
+		Result:  result,
+		Words:   strings.Join(words, ","),
+	}); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// mentionRegexp 匹配 @username，用户名由字母、数字、下划线、连字符和点组成
+var mentionRegexp = regexp.MustCompile(`@([\p{L}\p{N}_\-.]+)`)
+
+// mentionTrailing 句末的标点，如 "@alice." 中的点，用户名不存在时去掉后再查找
+const mentionTrailing = ".-"
+
+// parseMentions 解析 fromUserId 发布的文本中的 @username 并查找对应用户，返回提及位置
+// 不存在的用户以及拉黑了 fromUserId 的用户保持纯文本，不记录提及位置
+func parseMentions(ctx context.Context, fromUserId int64, text string) ([]dal.MentionSpan, error) {
+	indexList := mentionRegexp.FindAllStringSubmatchIndex(text, -1)
+	if len(indexList) == 0 {
+		return nil, nil
+	}
+	// 同时查找完整的用户名和去掉句末标点后的用户名
+	var names []string
+	for _, index := range indexList {
+		name := text[index[2]:index[3]]
+		names = append(names, name)
+		if trimmed := strings.TrimRight(name, mentionTrailing); trimmed != name && trimmed != "" {
+			names = append(names, trimmed)
+		}
+	}
+	userList, err := dal.Users.GetByNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	userIdMap := make(map[string]int64, len(userList))
+	userIdList := make([]int64, 0, len(userList))
+	for _, user := range userList {
+		userIdMap[user.Name] = user.Id
+		userIdList = append(userIdList, user.Id)
+	}
+	blockerList, err := dal.GetBlockers(ctx, userIdList, fromUserId)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[int64]bool, len(blockerList))
+	for _, blockerId := range blockerList {
+		blocked[blockerId] = true
+	}
+	var spans []dal.MentionSpan
+	for _, index := range indexList {
+		name := text[index[2]:index[3]]
+		userId, ok := userIdMap[name]
+		if !ok {
+			name = strings.TrimRight(name, mentionTrailing)
+			userId, ok = userIdMap[name]
+		}
+		if !ok || blocked[userId] {
+			continue
+		}
+		// 正则返回的是字节下标，转换为字符下标方便前端处理
+		start := utf8.RuneCountInString(text[:index[0]])
+		spans = append(spans, dal.MentionSpan{
+			UserId: userId,
+			Start:  start,
+			End:    start + utf8.RuneCountInString("@"+name),
+		})
+	}
+	return spans, nil
+}
+
+// addMentions 记录提及并通知被提及的用户
+// 由事件总线调用，失败时重新投递，已记录的提及不会重复记录，已通知的提及不会重复通知
+func addMentions(ctx context.Context, fromUserId, videoId, commentId int64, spans []dal.MentionSpan) error {
+	mentionList, err := dal.AddMentions(ctx, fromUserId, videoId, commentId, spans)
+	if err != nil {
+		return err
+	}
+	for _, mention := range mentionList {
+		if err := deliverNotification(ctx, dal.Notification{
+			UserId:        mention.UserId,
+			Type:          dal.NotificationMention,
+			VideoId:       videoId,
+			CommentId:     commentId,
+			LatestActorId: fromUserId,
+		}); err != nil {
+			return err
+		}
+		if err := dal.MarkMentionNotified(ctx, mention.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type MentionListResponse struct {
+	Response
+	MentionList []dal.Mention `json:"mention_list"`
+	NextCursor  int64         `json:"next_cursor"`
+}
+
+// MentionList 分页获取当前用户被 @ 提及的记录，cursor 为上一页返回的 next_cursor
+func MentionList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	cursor := util.QueryId(c, "cursor")
+	mentionList, err := dal.GetMentionList(ctx, userId, cursor, config.CommentPageSize)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, MentionListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取提及列表失败"},
+		})
+		return
+	}
+	for i, mention := range mentionList {
+		mentionList[i].FromUser, err = cache.ReadUser(ctx, mention.FromUserId)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusOK, MentionListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "获取提及列表失败"},
+			})
+			return
+		}
+	}
+	nextCursor := cursor
+	if len(mentionList) > 0 {
+		nextCursor = mentionList[len(mentionList)-1].Id
+	}
+	c.JSON(http.StatusOK, MentionListResponse{
+		Response:    Response{StatusCode: StatusSuccess},
+		MentionList: mentionList,
+		NextCursor:  nextCursor,
+	})
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ActionSendMessage = 1
+)
+
+type MessageListResponse struct {
+	Response
+	MessageList []dal.Message `json:"message_list"`
+}
+
+type ConversationListResponse struct {
+	Response
+	ConversationList []dal.Conversation `json:"conversation_list"`
+	TotalUnread      int64              `json:"total_unread"`
+}
+
+// MessageAction 发送私信，默认只能发送给互相关注的好友
+func MessageAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	if actionType != ActionSendMessage {
+		ResponseFailed(c, "不支持的操作")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	toUserId := util.QueryId(c, "to_user_id")
+	if toUserId == userId {
+		ResponseFailed(c, "不能给自己发送私信")
+		return
+	}
+	if config.MessageFriendOnly {
+		isFriend, err := cache.ReadFriend(ctx, userId, toUserId)
+		if err != nil {
+			log.Println(err)
+			ResponseFailed(c, "发送失败")
+			return
+		}
+		if !isFriend {
+			ResponseFailed(c, "只能给互相关注的好友发送私信")
+			return
+		}
+	}
+	content := strings.TrimSpace(c.Query("content"))
+	if content == "" || utf8.RuneCountInString(content) > config.MaxMessageLen {
+		ResponseFailed(c, "私信长度无效")
+		return
+	}
+	// 私信发送频繁，只过滤敏感词，不做频率和重复检查
+	content, ok, msg := filterContent(ctx, userId, SceneMessage, content, false)
+	if !ok {
+		ResponseFailed(c, msg)
+		return
+	}
+	message, err := cache.AddMessage(ctx, dal.Message{
+		FromUserId: userId,
+		ToUserId:   toUserId,
+		Content:    content,
+	})
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "发送失败")
+		return
+	}
+	bus.Publish(ctx, bus.MessageSent{MessageId: message.Id, FromUserId: userId, ToUserId: toUserId})
+	ResponseSuccess(c, "发送成功")
+}
+
+// MessageChat 获取与 to_user_id 的聊天记录，按发送时间正序排列，并将会话标记为已读
+// pre_msg_time 为客户端已有的最新私信的时间，只返回其后的私信，为 0 时返回最新的私信
+func MessageChat(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	toUserId := util.QueryId(c, "to_user_id")
+	preMsgTime := util.QueryId(c, "pre_msg_time")
+	messageList, err := cache.ReadMessageList(ctx, userId, toUserId, preMsgTime, config.MessagePageSize)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, MessageListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取聊天记录失败"},
+		})
+		return
+	}
+	// 标记已读失败不影响读取聊天记录
+	if cleared, err := cache.ClearUnread(ctx, userId, toUserId); err != nil {
+		log.Println(err)
+	} else if cleared {
+		bus.Publish(ctx, bus.ConversationRead{UserId: userId, PeerId: toUserId})
+	}
+	c.JSON(http.StatusOK, MessageListResponse{
+		Response:    Response{StatusCode: StatusSuccess},
+		MessageList: messageList,
+	})
+}
+
+// ConversationList 获取会话列表，包括聊天对象、最后一条私信的预览、每个会话的未读数和总未读数
+func ConversationList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	conversationList, err := cache.ReadConversationList(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, ConversationListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取会话列表失败"},
+		})
+		return
+	}
+	var totalUnread int64
+	for _, conversation := range conversationList {
+		totalUnread += conversation.UnreadCount
+	}
+	c.JSON(http.StatusOK, ConversationListResponse{
+		Response:         Response{StatusCode: StatusSuccess},
+		ConversationList: conversationList,
+		TotalUnread:      totalUnread,
+	})
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// deliverNotification 检查接收者的通知开关，写入通知并实时推送
+// 点赞和评论只需要填写 VideoId，接收者为视频作者，视频已被彻底删除时不再通知
+func deliverNotification(ctx context.Context, notification dal.Notification) error {
+	if notification.Type == dal.NotificationLike || notification.Type == dal.NotificationComment {
+		video, err := cache.ReadVideo(ctx, notification.VideoId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		notification.UserId = video.UserId
+	}
+	// 不通知自己的操作
+	if notification.UserId == notification.LatestActorId {
+		return nil
+	}
+	setting, err := cache.ReadNotificationSetting(ctx, notification.UserId)
+	if err != nil {
+		return err
+	}
+	if !setting.Enabled(notification.Type) {
+		return nil
+	}
+	notification, changed, err := cache.AddNotification(ctx, notification)
+	if err != nil || !changed {
+		return err
+	}
+	if err := fillNotification(ctx, notification.UserId, &notification); err != nil {
+		return err
+	}
+	pushEvent(ctx, notification.UserId, EventNotification, notification)
+	pushUnreadCount(ctx, notification.UserId)
+	return nil
+}
+
+// fillNotification 读取通知最近的操作者以及相关视频，视频已删除或无权限查看时不返回视频
+func fillNotification(ctx context.Context, userId int64, notification *dal.Notification) error {
+	actorIdList, err := dal.GetNotificationActorIds(ctx, notification.Id, config.NotificationActorPreview)
+	if err != nil {
+		return err
+	}
+	notification.Actors = make([]dal.User, 0, len(actorIdList))
+	for _, actorId := range actorIdList {
+		actor, err := cache.ReadUser(ctx, actorId)
+		if err != nil {
+			return err
+		}
+		notification.Actors = append(notification.Actors, actor)
+	}
+	if notification.VideoId > 0 {
+		if video, err := cache.ReadVisibleVideo(ctx, userId, notification.VideoId); err == nil {
+			notification.Video = &video
+		}
+	}
+	return nil
+}
+
+type NotificationListResponse struct {
+	Response
+	NotificationList []dal.Notification `json:"notification_list"`
+	UnreadCount      int64              `json:"unread_count"`
+	NextCursor       int64              `json:"next_cursor"`
+	NextCursorId     int64              `json:"next_cursor_id"`
+	HasMore          bool               `json:"has_more"`
+}
+
+// NotificationList 按最后更新时间倒序分页获取通知，type 为空时获取所有类型
+// cursor、cursor_id 为上一页返回的 next_cursor、next_cursor_id，首页为 0
+func NotificationList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	notificationType := c.Query("type")
+	if notificationType != "" && !dal.ValidNotificationType(notificationType) {
+		c.JSON(http.StatusOK, NotificationListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "不支持的通知类型"},
+		})
+		return
+	}
+	cursor := util.QueryId(c, "cursor")
+	cursorId := util.QueryId(c, "cursor_id")
+	// 多读一条用于判断是否还有下一页
+	notificationList, err := dal.GetNotificationList(ctx, userId, notificationType, cursor, cursorId, config.NotificationPageSize+1)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, NotificationListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取通知失败"},
+		})
+		return
+	}
+	hasMore := len(notificationList) > config.NotificationPageSize
+	if hasMore {
+		notificationList = notificationList[:config.NotificationPageSize]
+	}
+	for i := range notificationList {
+		if err := fillNotification(ctx, userId, &notificationList[i]); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusOK, NotificationListResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "获取通知失败"},
+			})
+			return
+		}
+	}
+	unreadCount, err := cache.ReadUnreadNotificationCount(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, NotificationListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取通知失败"},
+		})
+		return
+	}
+	nextCursor, nextCursorId := cursor, cursorId
+	if len(notificationList) > 0 {
+		last := notificationList[len(notificationList)-1]
+		nextCursor, nextCursorId = last.UpdatedAt, last.Id
+	}
+	c.JSON(http.StatusOK, NotificationListResponse{
+		Response:         Response{StatusCode: StatusSuccess},
+		NotificationList: notificationList,
+		UnreadCount:      unreadCount,
+		NextCursor:       nextCursor,
+		NextCursorId:     nextCursorId,
+		HasMore:          hasMore,
+	})
+}
+
+// NotificationRead 将通知标记为已读，notification_id 为空时标记所有通知
+func NotificationRead(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	notificationId := util.QueryId(c, "notification_id")
+	if err := cache.ReadNotifications(ctx, userId, notificationId); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作失败")
+		return
+	}
+	bus.Publish(ctx, bus.NotificationsRead{UserId: userId})
+	ResponseSuccess(c, "操作成功")
+}
+
+type NotificationSettingResponse struct {
+	Response
+	Setting dal.NotificationSetting `json:"setting"`
+}
+
+// NotificationSetting 获取当前用户的通知开关
+func NotificationSetting(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	setting, err := cache.ReadNotificationSetting(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, NotificationSettingResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取通知设置失败"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, NotificationSettingResponse{
+		Response: Response{StatusCode: StatusSuccess},
+		Setting:  setting,
+	})
+}
+
+// EditNotificationSetting 修改通知开关，like、comment、follow、mention 为 1 时开启，为 0 时关闭，未传入的保持不变
+func EditNotificationSetting(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	setting, err := cache.ReadNotificationSetting(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "修改失败")
+		return
+	}
+	for field, enabled := range map[string]*bool{
+		dal.NotificationLike:    &setting.Like,
+		dal.NotificationComment: &setting.Comment,
+		dal.NotificationFollow:  &setting.Follow,
+		dal.NotificationMention: &setting.Mention,
+	} {
+		switch c.Query(field) {
+		case "":
+		case "0":
+			*enabled = false
+		case "1":
+			*enabled = true
+		default:
+			ResponseFailed(c, "无效的通知设置")
+			return
+		}
+	}
+	if err := cache.EditNotificationSetting(ctx, setting); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "修改失败")
+		return
+	}
+	ResponseSuccess(c, "修改成功")
+}
@@ -1,13 +1,19 @@
 package service
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
 	"github.com/zenpk/mini-douyin-ex/cache"
 	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/media"
 	"github.com/zenpk/mini-douyin-ex/util"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type VideoListResponse struct {
@@ -17,10 +23,48 @@ type VideoListResponse struct {
 
 // Publish 前端传入视频、token
 func Publish(c *gin.Context) {
+	ctx := c.Request.Context()
 	// 上传者 id
 	userId := util.GetTokenUserId(c)
-	// 视频标题
+	// 视频标题和简介
 	title := c.PostForm("title")
+	description := c.PostForm("description")
+	// 过滤标题和简介中的敏感词，标题同时进行反垃圾检查
+	rawTitle := title
+	title, ok, msg := filterContent(ctx, userId, SceneTitle, title, true)
+	if !ok {
+		ResponseFailed(c, msg)
+		return
+	}
+	description, ok, msg = filterContent(ctx, userId, SceneDescription, description, false)
+	if !ok {
+		ResponseFailed(c, msg)
+		return
+	}
+	// 可见范围，默认所有人可见
+	visibility := int32(util.QueryId(c, "visibility"))
+	if !dal.ValidVisibility(visibility) {
+		ResponseFailed(c, "不支持的可见范围")
+		return
+	}
+	// 发布状态，默认立即发布，也可以保存为草稿或定时发布
+	status := int32(util.QueryId(c, "status"))
+	releaseTime := util.QueryId(c, "release_time")
+	if status == dal.StatusScheduled && releaseTime <= time.Now().Unix() {
+		ResponseFailed(c, "定时发布时间无效")
+		return
+	} else if status != dal.StatusPublished && status != dal.StatusDraft && status != dal.StatusScheduled {
+		ResponseFailed(c, "不支持的发布状态")
+		return
+	}
+	if status != dal.StatusScheduled {
+		releaseTime = 0
+	}
+	// 解析标题中的 @ 提及，失败时按纯文本处理
+	spans, err := parseMentions(ctx, userId, title)
+	if err != nil {
+		log.Println(err)
+	}
 	// 读取视频
 	data, err := c.FormFile("data")
 	if err != nil {
@@ -28,24 +72,176 @@ func Publish(c *gin.Context) {
 		ResponseFailed(c, "读取视频失败")
 		return
 	}
-	filename := filepath.Base(data.Filename)
-	if video, err := dal.Publish(c, userId, title, filename, data); err != nil {
+	video := dal.Video{
+		UserId:      userId,
+		Title:       title,
+		MentionData: dal.EncodeMentions(spans),
+		Description: description,
+		Visibility:  visibility,
+		Status:      status,
+		ReleaseTime: releaseTime,
+	}
+	if video, err := publishVideo(ctx, video, data); err != nil {
 		log.Println(err)
 		ResponseFailed(c, "上传失败")
 	} else {
+		recordSpam(ctx, userId, SceneTitle, rawTitle)
+		// 关联标题中的话题，需要在写入 Redis 之前完成，以便加入话题视频列表
+		setVideoTopics(ctx, video, title)
 		// 将视频写入 Redis，如果失败也不需要回滚，下次重新读取即可
-		if err := cache.AddVideo(video); err != nil {
+		if err := cache.AddVideo(ctx, video); err != nil {
 			log.Println(err)
 		}
+		// 草稿和定时发布的视频在发布时再通知被提及的用户、加入搜索索引
+		if video.Status == dal.StatusPublished {
+			bus.Publish(ctx, bus.VideoPublished{VideoId: video.Id, UserId: userId})
+		}
 		ResponseSuccess(c, "上传成功")
 	}
 }
 
+// publishVideo 保存视频信息，将视频存入本地并生成封面，最后返回视频方便加入缓存
+// 因为存储的文件名需要包含 videoId，所以先保存到数据库，利用 Id 自增特性获取 videoId
+func publishVideo(ctx context.Context, video dal.Video, data *multipart.FileHeader) (dal.Video, error) {
+	video, err := dal.Videos.Create(ctx, video)
+	if err != nil {
+		return dal.Video{}, err
+	}
+	name := media.FileName(video.UserId, video.Id, data.Filename)
+	if err := media.SaveVideo(data, name); err != nil {
+		return dal.Video{}, err
+	}
+	if err := media.GenerateCover(ctx, name); err != nil {
+		return dal.Video{}, err
+	}
+	// 更新数据库中的视频和封面链接
+	video.PlayUrl = media.PlayUrl(name)
+	video.CoverUrl = media.CoverUrl(name)
+	if err := dal.Videos.SetUrls(ctx, video.Id, video.PlayUrl, video.CoverUrl); err != nil {
+		return dal.Video{}, err
+	}
+	return video, nil
+}
+
+const (
+	ActionDeleteVideo  = 1
+	ActionRestoreVideo = 2
+)
+
+// EditVideo 修改视频标题、简介和可见范围，未传入的字段保持不变
+func EditVideo(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	video, err := cache.ReadVideo(ctx, videoId)
+	if err != nil || video.UserId != userId {
+		log.Println(err)
+		ResponseFailed(c, "无法修改视频")
+		return
+	}
+	title, mentionData, description, visibility := video.Title, video.MentionData, video.Description, video.Visibility
+	if value, ok := c.GetPostForm("title"); ok {
+		if strings.TrimSpace(value) == "" {
+			ResponseFailed(c, "标题不能为空")
+			return
+		}
+		// 过滤标题中的敏感词
+		value, ok, msg := filterContent(ctx, userId, SceneTitle, value, false)
+		if !ok {
+			ResponseFailed(c, msg)
+			return
+		}
+		// 修改时只更新提及位置，不再重复通知
+		spans, err := parseMentions(ctx, userId, value)
+		if err != nil {
+			log.Println(err)
+		}
+		title, mentionData = value, dal.EncodeMentions(spans)
+	}
+	if value, ok := c.GetPostForm("description"); ok {
+		// 过滤简介中的敏感词
+		value, ok, msg := filterContent(ctx, userId, SceneDescription, value, false)
+		if !ok {
+			ResponseFailed(c, msg)
+			return
+		}
+		description = value
+	}
+	if c.Query("visibility") != "" || c.PostForm("visibility") != "" {
+		visibility = int32(util.QueryId(c, "visibility"))
+		if !dal.ValidVisibility(visibility) {
+			ResponseFailed(c, "不支持的可见范围")
+			return
+		}
+	}
+	if err := cache.EditVideo(ctx, userId, videoId, title, mentionData, description, visibility); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "修改失败")
+	} else {
+		// 按新标题重新关联话题
+		video.Title, video.Description, video.Visibility = title, description, visibility
+		setVideoTopics(ctx, video, title)
+		bus.Publish(ctx, bus.VideoUpdated{VideoId: videoId, UserId: userId})
+		ResponseSuccess(c, "修改成功")
+	}
+}
+
+// DeleteVideo 删除视频、恢复已删除的视频
+func DeleteVideo(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	if actionType == ActionDeleteVideo {
+		if err := cache.RemoveVideo(ctx, userId, videoId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "删除失败")
+		} else {
+			bus.Publish(ctx, bus.VideoDeleted{VideoId: videoId, UserId: userId})
+			ResponseSuccess(c, "删除成功")
+		}
+	} else if actionType == ActionRestoreVideo {
+		if err := cache.RestoreVideo(ctx, userId, videoId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "恢复失败")
+		} else {
+			bus.Publish(ctx, bus.VideoUpdated{VideoId: videoId, UserId: userId})
+			ResponseSuccess(c, "恢复成功")
+		}
+	} else {
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
+// ReleaseVideo 发布草稿或修改定时发布时间，release_time 为空或早于当前时间时立即发布
+func ReleaseVideo(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	releaseTime := util.QueryId(c, "release_time")
+	if video, err := cache.ReleaseVideo(ctx, userId, videoId, releaseTime); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "发布失败")
+	} else {
+		if video.Status == dal.StatusPublished {
+			bus.Publish(ctx, bus.VideoPublished{VideoId: video.Id, UserId: userId})
+		}
+		ResponseSuccess(c, "发布成功")
+	}
+}
+
 // PublishList 获取当前用户的视频列表
 func PublishList(c *gin.Context) {
+	ctx := c.Request.Context()
 	userAId := util.GetTokenUserId(c)
 	userBId := util.QueryUserId(c)
-	if videoList, err := cache.ReadPublishList(userAId, userBId); err != nil {
+	if videoList, err := cache.ReadPublishList(ctx, userAId, userBId); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, VideoListResponse{
 			Response: Response{
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 实时推送的事件类型
+const (
+	EventMessage      = "message"      // 新私信
+	EventNotification = "notification" // 新通知
+	EventCounter      = "counter"      // 未读数变化
+	EventResync       = "resync"       // 断线期间的事件无法完整补发，客户端需要重新拉取数据
+)
+
+type UnreadCounter struct {
+	UnreadMessageCount      int64 `json:"unread_message_count"`
+	UnreadNotificationCount int64 `json:"unread_notification_count"`
+}
+
+// pushClient 一个 WebSocket 连接，同一用户可以有多个连接
+type pushClient struct {
+	userId int64
+	send   chan cache.Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// close 通知写协程关闭连接，可以重复调用
+func (client *pushClient) close() {
+	client.once.Do(func() { close(client.done) })
+}
+
+// pushHub 管理本实例上的所有连接，每个实例只订阅有连接的用户的事件
+type pushHub struct {
+	mu      sync.Mutex
+	clients map[int64]map[*pushClient]bool
+	sub     *cache.EventSubscription
+}
+
+var hub = &pushHub{clients: make(map[int64]map[*pushClient]bool)}
+
+// RunPushHub 订阅 Redis 中的事件并分发给本实例上的连接
+// 事件由任意实例发布，通过 pub/sub 到达用户所连接的实例
+func RunPushHub() {
+	hub.sub = cache.SubscribeEvents(context.Background())
+	go func() {
+		for event := range hub.sub.Events() {
+			hub.dispatch(event)
+		}
+	}()
+}
+
+// register 加入连接，用户在本实例上的第一个连接建立时订阅其事件
+func (h *pushHub) register(ctx context.Context, client *pushClient) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients[client.userId]) == 0 {
+		if err := h.sub.Subscribe(ctx, client.userId); err != nil {
+			return err
+		}
+		h.clients[client.userId] = make(map[*pushClient]bool)
+	}
+	h.clients[client.userId][client] = true
+	return nil
+}
+
+// unregister 移除连接，用户在本实例上的最后一个连接断开时取消订阅
+// 连接断开时请求的 ctx 可能已经取消，因此不使用请求的 ctx
+func (h *pushHub) unregister(client *pushClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients[client.userId], client)
+	if len(h.clients[client.userId]) > 0 {
+		return
+	}
+	delete(h.clients, client.userId)
+	if err := h.sub.Unsubscribe(context.Background(), client.userId); err != nil {
+		log.Println(err)
+	}
+}
+
+// dispatch 将事件发给用户的所有连接
+// 发送缓冲区已满说明客户端处理太慢，直接断开连接，客户端重连时从最后收到的事件处补发
+func (h *pushHub) dispatch(event cache.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[event.UserId] {
+		select {
+		case client.send <- event:
+		default:
+			client.close()
+		}
+	}
+}
+
+// pushEvent 向用户的所有连接推送事件，失败不影响原操作
+func pushEvent(ctx context.Context, userId int64, eventType string, data interface{}) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := cache.PublishEvent(ctx, userId, eventType, bytes); err != nil {
+		log.Println(err)
+	}
+}
+
+// pushUnreadCount 推送用户最新的私信总未读数和未读通知数
+func pushUnreadCount(ctx context.Context, userId int64) {
+	totalUnread, err := dal.GetTotalUnread(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	unreadNotification, err := cache.ReadUnreadNotificationCount(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	pushEvent(ctx, userId, EventCounter, UnreadCounter{
+		UnreadMessageCount:      totalUnread,
+		UnreadNotificationCount: unreadNotification,
+	})
+}
+
+var upgrader = websocket.Upgrader{
+	// 客户端通过 token 鉴权，不依赖 cookie，因此允许跨域连接
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Push 建立 WebSocket 连接，实时推送私信、通知和未读数变化
+// 重连时通过 last_event_id 参数或 Last-Event-ID 请求头传入最后收到的事件 id，补发断线期间的事件
+func Push(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	lastEventId := c.Query("last_event_id")
+	if lastEventId == "" {
+		lastEventId = c.GetHeader("Last-Event-ID")
+	}
+	if lastEventId != "" && !cache.ValidEventId(lastEventId) {
+		ResponseFailed(c, "无效的事件 id")
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil { // Upgrade 失败时已经返回了错误响应
+		log.Println(err)
+		return
+	}
+	client := &pushClient{
+		userId: userId,
+		send:   make(chan cache.Event, config.PushSendBuffer),
+		done:   make(chan struct{}),
+	}
+	// 先订阅再补发，保证补发和实时推送之间不会漏掉事件，重复的事件在写入时跳过
+	if err := hub.register(ctx, client); err != nil {
+		log.Println(err)
+		conn.Close()
+		return
+	}
+	defer hub.unregister(client)
+	var replay []cache.Event
+	resync := false
+	if lastEventId != "" {
+		eventList, complete, err := cache.ReadEventsAfter(ctx, userId, lastEventId, config.EventReplaySize)
+		if err != nil {
+			log.Println(err)
+		}
+		replay = eventList
+		// 读取失败、事件已被裁剪或超过补发上限时，都需要客户端重新拉取
+		resync = err != nil || !complete || len(eventList) >= config.EventReplaySize
+	}
+	go readPump(conn, client)
+	writePump(conn, client, lastEventId, replay, resync)
+}
+
+// readPump 读取客户端的消息以处理 pong，超过 PushPongWait 没有收到任何消息时认为连接已断开
+func readPump(conn *websocket.Conn, client *pushClient) {
+	defer client.close()
+	conn.SetReadLimit(512)
+	extend := func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(config.PushPongWait))
+	}
+	if err := extend(""); err != nil {
+		return
+	}
+	conn.SetPongHandler(extend)
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if err := extend(""); err != nil {
+			return
+		}
+	}
+}
+
+// writePump 依次写入补发的事件和实时事件，并定时发送 ping
+// 所有写操作都在这个协程中进行，跳过 id 不晚于已发送事件的重复事件
+func writePump(conn *websocket.Conn, client *pushClient, lastEventId string, replay []cache.Event, resync bool) {
+	ticker := time.NewTicker(config.PushPingCycle)
+	defer func() {
+		ticker.Stop()
+		client.close()
+		conn.Close()
+	}()
+	write := func(event cache.Event) error {
+		if event.Id != "" {
+			if lastEventId != "" && cache.CompareEventId(event.Id, lastEventId) <= 0 {
+				return nil
+			}
+			lastEventId = event.Id
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(config.PushWriteWait)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(event)
+	}
+	if resync {
+		if err := write(cache.Event{Type: EventResync}); err != nil {
+			return
+		}
+	}
+	for _, event := range replay {
+		if err := write(event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case event := <-client.send:
+			if err := write(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(config.PushWriteWait)); err != nil {
+				return
+			}
+		case <-client.done:
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(config.PushWriteWait))
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
 	"github.com/zenpk/mini-douyin-ex/cache"
 	"github.com/zenpk/mini-douyin-ex/dal"
 	"github.com/zenpk/mini-douyin-ex/util"
@@ -22,6 +23,7 @@ const (
 
 // RelationAction 关注取关操作
 func RelationAction(c *gin.Context) {
+	ctx := c.Request.Context()
 	actionStr := c.Query("action_type")
 	action, err := strconv.Atoi(actionStr)
 	if err != nil {
@@ -32,7 +34,7 @@ func RelationAction(c *gin.Context) {
 	userId := util.GetTokenUserId(c)
 	toUserId := util.QueryId(c, "to_user_id")
 	// 先检查是否已关注
-	isFollow, err := cache.ReadRelation(userId, toUserId)
+	isFollow, err := cache.ReadRelation(ctx, userId, toUserId)
 	if err != nil {
 		log.Println(err)
 		ResponseFailed(c, "操作出错")
@@ -41,16 +43,17 @@ func RelationAction(c *gin.Context) {
 	if action == ActionFollow {
 		if isFollow {
 			ResponseFailed(c, "已关注过该用户")
-		} else if err := cache.AddFollow(userId, toUserId); err != nil {
+		} else if err := cache.AddFollow(ctx, userId, toUserId); err != nil {
 			log.Println(err)
 			ResponseFailed(c, "关注失败")
 		} else {
+			bus.Publish(ctx, bus.UserFollowed{UserId: userId, ToUserId: toUserId})
 			ResponseSuccess(c, "关注成功")
 		}
 	} else if action == ActionUnfollow {
 		if !isFollow {
 			ResponseFailed(c, "未关注过该用户")
-		} else if err := cache.DeleteFollow(userId, toUserId); err != nil {
+		} else if err := cache.DeleteFollow(ctx, userId, toUserId); err != nil {
 			log.Println(err)
 			ResponseFailed(c, "取消关注失败")
 		} else {
@@ -61,12 +64,56 @@ func RelationAction(c *gin.Context) {
 	}
 }
 
+const (
+	ActionBlock   = 1
+	ActionUnblock = 2
+)
+
+// BlockAction 拉黑、取消拉黑，被拉黑的用户无法 @ 提及当前用户
+func BlockAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	actionStr := c.Query("action_type")
+	action, err := strconv.Atoi(actionStr)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	userId := util.GetTokenUserId(c)
+	toUserId := util.QueryId(c, "to_user_id")
+	if toUserId == userId {
+		ResponseFailed(c, "不能拉黑自己")
+		return
+	}
+	if action == ActionBlock {
+		if _, err := cache.ReadUser(ctx, toUserId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "用户不存在")
+		} else if err := dal.AddBlock(ctx, userId, toUserId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "拉黑失败")
+		} else {
+			ResponseSuccess(c, "拉黑成功")
+		}
+	} else if action == ActionUnblock {
+		if err := dal.DeleteBlock(ctx, userId, toUserId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "取消拉黑失败")
+		} else {
+			ResponseSuccess(c, "取消拉黑成功")
+		}
+	} else {
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
 // FollowList 展示查询用户的关注列表
 func FollowList(c *gin.Context) {
+	ctx := c.Request.Context()
 	userAId := util.GetTokenUserId(c)
 	userBId := util.QueryUserId(c)
 	// 先查 Redis，未命中再查数据库，查询过程中应更新 isFollow 信息
-	if followList, err := cache.ReadFollow(userAId, userBId); err != nil {
+	if followList, err := cache.ReadFollow(ctx, userAId, userBId); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, UserListResponse{
 			Response: Response{StatusCode: StatusFailed, StatusMsg: "查询失败"},
@@ -81,10 +128,11 @@ func FollowList(c *gin.Context) {
 
 // FollowerList 展示查询用户的粉丝列表
 func FollowerList(c *gin.Context) {
+	ctx := c.Request.Context()
 	userAId := util.GetTokenUserId(c)
 	userBId := util.QueryUserId(c)
 	// 先查 Redis，未命中再查数据库，查询过程中应更新 isFollow 信息
-	if followList, err := cache.ReadFollower(userAId, userBId); err != nil {
+	if followList, err := cache.ReadFollower(ctx, userAId, userBId); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, UserListResponse{
 			Response: Response{StatusCode: StatusFailed, StatusMsg: "查询失败"},
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/bus"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/media"
+	"log"
+	"time"
+)
+
+// RunScheduler 在后台启动定时任务
+// 定时发布的状态保存在 MySQL 中，服务重启后第一次执行即可补发错过的视频
+func RunScheduler() {
+	go runEvery(config.ReleaseCycle, func(ctx context.Context) error {
+		videoList, err := cache.ReleaseScheduledVideos(ctx)
+		for _, video := range videoList {
+			bus.Publish(ctx, bus.VideoPublished{VideoId: video.Id, UserId: video.UserId})
+		}
+		return err
+	})
+	// 将发件箱中已提交的变化同步到 Redis
+	go runEvery(config.OutboxRelayCycle, cache.RelayOutbox)
+	// 发送第三方回调
+	go runEvery(config.WebhookCycle, sendWebhooks)
+	go runEvery(config.PlayFlushCycle, cache.FlushPlayCounts)
+	go runEvery(config.VideoPurgeCycle, purgeVideos)
+}
+
+// runEvery 立即执行一次任务，之后每隔 cycle 执行一次
+func runEvery(cycle time.Duration, task func(ctx context.Context) error) {
+	ctx := context.Background()
+	ticker := time.NewTicker(cycle)
+	defer ticker.Stop()
+	for {
+		if err := task(ctx); err != nil {
+			log.Println(err)
+		}
+		<-ticker.C
+	}
+}
+
+// purgeVideos 彻底删除超出恢复期限的视频，并删除其本地文件
+func purgeVideos(ctx context.Context) error {
+	videoList, err := dal.Videos.Purge(ctx)
+	for _, video := range videoList {
+		if err := media.Remove(video.PlayUrl); err != nil {
+			log.Println(err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/search"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"gorm.io/gorm"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// indexVideo 已发布的视频按标题加入搜索索引，草稿和定时发布的视频在发布时再加入
+func indexVideo(video dal.Video) {
+	if video.Status == dal.StatusPublished {
+		search.Index(search.TypeVideo, video.Id, video.Title)
+	}
+}
+
+// blendScore 结合文本相关度和热度计算排序得分
+func blendScore(relevance float64, popularity int64) float64 {
+	if popularity < 0 {
+		popularity = 0
+	}
+	return relevance * (1 + config.SearchPopularityWeight*math.Log1p(float64(popularity)))
+}
+
+type SearchResponse struct {
+	Response
+	VideoList  []dal.Video `json:"video_list,omitempty"`
+	UserList   []dal.User  `json:"user_list,omitempty"`
+	TopicList  []dal.Topic `json:"topic_list,omitempty"`
+	NextOffset int64       `json:"next_offset"`
+	HasMore    bool        `json:"has_more"`
+}
+
+// Search 搜索视频、用户或话题，type 为 video（默认）、user 或 topic
+// 先按文本相关度取出候选结果，再结合点赞、评论、播放量或粉丝数等热度重新排序后分页
+func Search(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	keyword := c.Query("keyword")
+	searchType := c.Query("type")
+	offset := int(util.QueryId(c, "offset"))
+	count := int(util.QueryId(c, "count"))
+	if count <= 0 || count > config.SearchPageSize {
+		count = config.SearchPageSize
+	}
+	var resp SearchResponse
+	var total int
+	var err error
+	switch searchType {
+	case search.TypeUser:
+		resp.UserList, err = searchUsers(ctx, userId, keyword)
+		total = len(resp.UserList)
+		start, end := pageRange(total, offset, count)
+		resp.UserList = resp.UserList[start:end]
+	case search.TypeTopic:
+		resp.TopicList, err = searchTopics(ctx, keyword)
+		total = len(resp.TopicList)
+		start, end := pageRange(total, offset, count)
+		resp.TopicList = resp.TopicList[start:end]
+	default:
+		resp.VideoList, err = searchVideos(ctx, userId, keyword)
+		total = len(resp.VideoList)
+		start, end := pageRange(total, offset, count)
+		resp.VideoList = resp.VideoList[start:end]
+		// 只为当前页查询点赞信息和关注信息
+		if err == nil && userId != 0 {
+			for i, video := range resp.VideoList {
+				resp.VideoList[i].IsFavorite, err = cache.ReadFavorite(ctx, userId, video.Id)
+				if err == nil {
+					resp.VideoList[i].Author.IsFollow, err = cache.ReadRelation(ctx, userId, video.UserId)
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, SearchResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "搜索失败"},
+		})
+		return
+	}
+	_, end := pageRange(total, offset, count)
+	resp.Response = Response{StatusCode: StatusSuccess}
+	resp.NextOffset = int64(end)
+	resp.HasMore = end < total
+	c.JSON(http.StatusOK, resp)
+}
+
+// pageRange 计算分页在结果中的起止下标，左闭右开
+func pageRange(total, offset, count int) (int, int) {
+	start := offset
+	if start < 0 {
+		start = 0
+	} else if start > total {
+		start = total
+	}
+	end := start + count
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// searchVideos 搜索当前用户有权限查看的视频，索引中已删除的视频会被跳过
+func searchVideos(ctx context.Context, userId int64, keyword string) ([]dal.Video, error) {
+	hitList := search.Search(search.TypeVideo, keyword, config.SearchCandidateSize)
+	videoList := make([]dal.Video, 0, len(hitList))
+	scoreMap := make(map[int64]float64, len(hitList))
+	for _, hit := range hitList {
+		video, err := cache.ReadVisibleVideo(ctx, userId, hit.Id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return []dal.Video{}, err
+		}
+		// 播放量远大于点赞和评论数，因此只计入十分之一
+		scoreMap[video.Id] = blendScore(hit.Score, video.FavoriteCount+video.CommentCount+video.PlayCount/10)
+		videoList = append(videoList, video)
+	}
+	sort.SliceStable(videoList, func(i, j int) bool {
+		return scoreMap[videoList[i].Id] > scoreMap[videoList[j].Id]
+	})
+	return videoList, nil
+}
+
+// searchUsers 搜索用户，热度为粉丝数
+func searchUsers(ctx context.Context, userId int64, keyword string) ([]dal.User, error) {
+	hitList := search.Search(search.TypeUser, keyword, config.SearchCandidateSize)
+	userList := make([]dal.User, 0, len(hitList))
+	scoreMap := make(map[int64]float64, len(hitList))
+	for _, hit := range hitList {
+		user, err := cache.ReadUser(ctx, hit.Id)
+		if err != nil {
+			return []dal.User{}, err
+		}
+		if user.Id == 0 { // 用户不存在
+			continue
+		}
+		if userId != 0 {
+			user.IsFollow, err = cache.ReadRelation(ctx, userId, user.Id)
+			if err != nil {
+				return []dal.User{}, err
+			}
+		}
+		scoreMap[user.Id] = blendScore(hit.Score, user.FollowerCount)
+		userList = append(userList, user)
+	}
+	sort.SliceStable(userList, func(i, j int) bool {
+		return scoreMap[userList[i].Id] > scoreMap[userList[j].Id]
+	})
+	return userList, nil
+}
+
+// searchTopics 搜索话题，热度为视频数
+func searchTopics(ctx context.Context, keyword string) ([]dal.Topic, error) {
+	hitList := search.Search(search.TypeTopic, keyword, config.SearchCandidateSize)
+	topicIdList := make([]int64, len(hitList))
+	relevanceMap := make(map[int64]float64, len(hitList))
+	for i, hit := range hitList {
+		topicIdList[i] = hit.Id
+		relevanceMap[hit.Id] = hit.Score
+	}
+	topicList, err := dal.GetTopicsByIds(ctx, topicIdList)
+	if err != nil {
+		return []dal.Topic{}, err
+	}
+	sort.SliceStable(topicList, func(i, j int) bool {
+		return blendScore(relevanceMap[topicList[i].Id], topicList[i].VideoCount) >
+			blendScore(relevanceMap[topicList[j].Id], topicList[j].VideoCount)
+	})
+	return topicList, nil
+}
+
+type SuggestResponse struct {
+	Response
+	SuggestList []search.Hit `json:"suggest_list"`
+}
+
+// Suggest 搜索框输入时的前缀补全，返回匹配的视频标题、用户名和话题名
+// 多取一些候选结果，以便过滤掉当前用户无权限查看的视频后仍有足够的补全
+func Suggest(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	hitList := search.Suggest(c.Query("prefix"), 2*config.SuggestSize)
+	suggestList := make([]search.Hit, 0, config.SuggestSize)
+	for _, hit := range hitList {
+		if len(suggestList) >= config.SuggestSize {
+			break
+		}
+		if hit.Type == search.TypeVideo {
+			if _, err := cache.ReadVisibleVideo(ctx, userId, hit.Id); errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			} else if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusOK, SuggestResponse{
+					Response: Response{StatusCode: StatusFailed, StatusMsg: "获取搜索补全失败"},
+				})
+				return
+			}
+		}
+		suggestList = append(suggestList, hit)
+	}
+	c.JSON(http.StatusOK, SuggestResponse{
+		Response:    Response{StatusCode: StatusSuccess},
+		SuggestList: suggestList,
+	})
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/bus"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/search"
+	"gorm.io/gorm"
+)
+
+// RegisterSubscribers 注册所有事件订阅者，需要在 bus.Run 之前调用
+// 搜索索引保存在每个实例本地，每个实例都需要处理所有事件，使用 Broadcast 投递
+// 实时推送本身经过 Redis，在发布事件的进程内处理
+// 提及、通知和第三方回调写入 MySQL，通过 Redis Streams 保证至少处理一次
+func RegisterSubscribers() {
+	// 搜索索引
+	bus.Subscribe("search", bus.Broadcast, func(ctx context.Context, event bus.UserRegistered) error {
+		search.Index(search.TypeUser, event.UserId, event.Name)
+		return nil
+	})
+	bus.Subscribe("search", bus.Broadcast, func(ctx context.Context, event bus.VideoPublished) error {
+		return reindexVideo(ctx, event.VideoId)
+	})
+	bus.Subscribe("search", bus.Broadcast, func(ctx context.Context, event bus.VideoUpdated) error {
+		return reindexVideo(ctx, event.VideoId)
+	})
+	bus.Subscribe("search", bus.Broadcast, func(ctx context.Context, event bus.VideoDeleted) error {
+		search.Remove(search.TypeVideo, event.VideoId)
+		return nil
+	})
+	// 提及，视频发布后才记录标题中的提及
+	bus.Subscribe("mention", bus.AtLeastOnce, func(ctx context.Context, event bus.VideoPublished) error {
+		video, err := cache.ReadVideo(ctx, event.VideoId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return addMentions(ctx, video.UserId, video.Id, 0, dal.DecodeMentions(video.MentionData))
+	})
+	bus.Subscribe("mention", bus.AtLeastOnce, func(ctx context.Context, event bus.CommentAdded) error {
+		comment, err := cache.ReadComment(ctx, event.CommentId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return addMentions(ctx, comment.UserId, comment.VideoId, comment.Id, comment.Mentions)
+	})
+	// 通知
+	bus.Subscribe("notification", bus.AtLeastOnce, func(ctx context.Context, event bus.VideoFavorited) error {
+		return deliverNotification(ctx, dal.Notification{
+			Type:          dal.NotificationLike,
+			VideoId:       event.VideoId,
+			LatestActorId: event.UserId,
+		})
+	})
+	bus.Subscribe("notification", bus.AtLeastOnce, func(ctx context.Context, event bus.CommentAdded) error {
+		return deliverNotification(ctx, dal.Notification{
+			Type:          dal.NotificationComment,
+			VideoId:       event.VideoId,
+			CommentId:     event.CommentId,
+			LatestActorId: event.UserId,
+		})
+	})
+	bus.Subscribe("notification", bus.AtLeastOnce, func(ctx context.Context, event bus.UserFollowed) error {
+		return deliverNotification(ctx, dal.Notification{
+			UserId:        event.ToUserId,
+			Type:          dal.NotificationFollow,
+			LatestActorId: event.UserId,
+		})
+	})
+	// 第三方回调，视频相关的事件只推送所有人可见的视频
+	bus.Subscribe("webhook", bus.AtLeastOnce, func(ctx context.Context, event bus.VideoPublished) error {
+		return enqueueVideoWebhook(ctx, event.VideoId, event)
+	})
+	bus.Subscribe("webhook", bus.AtLeastOnce, func(ctx context.Context, event bus.VideoFavorited) error {
+		return enqueueVideoWebhook(ctx, event.VideoId, event)
+	})
+	bus.Subscribe("webhook", bus.AtLeastOnce, func(ctx context.Context, event bus.UserFollowed) error {
+		return enqueueWebhook(ctx, event)
+	})
+	// 实时推送私信和未读数
+	bus.Subscribe("push", bus.InProcess, func(ctx context.Context, event bus.MessageSent) error {
+		message, err := cache.ReadMessage(ctx, event.MessageId)
+		if err != nil {
+			return err
+		}
+		// 推送给接收方，同时同步到发送方的其他设备
+		pushEvent(ctx, message.ToUserId, EventMessage, message)
+		pushEvent(ctx, message.FromUserId, EventMessage, message)
+		pushUnreadCount(ctx, message.ToUserId)
+		return nil
+	})
+	bus.Subscribe("push", bus.InProcess, func(ctx context.Context, event bus.ConversationRead) error {
+		pushUnreadCount(ctx, event.UserId)
+		return nil
+	})
+	bus.Subscribe("push", bus.InProcess, func(ctx context.Context, event bus.NotificationsRead) error {
+		pushUnreadCount(ctx, event.UserId)
+		return nil
+	})
+}
+
+// reindexVideo 读取视频的最新信息后重新加入搜索索引，并将视频关联的话题加入搜索索引
+func reindexVideo(ctx context.Context, videoId int64) error {
+	video, err := cache.ReadVideo(ctx, videoId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		search.Remove(search.TypeVideo, videoId)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	indexVideo(video)
+	topicIdList, err := dal.GetTopicIdsByVideoId(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	topicList, err := dal.GetTopicsByIds(ctx, topicIdList)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topicList {
+		search.Index(search.TypeTopic, topic.Id, topic.Name)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// topicRegexp 匹配 #话题，话题名由字母、数字和下划线组成
+var topicRegexp = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+
+// parseTopics 解析文本中的 #话题，英文统一转为小写，去重后最多保留 MaxVideoTopics 个
+// 超出 MaxTopicNameLen 的话题名按纯文本处理
+func parseTopics(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range topicRegexp.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(match[1])
+		if utf8.RuneCountInString(name) > config.MaxTopicNameLen || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) >= config.MaxVideoTopics {
+			break
+		}
+	}
+	return names
+}
+
+// setVideoTopics 设置视频关联的话题，失败不影响原操作
+// 话题在处理视频发布、修改事件时加入各实例的搜索索引
+func setVideoTopics(ctx context.Context, video dal.Video, title string) {
+	if err := cache.SetVideoTopics(ctx, video, parseTopics(title)); err != nil {
+		log.Println(err)
+	}
+}
+
+type TopicVideoListResponse struct {
+	Response
+	Topic        dal.Topic   `json:"topic"`
+	VideoList    []dal.Video `json:"video_list"`
+	NextCursor   int64       `json:"next_cursor"`
+	NextCursorId int64       `json:"next_cursor_id"`
+	HasMore      bool        `json:"has_more"`
+}
+
+// TopicVideoList 话题页，分页获取话题下的视频
+func TopicVideoList(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	name := strings.ToLower(strings.TrimPrefix(c.Query("topic_name"), "#"))
+	topic, err := dal.GetTopicByName(ctx, name)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, TopicVideoListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "话题不存在"},
+		})
+		return
+	}
+	// 排序方式为 newest（默认）或 hot，cursor、cursor_id 为上一页返回的 next_cursor、next_cursor_id
+	sortType := c.Query("sort")
+	cursor := cache.Cursor{Score: util.QueryId(c, "cursor"), Id: util.QueryId(c, "cursor_id")}
+	count := int(util.QueryId(c, "count"))
+	if count <= 0 || count > config.TopicPageSize {
+		count = config.TopicPageSize
+	}
+	videoList, nextCursor, hasMore, err := cache.ReadTopicVideoList(ctx, userId, topic.Id, sortType, cursor, count)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, TopicVideoListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取话题视频失败"},
+		})
+		return
+	}
+	if userId != 0 { // 用户已登录，则需要进一步查询点赞信息和关注信息
+		for i, video := range videoList {
+			videoList[i].IsFavorite, err = cache.ReadFavorite(ctx, userId, video.Id)
+			if err == nil {
+				videoList[i].Author.IsFollow, err = cache.ReadRelation(ctx, userId, video.UserId)
+			}
+			if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusOK, TopicVideoListResponse{
+					Response: Response{StatusCode: StatusFailed, StatusMsg: "获取话题视频失败"},
+				})
+				return
+			}
+		}
+	}
+	c.JSON(http.StatusOK, TopicVideoListResponse{
+		Response:     Response{StatusCode: StatusSuccess},
+		Topic:        topic,
+		VideoList:    videoList,
+		NextCursor:   nextCursor.Score,
+		NextCursorId: nextCursor.Id,
+		HasMore:      hasMore,
+	})
+}
+
+type TopicListResponse struct {
+	Response
+	TopicList []dal.Topic `json:"topic_list"`
+}
+
+// TrendingTopic 获取热门话题
+func TrendingTopic(c *gin.Context) {
+	ctx := c.Request.Context()
+	topicList, err := cache.ReadTrendingTopics(ctx)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, TopicListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取热门话题失败"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, TopicListResponse{
+		Response:  Response{StatusCode: StatusSuccess},
+		TopicList: topicList,
+	})
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
 	"github.com/zenpk/mini-douyin-ex/cache"
 	"github.com/zenpk/mini-douyin-ex/dal"
 	"github.com/zenpk/mini-douyin-ex/util"
@@ -21,10 +22,11 @@ type UserResponse struct {
 }
 
 func Register(c *gin.Context) {
+	ctx := c.Request.Context()
 	username := c.Query("username")
 	password := c.Query("password")
 	// 调用数据层函数
-	if user, err := dal.Register(username, password); err != nil {
+	if user, err := dal.Users.Register(ctx, username, password); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, UserLoginResponse{
 			Response: Response{StatusCode: StatusFailed, StatusMsg: "注册失败"},
@@ -38,8 +40,9 @@ func Register(c *gin.Context) {
 				Response: Response{StatusCode: StatusFailed, StatusMsg: "token 生成失败"},
 			})
 		} else {
-			// 用户注册后，将用户信息写入缓存
-			if err := cache.RegisterLoginUser(user); err != nil {
+			// 用户注册后，将用户名加入搜索索引，并将用户信息写入缓存
+			bus.Publish(ctx, bus.UserRegistered{UserId: user.Id, Name: user.Name})
+			if err := cache.RegisterLoginUser(ctx, user); err != nil {
 				log.Println(err)
 				c.JSON(http.StatusOK, UserLoginResponse{
 					Response: Response{StatusCode: StatusFailed, StatusMsg: "注册失败"},
@@ -56,9 +59,10 @@ func Register(c *gin.Context) {
 }
 
 func Login(c *gin.Context) {
+	ctx := c.Request.Context()
 	username := c.Query("username")
 	password := c.Query("password")
-	if user, err := dal.Login(username, password); err != nil {
+	if user, err := dal.Users.Login(ctx, username, password); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, UserLoginResponse{
 			Response: Response{StatusCode: StatusFailed, StatusMsg: "登录失败"},
@@ -71,7 +75,7 @@ func Login(c *gin.Context) {
 			})
 		} else {
 			// 用户登录后，将用户信息写入缓存
-			if err := cache.RegisterLoginUser(user); err != nil {
+			if err := cache.RegisterLoginUser(ctx, user); err != nil {
 				log.Println(err)
 				c.JSON(http.StatusOK, UserLoginResponse{
 					Response: Response{StatusCode: StatusFailed, StatusMsg: "token 生成失败"},
@@ -89,10 +93,11 @@ func Login(c *gin.Context) {
 
 // UserInfo 查看某用户的信息
 func UserInfo(c *gin.Context) {
+	ctx := c.Request.Context()
 	userAId := util.GetTokenUserId(c) // token 中包含的 id 是当前登录用户的 id
 	userBId := util.QueryUserId(c)    // 请求中的 user_id 是查看用户信息的 id
 	// 先查用户
-	userB, err := cache.ReadUser(userBId)
+	userB, err := cache.ReadUser(ctx, userBId)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, UserResponse{
@@ -101,7 +106,7 @@ func UserInfo(c *gin.Context) {
 		return
 	}
 	// 再查是否关注
-	userB.IsFollow, err = cache.ReadRelation(userAId, userBId)
+	userB.IsFollow, err = cache.ReadRelation(ctx, userAId, userBId)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusOK, UserResponse{
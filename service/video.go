@@ -0,0 +1,92 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type VideoResponse struct {
+	Response
+	Video       dal.Video     `json:"video"`
+	CommentList []dal.Comment `json:"comment_list"`
+}
+
+// VideoDetail 获取单个视频的详细信息，用于分享链接和通知跳转
+// 包括作者信息、点赞和关注信息以及第一页评论，无权限查看或已删除的视频均视为不存在
+func VideoDetail(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	video, err := cache.ReadVisibleVideo(ctx, userId, videoId)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, VideoResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "视频不存在"},
+		})
+		return
+	}
+	if userId != 0 { // 用户已登录，则需要进一步查询点赞信息和关注信息
+		if video.IsFavorite, err = cache.ReadFavorite(ctx, userId, video.Id); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusOK, VideoResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "获取视频失败"},
+			})
+			return
+		}
+		if video.Author.IsFollow, err = cache.ReadRelation(ctx, userId, video.Author.Id); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusOK, VideoResponse{
+				Response: Response{StatusCode: StatusFailed, StatusMsg: "获取视频失败"},
+			})
+			return
+		}
+	}
+	// 只返回第一页评论
+	commentList, _, _, err := cache.ReadCommentList(ctx, videoId, dal.CommentSortNewest, cache.Cursor{}, config.CommentPageSize)
+	if err == nil && userId != 0 {
+		err = cache.FillCommentLike(ctx, userId, commentList)
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, VideoResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取视频失败"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, VideoResponse{
+		Response:    Response{StatusCode: StatusSuccess},
+		Video:       video,
+		CommentList: commentList,
+	})
+}
+
+// PlayVideo 上报一次播放，同一用户（未登录时为 IP）在去重窗口内重复播放只计一次
+// 未登录时不使用客户端上报的设备 id，否则更换设备 id 即可刷播放量
+func PlayVideo(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := util.GetTokenUserId(c)
+	videoId := util.QueryId(c, "video_id")
+	if _, err := cache.ReadVisibleVideo(ctx, userId, videoId); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "视频不存在")
+		return
+	}
+	var viewer string
+	if userId != 0 {
+		viewer = "user:" + strconv.FormatInt(userId, 10)
+	} else {
+		viewer = "ip:" + c.ClientIP()
+	}
+	if _, err := cache.AddPlay(ctx, videoId, viewer); err != nil {
+		log.Println(err)
+		ResponseFailed(c, "播放记录失败")
+		return
+	}
+	ResponseSuccess(c, "")
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/zenpk/mini-douyin-ex/bus"
+	"github.com/zenpk/mini-douyin-ex/cache"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/util"
+	"github.com/zenpk/mini-douyin-ex/webhook"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookEvents 允许第三方订阅的事件
+var webhookEvents = map[string]bool{
+	bus.VideoPublished{}.EventName(): true,
+	bus.VideoFavorited{}.EventName(): true,
+	bus.UserFollowed{}.EventName():   true,
+}
+
+// WebhookPayload 回调请求的 JSON 内容，data 为事件本身
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	CreatedAt int64     `json:"created_at"`
+	Data      bus.Event `json:"data"`
+}
+
+// enqueueWebhook 为订阅了该事件的回调创建投递，由 sendWebhooks 发送
+// 事件重复投递时会重复创建，接收方可以根据事件内容去重
+func enqueueWebhook(ctx context.Context, event bus.Event) error {
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     event.EventName(),
+		CreatedAt: time.Now().UnixMilli(),
+		Data:      event,
+	})
+	if err != nil {
+		return err
+	}
+	return dal.AddWebhookDeliveries(ctx, event.EventName(), payload)
+}
+
+// enqueueVideoWebhook 视频对未登录用户可见时才创建投递，第三方不应获知私密或仅好友可见的视频
+// 视频已删除或不可见时直接跳过
+func enqueueVideoWebhook(ctx context.Context, videoId int64, event bus.Event) error {
+	if _, err := cache.ReadVisibleVideo(ctx, 0, videoId); errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return enqueueWebhook(ctx, event)
+}
+
+// sendWebhooks 并发发送已到期的投递，每次尝试的结果都记录在投递日志中
+func sendWebhooks(ctx context.Context) error {
+	deliveryList, err := dal.GetDueWebhookDeliveries(ctx, config.WebhookBatchSize)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveryList {
+		claimed, err := dal.ClaimWebhookDelivery(ctx, &delivery)
+		if err != nil {
+			return err
+		}
+		if !claimed { // 已被其他实例认领
+			continue
+		}
+		wg.Add(1)
+		go func(delivery dal.WebhookDelivery) {
+			defer wg.Done()
+			if err := sendWebhook(ctx, delivery); err != nil {
+				log.Println(err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return nil
+}
+
+// sendWebhook 发送一次投递并记录结果
+func sendWebhook(ctx context.Context, delivery dal.WebhookDelivery) error {
+	hook, err := dal.GetWebhookById(ctx, delivery.WebhookId)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	code, body, cause := webhook.Send(ctx, hook.Url, hook.Secret, delivery.EventType, delivery.Id, []byte(delivery.Payload))
+	delivery.ResponseCode = code
+	delivery.ResponseBody = body
+	delivery.Duration = time.Since(start).Milliseconds()
+	disabled, err := dal.FinishWebhookDelivery(ctx, delivery, cause)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		log.Println("回调连续失败，已停用：" + strconv.FormatInt(hook.Id, 10) + " " + hook.Url)
+	}
+	return nil
+}
+
+// webhookFields 检查回调地址和事件类型，无效时 ok 为 false，msg 为提示信息
+// 事件类型为逗号分隔的字符串，返回去重后的结果
+func webhookFields(rawUrl, rawEvents string) (string, string, bool, string) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(u.String()) > 512 {
+		return "", "", false, "无效的回调地址"
+	}
+	var eventList []string
+	seen := make(map[string]bool)
+	for _, event := range strings.Split(rawEvents, ",") {
+		event = strings.TrimSpace(event)
+		if event == "" || seen[event] {
+			continue
+		}
+		if !webhookEvents[event] {
+			return "", "", false, "不支持的事件类型：" + event
+		}
+		seen[event] = true
+		eventList = append(eventList, event)
+	}
+	if len(eventList) == 0 {
+		return "", "", false, "请至少订阅一种事件"
+	}
+	return u.String(), strings.Join(eventList, ","), true, ""
+}
+
+const (
+	ActionAddWebhook    = 1
+	ActionDeleteWebhook = 2
+	ActionEditWebhook   = 3
+)
+
+type WebhookResponse struct {
+	Response
+	Webhook dal.Webhook `json:"webhook"`
+	Secret  string      `json:"secret,omitempty"` // 只在创建时返回，用于校验签名
+}
+
+type WebhookListResponse struct {
+	Response
+	WebhookList []dal.Webhook `json:"webhook_list"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Response
+	DeliveryList []dal.WebhookDelivery `json:"delivery_list"`
+	NextCursor   int64                 `json:"next_cursor"`
+	HasMore      bool                  `json:"has_more"`
+}
+
+// WebhookAction 创建、删除、修改回调订阅
+// url 为回调地址，event_types 为逗号分隔的事件类型，修改时 enabled 为 1 启用，为 0 停用
+func WebhookAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	action := c.Query("action_type")
+	actionType, err := strconv.Atoi(action)
+	if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作出错")
+		return
+	}
+	webhookId := util.QueryId(c, "webhook_id")
+	switch actionType {
+	case ActionAddWebhook:
+		hookUrl, eventTypes, ok, msg := webhookFields(c.Query("url"), c.Query("event_types"))
+		if !ok {
+			ResponseFailed(c, msg)
+			return
+		}
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			log.Println(err)
+			ResponseFailed(c, "创建失败")
+			return
+		}
+		hook, err := dal.AddWebhook(ctx, dal.Webhook{Url: hookUrl, EventTypes: eventTypes, Secret: secret})
+		if err != nil {
+			log.Println(err)
+			ResponseFailed(c, "创建失败")
+			return
+		}
+		c.JSON(http.StatusOK, WebhookResponse{
+			Response: Response{StatusCode: StatusSuccess, StatusMsg: "创建成功"},
+			Webhook:  hook,
+			Secret:   secret,
+		})
+	case ActionDeleteWebhook:
+		if err := dal.DeleteWebhook(ctx, webhookId); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "删除失败")
+		} else {
+			ResponseSuccess(c, "删除成功")
+		}
+	case ActionEditWebhook:
+		hook, err := dal.GetWebhookById(ctx, webhookId)
+		if err != nil {
+			log.Println(err)
+			ResponseFailed(c, "回调不存在")
+			return
+		}
+		// 未传入的字段保持不变
+		rawUrl, rawEvents := hook.Url, hook.EventTypes
+		if c.Query("url") != "" {
+			rawUrl = c.Query("url")
+		}
+		if c.Query("event_types") != "" {
+			rawEvents = c.Query("event_types")
+		}
+		hookUrl, eventTypes, ok, msg := webhookFields(rawUrl, rawEvents)
+		if !ok {
+			ResponseFailed(c, msg)
+			return
+		}
+		hook.Url, hook.EventTypes = hookUrl, eventTypes
+		switch c.Query("enabled") {
+		case "":
+		case "0":
+			hook.Enabled = false
+		case "1":
+			hook.Enabled = true
+		default:
+			ResponseFailed(c, "无效的启用状态")
+			return
+		}
+		if err := dal.EditWebhook(ctx, hook); err != nil {
+			log.Println(err)
+			ResponseFailed(c, "修改失败")
+		} else {
+			ResponseSuccess(c, "修改成功")
+		}
+	default:
+		ResponseFailed(c, "不支持的操作")
+	}
+}
+
+// WebhookList 获取所有回调订阅
+func WebhookList(c *gin.Context) {
+	ctx := c.Request.Context()
+	webhookList, err := dal.GetWebhookList(ctx)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, WebhookListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取回调列表失败"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, WebhookListResponse{
+		Response:    Response{StatusCode: StatusSuccess},
+		WebhookList: webhookList,
+	})
+}
+
+// WebhookDeliveryList 按时间倒序分页获取回调的投递日志，cursor 为上一页返回的 next_cursor，首页为 0
+func WebhookDeliveryList(c *gin.Context) {
+	ctx := c.Request.Context()
+	webhookId := util.QueryId(c, "webhook_id")
+	cursor := util.QueryId(c, "cursor")
+	// 多读一条用于判断是否还有下一页
+	deliveryList, err := dal.GetWebhookDeliveryList(ctx, webhookId, cursor, config.WebhookPageSize+1)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusOK, WebhookDeliveryListResponse{
+			Response: Response{StatusCode: StatusFailed, StatusMsg: "获取投递日志失败"},
+		})
+		return
+	}
+	hasMore := len(deliveryList) > config.WebhookPageSize
+	if hasMore {
+		deliveryList = deliveryList[:config.WebhookPageSize]
+	}
+	nextCursor := cursor
+	if len(deliveryList) > 0 {
+		nextCursor = deliveryList[len(deliveryList)-1].Id
+	}
+	c.JSON(http.StatusOK, WebhookDeliveryListResponse{
+		Response:     Response{StatusCode: StatusSuccess},
+		DeliveryList: deliveryList,
+		NextCursor:   nextCursor,
+		HasMore:      hasMore,
+	})
+}
+
+// RedeliverWebhook 重新发送已成功或已失败的投递
+func RedeliverWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+	deliveryId := util.QueryId(c, "delivery_id")
+	if err := dal.RedeliverWebhook(ctx, deliveryId); errors.Is(err, gorm.ErrRecordNotFound) {
+		ResponseFailed(c, "投递不存在或正在发送")
+	} else if err != nil {
+		log.Println(err)
+		ResponseFailed(c, "操作失败")
+	} else {
+		ResponseSuccess(c, "操作成功")
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"github.com/zenpk/mini-douyin-ex/dal"
+	"github.com/zenpk/mini-douyin-ex/webhook"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver 测试用的接收方，status 为返回的状态码，可以在测试中修改
+type webhookReceiver struct {
+	server   *httptest.Server
+	status   int32
+	requests int32
+	header   atomic.Value // 最后一次请求的 http.Header
+	body     atomic.Value // 最后一次请求的内容
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	receiver := &webhookReceiver{status: int32(status)}
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.header.Store(r.Header.Clone())
+		receiver.body.Store(body)
+		atomic.AddInt32(&receiver.requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&receiver.status)))
+		w.Write([]byte("received"))
+	}))
+	t.Cleanup(receiver.server.Close)
+	return receiver
+}
+
+// setupWebhookTest 使用临时的 SQLite 数据库，并创建一个指向 receiver 的回调
+func setupWebhookTest(t *testing.T, receiver *webhookReceiver) dal.Webhook {
+	mode, file := config.Mode, config.SQLiteFile
+	config.Mode = config.ModeDev
+	config.SQLiteFile = filepath.Join(t.TempDir(), "douyin.db")
+	t.Cleanup(func() {
+		config.Mode, config.SQLiteFile = mode, file
+		if db, err := dal.DB.DB(); err == nil {
+			db.Close()
+		}
+	})
+	if err := dal.ConnectDB(); err != nil {
+		t.Fatal(err)
+	}
+	hook, err := dal.AddWebhook(context.Background(), dal.Webhook{Url: receiver.server.URL, EventTypes: "video_published", Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// enqueueTestDelivery 创建一条投递并返回
+func enqueueTestDelivery(t *testing.T) dal.WebhookDelivery {
+	if err := dal.AddWebhookDeliveries(context.Background(), "video_published", []byte(`{"event":"video_published"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var delivery dal.WebhookDelivery
+	if err := dal.DB.Order("id desc").Take(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+// sendDueWebhooks 将所有待发送的投递设为已到期后发送一次，模拟等待重试间隔
+func sendDueWebhooks(t *testing.T) {
+	if err := dal.DB.Model(&dal.WebhookDelivery{}).Where("status = ?", dal.DeliveryPending).
+		Update("next_run_at", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := sendWebhooks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func reloadDelivery(t *testing.T, deliveryId int64) dal.WebhookDelivery {
+	var delivery dal.WebhookDelivery
+	if err := dal.DB.Where("id = ?", deliveryId).Take(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestSendWebhookSigned(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	setupWebhookTest(t, receiver)
+	delivery := enqueueTestDelivery(t)
+
+	sendDueWebhooks(t)
+	delivery = reloadDelivery(t, delivery.Id)
+	if delivery.Status != dal.DeliverySuccess || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusOK || delivery.ResponseBody != "received" {
+		t.Fatalf("投递记录为 %+v", delivery)
+	}
+	header := receiver.header.Load().(http.Header)
+	if header.Get(webhook.HeaderEvent) != "video_published" || header.Get(webhook.HeaderDelivery) != strconv.FormatInt(delivery.Id, 10) {
+		t.Fatalf("请求头为 %v", header)
+	}
+	timestamp, err := strconv.ParseInt(header.Get(webhook.HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !webhook.Verify("secret", timestamp, receiver.body.Load().([]byte), header.Get(webhook.HeaderSignature)) {
+		t.Fatal("签名校验失败")
+	}
+	// 已成功的投递不再发送
+	sendDueWebhooks(t)
+	if n := atomic.LoadInt32(&receiver.requests); n != 1 {
+		t.Fatalf("发送了 %d 次", n)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	setupWebhookTest(t, receiver)
+	delivery := enqueueTestDelivery(t)
+
+	// 第 n 次失败后等待 WebhookRetryBase * 2^(n-1)
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		sendDueWebhooks(t)
+		after := time.Now()
+		delivery = reloadDelivery(t, delivery.Id)
+		if delivery.Status != dal.DeliveryPending || delivery.Attempts != attempt {
+			t.Fatalf("第 %d 次失败后投递记录为 %+v", attempt, delivery)
+		}
+		if delivery.ResponseCode != http.StatusInternalServerError || delivery.LastError == "" {
+			t.Fatalf("第 %d 次失败后没有记录错误：%+v", attempt, delivery)
+		}
+		backoff := config.WebhookRetryBase << (attempt - 1)
+		if delivery.NextRunAt < before.Add(backoff).UnixMilli() || delivery.NextRunAt > after.Add(backoff).UnixMilli() {
+			t.Fatalf("第 %d 次失败后的重试间隔为 %dms，应为 %v", attempt, delivery.NextRunAt-before.UnixMilli(), backoff)
+		}
+	}
+	// 未到期的投递不会发送
+	if err := sendWebhooks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&receiver.requests); n != 3 {
+		t.Fatalf("发送了 %d 次", n)
+	}
+}
+
+func TestWebhookFailedAfterMaxAttempts(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	setupWebhookTest(t, receiver)
+	delivery := enqueueTestDelivery(t)
+
+	for i := 0; i < config.WebhookMaxAttempts; i++ {
+		sendDueWebhooks(t)
+	}
+	delivery = reloadDelivery(t, delivery.Id)
+	if delivery.Status != dal.DeliveryFailed || delivery.Attempts != config.WebhookMaxAttempts || delivery.LastError == "" {
+		t.Fatalf("投递记录为 %+v", delivery)
+	}
+	// 已失败的投递不再发送
+	sendDueWebhooks(t)
+	if n := atomic.LoadInt32(&receiver.requests); n != config.WebhookMaxAttempts {
+		t.Fatalf("发送了 %d 次，应为 %d 次", n, config.WebhookMaxAttempts)
+	}
+}
+
+func TestWebhookAutoDisable(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	hook := setupWebhookTest(t, receiver)
+
+	// 成功一次即清零连续失败次数
+	enqueueTestDelivery(t)
+	sendDueWebhooks(t)
+	atomic.StoreInt32(&receiver.status, http.StatusOK)
+	enqueueTestDelivery(t)
+	sendDueWebhooks(t)
+	hook, err := dal.GetWebhookById(context.Background(), hook.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hook.Enabled || hook.FailureCount != 0 {
+		t.Fatalf("成功后回调为 %+v", hook)
+	}
+
+	atomic.StoreInt32(&receiver.status, http.StatusInternalServerError)
+	for i := 0; i < config.WebhookMaxFailures; i++ {
+		enqueueTestDelivery(t)
+	}
+	for i := 0; i < config.WebhookMaxFailures && hook.Enabled; i++ {
+		sendDueWebhooks(t)
+		if hook, err = dal.GetWebhookById(context.Background(), hook.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hook.Enabled || hook.FailureCount < config.WebhookMaxFailures {
+		t.Fatalf("连续失败 %d 次后回调为 %+v", config.WebhookMaxFailures, hook)
+	}
+	// 停用后不再发送，也不再创建新的投递
+	sent := atomic.LoadInt32(&receiver.requests)
+	enqueueTestDelivery(t)
+	sendDueWebhooks(t)
+	if n := atomic.LoadInt32(&receiver.requests); n != sent {
+		t.Fatalf("停用后又发送了 %d 次", n-sent)
+	}
+}
+
+func TestRedeliverWebhook(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	setupWebhookTest(t, receiver)
+	delivery := enqueueTestDelivery(t)
+
+	// 等待发送或重试中的投递不能重新投递
+	if err := dal.RedeliverWebhook(context.Background(), delivery.Id); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("重新投递待发送的投递返回 %v", err)
+	}
+	for i := 0; i < config.WebhookMaxAttempts; i++ {
+		sendDueWebhooks(t)
+	}
+	if delivery = reloadDelivery(t, delivery.Id); delivery.Status != dal.DeliveryFailed {
+		t.Fatalf("投递记录为 %+v", delivery)
+	}
+
+	atomic.StoreInt32(&receiver.status, http.StatusOK)
+	if err := dal.RedeliverWebhook(context.Background(), delivery.Id); err != nil {
+		t.Fatal(err)
+	}
+	delivery = reloadDelivery(t, delivery.Id)
+	if delivery.Status != dal.DeliveryPending || delivery.Attempts != 0 {
+		t.Fatalf("重新投递后投递记录为 %+v", delivery)
+	}
+	// 重新投递的记录立即到期，不需要等待
+	if err := sendWebhooks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	delivery = reloadDelivery(t, delivery.Id)
+	if delivery.Status != dal.DeliverySuccess || delivery.Attempts != 1 || delivery.LastError != "" {
+		t.Fatalf("重新投递后投递记录为 %+v", delivery)
+	}
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 回调请求头
+const (
+	HeaderEvent     = "X-Douyin-Event"     // 事件类型
+	HeaderDelivery  = "X-Douyin-Delivery"  // 投递 id，重试时不变，接收方可以据此去重
+	HeaderTimestamp = "X-Douyin-Timestamp" // 发送时的秒级时间戳，参与签名，接收方可以拒绝过旧的请求
+	HeaderSignature = "X-Douyin-Signature" // 签名，格式为 sha256=<十六进制>
+)
+
+var client = &http.Client{Timeout: config.WebhookTimeout}
+
+// NewSecret 生成随机的签名密钥
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Sign 计算签名，签名内容为 "<timestamp>.<body>"，使用 HMAC-SHA256
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名，供接收方使用
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Send 发送一次回调，返回状态码和截断到 WebhookResponseLimit 的响应内容
+// 请求失败或状态码不是 2xx 时返回错误
+func Send(ctx context.Context, url, secret, eventType string, deliveryId int64, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(deliveryId, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, config.WebhookResponseLimit))
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(respBody), errors.New("回调返回状态码 " + strconv.Itoa(resp.StatusCode))
+	}
+	return resp.StatusCode, string(respBody), nil
+}
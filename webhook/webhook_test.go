@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"context"
+	"github.com/zenpk/mini-douyin-ex/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"event":"video_published"}`)
+	signature := Sign("secret", 1700000000, body)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("签名格式错误：%s", signature)
+	}
+	if !Verify("secret", 1700000000, body, signature) {
+		t.Fatal("正确的签名校验失败")
+	}
+	if Verify("other", 1700000000, body, signature) {
+		t.Fatal("密钥不同时签名校验通过")
+	}
+	if Verify("secret", 1700000001, body, signature) {
+		t.Fatal("时间戳不同时签名校验通过")
+	}
+	if Verify("secret", 1700000000, []byte(`{"event":"user_followed"}`), signature) {
+		t.Fatal("内容不同时签名校验通过")
+	}
+}
+
+func TestSendHeaders(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	payload := []byte(`{"event":"video_published"}`)
+	before := time.Now().Unix()
+	code, respBody, err := Send(context.Background(), server.URL, "secret", "video_published", 42, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || respBody != "ok" {
+		t.Fatalf("响应为 %d %q", code, respBody)
+	}
+	if string(body) != string(payload) {
+		t.Fatalf("请求内容为 %s", body)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type 为 %q", got)
+	}
+	if got := header.Get(HeaderEvent); got != "video_published" {
+		t.Fatalf("%s 为 %q", HeaderEvent, got)
+	}
+	if got := header.Get(HeaderDelivery); got != "42" {
+		t.Fatalf("%s 为 %q", HeaderDelivery, got)
+	}
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().Unix() {
+		t.Fatalf("%s 为 %q", HeaderTimestamp, header.Get(HeaderTimestamp))
+	}
+	if !Verify("secret", timestamp, body, header.Get(HeaderSignature)) {
+		t.Fatalf("%s 校验失败：%q", HeaderSignature, header.Get(HeaderSignature))
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(strings.Repeat("x", config.WebhookResponseLimit+100)))
+	}))
+	defer server.Close()
+
+	code, respBody, err := Send(context.Background(), server.URL, "secret", "video_published", 1, []byte("{}"))
+	if err == nil {
+		t.Fatal("状态码不是 2xx 时没有返回错误")
+	}
+	if code != http.StatusInternalServerError {
+		t.Fatalf("状态码为 %d", code)
+	}
+	if len(respBody) != config.WebhookResponseLimit {
+		t.Fatalf("响应内容长度为 %d，应截断到 %d", len(respBody), config.WebhookResponseLimit)
+	}
+}